@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/db/migrations"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/scheduler"
+)
+
+// runImport handles `receitago import <step>`, e.g. `receitago import cnpj
+// empresas`, running one import step of the pipeline. Returns the exit code.
+func runImport(ctx context.Context, cfg *config.Config, logger zerolog.Logger, args []string) int {
+	pool, err := database.NewPostgresPool(ctx, cfg, logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("❌ postgres connect failed")
+		return 1
+	}
+	defer pool.Close()
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
+	if err != nil {
+		logger.Error().Err(err).Msg("❌ mongo connect failed")
+		return 1
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	pipeline, err := scheduler.NewPipeline(cfg, pool, mongoClient, logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("❌ create pipeline failed")
+		return 1
+	}
+
+	var steps []string
+	for _, name := range pipeline.StepNames() {
+		if step, ok := strings.CutPrefix(name, "import "); ok {
+			steps = append(steps, step)
+		}
+	}
+	step := strings.Join(args, " ")
+	if step == "" || !slices.Contains(steps, step) {
+		fmt.Fprintf(os.Stderr, "usage: receitago import <step>\nsteps: %s\n", strings.Join(steps, ", "))
+		return 2
+	}
+
+	migrator, err := database.NewMigrator(pool, migrations.FS, logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("❌ load migrations failed")
+		return 1
+	}
+	if err := migrateOnStart(ctx, migrator, cfg, logger); err != nil {
+		logger.Error().Err(err).Msg("❌ database migrations failed")
+		return 1
+	}
+
+	if err := pipeline.RunStep(postgres.WithTrigger(ctx, "cli"), "import "+step); err != nil {
+		logger.Error().Err(err).Str("step", step).Msg("❌ import failed")
+		return 1
+	}
+	logger.Info().Str("step", step).Msg("✅ Import finished")
+	return 0
+}
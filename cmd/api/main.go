@@ -7,13 +7,15 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/db/migrations"
 	"github.com/BrunoGuimaraesSilva/receitago/internal/api"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+	mongoimport "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/mongo"
 )
 
 // @title ReceitaGo API
@@ -39,11 +41,26 @@ func main() {
 	ctx := context.Background()
 	cfg := config.Load()
 
-	pgConn, err := pgx.Connect(ctx, cfg.PostgresDSN)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(ctx, cfg, logger, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(ctx, cfg, logger, os.Args[2:]))
+	}
+
+	pgPool, err := database.NewPostgresPool(ctx, cfg, logger)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("❌ postgres connect failed")
 	}
-	defer pgConn.Close(ctx)
+	defer pgPool.Close()
+
+	migrator, err := database.NewMigrator(pgPool, migrations.FS, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("❌ load migrations failed")
+	}
+	if err := migrateOnStart(ctx, migrator, cfg, logger); err != nil {
+		logger.Fatal().Err(err).Msg("❌ database migrations failed")
+	}
 
 	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
 	if err != nil {
@@ -51,7 +68,12 @@ func main() {
 	}
 	defer mongoClient.Disconnect(ctx)
 
-	srv, err := api.NewServer(cfg, logger, pgConn, mongoClient)
+	if err := mongoimport.EnsureIndexes(ctx, mongoClient.Database(cfg.MongoDB)); err != nil {
+		// existing duplicates block unique indexes until the collections are reimported
+		logger.Error().Err(err).Msg("⚠️ mongo index creation failed")
+	}
+
+	srv, err := api.NewServer(cfg, logger, pgPool, mongoClient, migrator)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create server")
 	}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/db/migrations"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+)
+
+const migrateUsage = "usage: receitago migrate up | down [steps] | status"
+
+// runMigrate handles `receitago migrate ...` and returns the exit code.
+func runMigrate(ctx context.Context, cfg *config.Config, logger zerolog.Logger, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	pool, err := database.NewPostgresPool(ctx, cfg, logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("❌ postgres connect failed")
+		return 1
+	}
+	defer pool.Close()
+
+	migrator, err := database.NewMigrator(pool, migrations.FS, logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("❌ load migrations failed")
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			logger.Error().Err(err).Msg("❌ migrate up failed")
+			return 1
+		}
+		logger.Info().Int("applied", n).Msg("✅ Migrations applied")
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			logger.Error().Err(err).Msg("❌ migrate down failed")
+			return 1
+		}
+		logger.Info().Int("reverted", n).Msg("✅ Migrations reverted")
+	case "status":
+		st, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Error().Err(err).Msg("❌ migrate status failed")
+			return 1
+		}
+		fmt.Printf("version: %d\ndirty:   %t\nlatest:  %d\n", st.Version, st.Dirty, st.Latest)
+		for _, name := range st.Pending {
+			fmt.Println("pending:", name)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
+
+// migrateOnStart applies pending migrations, or with MIGRATE_ON_START=false
+// only checks that the schema is up to date.
+func migrateOnStart(ctx context.Context, migrator *database.Migrator, cfg *config.Config, logger zerolog.Logger) error {
+	if cfg.MigrateOnStart {
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info().Int("applied", n).Int64("version", migrator.Latest()).Msg("✅ Database schema up to date")
+		return nil
+	}
+	st, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.Dirty || len(st.Pending) > 0 {
+		return fmt.Errorf("schema at version %d (dirty: %t), expected %d: run `receitago migrate up`", st.Version, st.Dirty, st.Latest)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+-- Schema for Tesouro Nacional (SIAFI) CSV resources
+CREATE SCHEMA IF NOT EXISTS tesouro;
+
+-- One row per imported CSV; data tables are created by the importer from
+-- each file's header and reference the resource they were loaded from.
+-- columns and column_types record the schema each file was loaded with.
+CREATE TABLE tesouro.resources (
+    id BIGSERIAL PRIMARY KEY,
+    filename TEXT NOT NULL UNIQUE,
+    table_name TEXT NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    encoding VARCHAR(20) NOT NULL,
+    delimiter CHAR(1) NOT NULL,
+    columns TEXT[] NOT NULL,
+    column_types TEXT[] NOT NULL,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    imported_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_resources_table ON tesouro.resources(table_name);
@@ -0,0 +1,69 @@
+-- Core Receita CNPJ data. Code columns reference the dictionary ids, so
+-- they hold the prefixed id the dictionaries are keyed by.
+CREATE SCHEMA IF NOT EXISTS cnpj;
+
+CREATE TABLE cnpj.empresas (
+    cnpj_basico CHAR(8) PRIMARY KEY,
+    razao_social TEXT NOT NULL,
+    natureza_juridica BIGINT REFERENCES dictionaries.naturezas(id),
+    qualificacao_resp BIGINT REFERENCES dictionaries.qualificacoes(id),
+    capital_social NUMERIC(20, 2),
+    porte_empresa SMALLINT,
+    ente_federativo_resp TEXT
+);
+
+CREATE TABLE cnpj.estabelecimentos (
+    cnpj CHAR(14) PRIMARY KEY,
+    cnpj_basico CHAR(8) NOT NULL,
+    cnpj_ordem CHAR(4) NOT NULL,
+    cnpj_dv CHAR(2) NOT NULL,
+    matriz_filial SMALLINT,
+    nome_fantasia TEXT,
+    situacao_cadastral SMALLINT,
+    data_situacao DATE,
+    motivo_situacao BIGINT REFERENCES dictionaries.motivos(id),
+    nome_cidade_exterior TEXT,
+    pais BIGINT REFERENCES dictionaries.paises(id),
+    data_inicio_atividade DATE,
+    cnae_principal BIGINT REFERENCES dictionaries.cnaes(id),
+    cnaes_secundarios TEXT[] NOT NULL DEFAULT '{}',
+    tipo_logradouro TEXT,
+    logradouro TEXT,
+    numero TEXT,
+    complemento TEXT,
+    bairro TEXT,
+    cep TEXT,
+    uf CHAR(2),
+    municipio BIGINT REFERENCES dictionaries.municipios(id),
+    ddd1 TEXT,
+    telefone1 TEXT,
+    ddd2 TEXT,
+    telefone2 TEXT,
+    ddd_fax TEXT,
+    fax TEXT,
+    email TEXT,
+    situacao_especial TEXT,
+    data_situacao_especial DATE
+);
+
+CREATE INDEX idx_estabelecimentos_basico ON cnpj.estabelecimentos(cnpj_basico);
+CREATE INDEX idx_estabelecimentos_cnae ON cnpj.estabelecimentos(cnae_principal);
+CREATE INDEX idx_estabelecimentos_municipio ON cnpj.estabelecimentos(uf, municipio);
+
+CREATE TABLE cnpj.socios (
+    id BIGSERIAL PRIMARY KEY,
+    cnpj_basico CHAR(8) NOT NULL,
+    identificador_socio SMALLINT,
+    nome_socio TEXT,
+    cnpj_cpf_socio TEXT,
+    qualificacao_socio BIGINT REFERENCES dictionaries.qualificacoes(id),
+    data_entrada_sociedade DATE,
+    pais BIGINT REFERENCES dictionaries.paises(id),
+    cpf_representante_legal TEXT,
+    nome_representante_legal TEXT,
+    qualificacao_representante BIGINT REFERENCES dictionaries.qualificacoes(id),
+    faixa_etaria SMALLINT
+);
+
+CREATE INDEX idx_socios_basico ON cnpj.socios(cnpj_basico);
+CREATE INDEX idx_socios_documento ON cnpj.socios(cnpj_cpf_socio);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tributario.idx_regimes_dataset_year;
+ALTER TABLE tributario.regimes DROP CONSTRAINT IF EXISTS uq_regimes_natural_key;
@@ -0,0 +1,16 @@
+-- Reloads used to append, so collapse the duplicates before enforcing the
+-- natural key. Empty SCPs become NULL, which the key treats as equal.
+UPDATE tributario.regimes SET cnpj_da_scp = NULL WHERE cnpj_da_scp = '';
+
+DELETE FROM tributario.regimes a
+USING tributario.regimes b
+WHERE a.id > b.id
+  AND a.ano = b.ano
+  AND a.cnpj = b.cnpj
+  AND a.cnpj_da_scp IS NOT DISTINCT FROM b.cnpj_da_scp
+  AND a.dataset = b.dataset;
+
+ALTER TABLE tributario.regimes
+    ADD CONSTRAINT uq_regimes_natural_key UNIQUE NULLS NOT DISTINCT (ano, cnpj, cnpj_da_scp, dataset);
+
+CREATE INDEX idx_regimes_dataset_year ON tributario.regimes(dataset, ano);
@@ -0,0 +1,9 @@
+ALTER TABLE tributario.regimes ALTER COLUMN id DROP IDENTITY;
+CREATE SEQUENCE tributario.regimes_id_seq OWNED BY tributario.regimes.id;
+ALTER TABLE tributario.regimes ALTER COLUMN id SET DEFAULT nextval('tributario.regimes_id_seq');
+SELECT setval('tributario.regimes_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM tributario.regimes;
+
+ALTER TABLE cnpj.socios ALTER COLUMN id DROP IDENTITY;
+CREATE SEQUENCE cnpj.socios_id_seq OWNED BY cnpj.socios.id;
+ALTER TABLE cnpj.socios ALTER COLUMN id SET DEFAULT nextval('cnpj.socios_id_seq');
+SELECT setval('cnpj.socios_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM cnpj.socios;
@@ -0,0 +1,11 @@
+-- Reloads swap in a copy of the table, which can't share a serial sequence
+-- owned by the table it replaces; identity columns get their own.
+ALTER TABLE cnpj.socios ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE cnpj.socios_id_seq;
+ALTER TABLE cnpj.socios ALTER COLUMN id ADD GENERATED BY DEFAULT AS IDENTITY;
+SELECT setval(pg_get_serial_sequence('cnpj.socios', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM cnpj.socios;
+
+ALTER TABLE tributario.regimes ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE tributario.regimes_id_seq;
+ALTER TABLE tributario.regimes ALTER COLUMN id ADD GENERATED BY DEFAULT AS IDENTITY;
+SELECT setval(pg_get_serial_sequence('tributario.regimes', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM tributario.regimes;
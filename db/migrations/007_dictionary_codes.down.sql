@@ -0,0 +1,67 @@
+DO $$
+DECLARE fk record;
+BEGIN
+    FOR fk IN
+        SELECT conrelid::regclass AS tbl, conname FROM pg_constraint
+        WHERE contype = 'f' AND confrelid IN (SELECT oid FROM pg_class WHERE relnamespace = 'dictionaries'::regnamespace)
+    LOOP
+        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', fk.tbl, fk.conname);
+    END LOOP;
+END $$;
+
+DROP TABLE IF EXISTS cnpj.empresas_staging, cnpj.empresas_previous,
+    cnpj.estabelecimentos_staging, cnpj.estabelecimentos_previous,
+    cnpj.socios_staging, cnpj.socios_previous;
+
+ALTER TABLE cnpj.empresas
+    ALTER COLUMN natureza_juridica TYPE BIGINT USING ('1' || natureza_juridica)::bigint,
+    ALTER COLUMN qualificacao_resp TYPE BIGINT USING ('1' || qualificacao_resp)::bigint;
+
+ALTER TABLE cnpj.estabelecimentos
+    ALTER COLUMN motivo_situacao TYPE BIGINT USING ('1' || motivo_situacao)::bigint,
+    ALTER COLUMN pais TYPE BIGINT USING ('1' || pais)::bigint,
+    ALTER COLUMN cnae_principal TYPE BIGINT USING ('1' || cnae_principal)::bigint,
+    ALTER COLUMN municipio TYPE BIGINT USING ('1' || municipio)::bigint;
+
+ALTER TABLE cnpj.socios
+    ALTER COLUMN qualificacao_socio TYPE BIGINT USING ('1' || qualificacao_socio)::bigint,
+    ALTER COLUMN pais TYPE BIGINT USING ('1' || pais)::bigint,
+    ALTER COLUMN qualificacao_representante TYPE BIGINT USING ('1' || qualificacao_representante)::bigint;
+
+ALTER TABLE dictionaries.cnaes ALTER COLUMN code TYPE BIGINT USING ('1' || code)::bigint;
+ALTER TABLE dictionaries.cnaes RENAME COLUMN code TO id;
+
+ALTER TABLE dictionaries.motivos DROP COLUMN code_num;
+ALTER TABLE dictionaries.motivos ALTER COLUMN code TYPE BIGINT USING ('1' || code)::bigint;
+ALTER TABLE dictionaries.motivos RENAME COLUMN code TO id;
+
+ALTER TABLE dictionaries.qualificacoes DROP COLUMN code_num;
+ALTER TABLE dictionaries.qualificacoes ALTER COLUMN code TYPE BIGINT USING ('1' || code)::bigint;
+ALTER TABLE dictionaries.qualificacoes RENAME COLUMN code TO id;
+
+ALTER TABLE dictionaries.municipios DROP COLUMN code_num;
+ALTER TABLE dictionaries.municipios ALTER COLUMN code TYPE BIGINT USING ('1' || code)::bigint;
+ALTER TABLE dictionaries.municipios RENAME COLUMN code TO id;
+
+ALTER TABLE dictionaries.paises DROP COLUMN code_num;
+ALTER TABLE dictionaries.paises ALTER COLUMN code TYPE BIGINT USING ('1' || code)::bigint;
+ALTER TABLE dictionaries.paises RENAME COLUMN code TO id;
+
+ALTER TABLE dictionaries.naturezas DROP COLUMN code_num;
+ALTER TABLE dictionaries.naturezas ALTER COLUMN code TYPE BIGINT USING ('1' || code)::bigint;
+ALTER TABLE dictionaries.naturezas RENAME COLUMN code TO id;
+
+ALTER TABLE cnpj.empresas
+    ADD CONSTRAINT empresas_natureza_juridica_fkey FOREIGN KEY (natureza_juridica) REFERENCES dictionaries.naturezas(id),
+    ADD CONSTRAINT empresas_qualificacao_resp_fkey FOREIGN KEY (qualificacao_resp) REFERENCES dictionaries.qualificacoes(id);
+
+ALTER TABLE cnpj.estabelecimentos
+    ADD CONSTRAINT estabelecimentos_motivo_situacao_fkey FOREIGN KEY (motivo_situacao) REFERENCES dictionaries.motivos(id),
+    ADD CONSTRAINT estabelecimentos_pais_fkey FOREIGN KEY (pais) REFERENCES dictionaries.paises(id),
+    ADD CONSTRAINT estabelecimentos_cnae_principal_fkey FOREIGN KEY (cnae_principal) REFERENCES dictionaries.cnaes(id),
+    ADD CONSTRAINT estabelecimentos_municipio_fkey FOREIGN KEY (municipio) REFERENCES dictionaries.municipios(id);
+
+ALTER TABLE cnpj.socios
+    ADD CONSTRAINT socios_qualificacao_socio_fkey FOREIGN KEY (qualificacao_socio) REFERENCES dictionaries.qualificacoes(id),
+    ADD CONSTRAINT socios_pais_fkey FOREIGN KEY (pais) REFERENCES dictionaries.paises(id),
+    ADD CONSTRAINT socios_qualificacao_representante_fkey FOREIGN KEY (qualificacao_representante) REFERENCES dictionaries.qualificacoes(id);
@@ -0,0 +1,68 @@
+-- Dictionary ids were the Receita code prefixed with "1" to keep leading
+-- zeros in a BIGINT. Key the dictionaries by the code itself, as text, and
+-- store the same codes in the cnpj tables so they join with the raw data.
+
+-- Foreign keys are recreated below. Copies kept by reloads still hold the
+-- old ids and can't be rolled back to, so they go too.
+DO $$
+DECLARE fk record;
+BEGIN
+    FOR fk IN
+        SELECT conrelid::regclass AS tbl, conname FROM pg_constraint
+        WHERE contype = 'f' AND confrelid IN (SELECT oid FROM pg_class WHERE relnamespace = 'dictionaries'::regnamespace)
+    LOOP
+        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', fk.tbl, fk.conname);
+    END LOOP;
+END $$;
+
+DROP TABLE IF EXISTS cnpj.empresas_staging, cnpj.empresas_previous,
+    cnpj.estabelecimentos_staging, cnpj.estabelecimentos_previous,
+    cnpj.socios_staging, cnpj.socios_previous;
+
+ALTER TABLE dictionaries.cnaes RENAME COLUMN id TO code;
+ALTER TABLE dictionaries.cnaes ALTER COLUMN code TYPE TEXT USING substr(code::text, 2);
+
+-- CNAE codes are identifiers; the other dictionaries get a numeric form too
+ALTER TABLE dictionaries.motivos RENAME COLUMN id TO code;
+ALTER TABLE dictionaries.motivos ALTER COLUMN code TYPE TEXT USING substr(code::text, 2);
+ALTER TABLE dictionaries.motivos ADD COLUMN code_num INTEGER GENERATED ALWAYS AS (CASE WHEN code ~ '^[0-9]{1,9}$' THEN code::integer END) STORED;
+
+ALTER TABLE dictionaries.qualificacoes RENAME COLUMN id TO code;
+ALTER TABLE dictionaries.qualificacoes ALTER COLUMN code TYPE TEXT USING substr(code::text, 2);
+ALTER TABLE dictionaries.qualificacoes ADD COLUMN code_num INTEGER GENERATED ALWAYS AS (CASE WHEN code ~ '^[0-9]{1,9}$' THEN code::integer END) STORED;
+
+ALTER TABLE dictionaries.municipios RENAME COLUMN id TO code;
+ALTER TABLE dictionaries.municipios ALTER COLUMN code TYPE TEXT USING substr(code::text, 2);
+ALTER TABLE dictionaries.municipios ADD COLUMN code_num INTEGER GENERATED ALWAYS AS (CASE WHEN code ~ '^[0-9]{1,9}$' THEN code::integer END) STORED;
+
+ALTER TABLE dictionaries.paises RENAME COLUMN id TO code;
+ALTER TABLE dictionaries.paises ALTER COLUMN code TYPE TEXT USING substr(code::text, 2);
+ALTER TABLE dictionaries.paises ADD COLUMN code_num INTEGER GENERATED ALWAYS AS (CASE WHEN code ~ '^[0-9]{1,9}$' THEN code::integer END) STORED;
+
+ALTER TABLE dictionaries.naturezas RENAME COLUMN id TO code;
+ALTER TABLE dictionaries.naturezas ALTER COLUMN code TYPE TEXT USING substr(code::text, 2);
+ALTER TABLE dictionaries.naturezas ADD COLUMN code_num INTEGER GENERATED ALWAYS AS (CASE WHEN code ~ '^[0-9]{1,9}$' THEN code::integer END) STORED;
+
+ALTER TABLE cnpj.empresas
+    ALTER COLUMN natureza_juridica TYPE TEXT USING substr(natureza_juridica::text, 2),
+    ALTER COLUMN qualificacao_resp TYPE TEXT USING substr(qualificacao_resp::text, 2),
+    ADD CONSTRAINT empresas_natureza_juridica_fkey FOREIGN KEY (natureza_juridica) REFERENCES dictionaries.naturezas(code),
+    ADD CONSTRAINT empresas_qualificacao_resp_fkey FOREIGN KEY (qualificacao_resp) REFERENCES dictionaries.qualificacoes(code);
+
+ALTER TABLE cnpj.estabelecimentos
+    ALTER COLUMN motivo_situacao TYPE TEXT USING substr(motivo_situacao::text, 2),
+    ALTER COLUMN pais TYPE TEXT USING substr(pais::text, 2),
+    ALTER COLUMN cnae_principal TYPE TEXT USING substr(cnae_principal::text, 2),
+    ALTER COLUMN municipio TYPE TEXT USING substr(municipio::text, 2),
+    ADD CONSTRAINT estabelecimentos_motivo_situacao_fkey FOREIGN KEY (motivo_situacao) REFERENCES dictionaries.motivos(code),
+    ADD CONSTRAINT estabelecimentos_pais_fkey FOREIGN KEY (pais) REFERENCES dictionaries.paises(code),
+    ADD CONSTRAINT estabelecimentos_cnae_principal_fkey FOREIGN KEY (cnae_principal) REFERENCES dictionaries.cnaes(code),
+    ADD CONSTRAINT estabelecimentos_municipio_fkey FOREIGN KEY (municipio) REFERENCES dictionaries.municipios(code);
+
+ALTER TABLE cnpj.socios
+    ALTER COLUMN qualificacao_socio TYPE TEXT USING substr(qualificacao_socio::text, 2),
+    ALTER COLUMN pais TYPE TEXT USING substr(pais::text, 2),
+    ALTER COLUMN qualificacao_representante TYPE TEXT USING substr(qualificacao_representante::text, 2),
+    ADD CONSTRAINT socios_qualificacao_socio_fkey FOREIGN KEY (qualificacao_socio) REFERENCES dictionaries.qualificacoes(code),
+    ADD CONSTRAINT socios_pais_fkey FOREIGN KEY (pais) REFERENCES dictionaries.paises(code),
+    ADD CONSTRAINT socios_qualificacao_representante_fkey FOREIGN KEY (qualificacao_representante) REFERENCES dictionaries.qualificacoes(code);
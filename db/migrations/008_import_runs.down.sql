@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ingestion.import_runs;
+DROP SCHEMA IF EXISTS ingestion;
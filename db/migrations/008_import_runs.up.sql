@@ -0,0 +1,25 @@
+CREATE SCHEMA IF NOT EXISTS ingestion;
+
+-- One row per import: what was loaded, from which batch, and how it went.
+-- trigger is api, pipeline or cli; status is running, success or failed.
+CREATE TABLE ingestion.import_runs (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    kind TEXT NOT NULL,
+    trigger TEXT NOT NULL,
+    batch TEXT,
+    status TEXT NOT NULL DEFAULT 'running',
+    files TEXT[] NOT NULL DEFAULT '{}',
+    tables JSONB NOT NULL DEFAULT '{}',
+    accepted BIGINT NOT NULL DEFAULT 0,
+    rejected BIGINT NOT NULL DEFAULT 0,
+    quarantine TEXT,
+    result JSONB,
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ,
+    duration_ms BIGINT,
+    rows_per_second DOUBLE PRECISION
+);
+
+CREATE INDEX idx_import_runs_started ON ingestion.import_runs (started_at DESC);
+CREATE INDEX idx_import_runs_kind ON ingestion.import_runs (kind, started_at DESC);
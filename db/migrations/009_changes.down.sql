@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS cnpj.diffs;
+DROP TABLE IF EXISTS cnpj.changes;
+DROP TABLE IF EXISTS cnpj.snapshot_batches;
+DROP TABLE IF EXISTS cnpj.snapshots;
@@ -0,0 +1,51 @@
+-- What the diff compares, one row per estabelecimento and batch. Each batch
+-- is a partition, so old batches are dropped rather than deleted.
+CREATE TABLE cnpj.snapshots (
+    batch TEXT NOT NULL,
+    cnpj CHAR(14) NOT NULL,
+    razao_social TEXT,
+    nome_fantasia TEXT,
+    situacao_cadastral SMALLINT,
+    data_situacao DATE,
+    motivo_situacao TEXT,
+    cnae_principal TEXT,
+    natureza_juridica TEXT,
+    capital_social NUMERIC(20, 2),
+    endereco JSONB,
+    socios JSONB,
+    PRIMARY KEY (batch, cnpj)
+) PARTITION BY LIST (batch);
+
+CREATE TABLE cnpj.snapshot_batches (
+    batch TEXT PRIMARY KEY,
+    rows BIGINT NOT NULL,
+    taken_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Changes between two snapshots. fields lists the updated fields; before
+-- and after hold their values, or the whole row for removed and inserted.
+CREATE TABLE cnpj.changes (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    from_batch TEXT NOT NULL,
+    to_batch TEXT NOT NULL,
+    cnpj CHAR(14) NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('inserted', 'removed', 'updated')),
+    fields TEXT[] NOT NULL DEFAULT '{}',
+    uf CHAR(2),
+    before JSONB,
+    after JSONB
+);
+
+CREATE INDEX idx_changes_batches ON cnpj.changes (from_batch, to_batch, id);
+CREATE INDEX idx_changes_cnpj ON cnpj.changes (cnpj);
+
+CREATE TABLE cnpj.diffs (
+    from_batch TEXT NOT NULL,
+    to_batch TEXT NOT NULL,
+    inserted BIGINT NOT NULL,
+    removed BIGINT NOT NULL,
+    updated BIGINT NOT NULL,
+    fields JSONB NOT NULL DEFAULT '{}',
+    computed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (from_batch, to_batch)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cnpj.history_batches;
+DROP TABLE IF EXISTS cnpj.socios_history;
+DROP TABLE IF EXISTS cnpj.estabelecimentos_history;
@@ -0,0 +1,43 @@
+-- Type-2 history of the tracked estabelecimento fields and of the sócios of
+-- each company. A version is valid from the batch it first appeared in until
+-- the batch that replaced it; valid_to is NULL for current versions.
+CREATE TABLE cnpj.estabelecimentos_history (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    cnpj CHAR(14) NOT NULL,
+    situacao_cadastral SMALLINT,
+    data_situacao DATE,
+    motivo_situacao TEXT,
+    cnae_principal TEXT,
+    tipo_logradouro TEXT,
+    logradouro TEXT,
+    numero TEXT,
+    complemento TEXT,
+    bairro TEXT,
+    cep TEXT,
+    uf CHAR(2),
+    municipio TEXT,
+    valid_from TEXT NOT NULL,
+    valid_to TEXT
+);
+
+CREATE UNIQUE INDEX idx_estabelecimentos_history_current ON cnpj.estabelecimentos_history (cnpj) WHERE valid_to IS NULL;
+CREATE INDEX idx_estabelecimentos_history_cnpj ON cnpj.estabelecimentos_history (cnpj, valid_from);
+
+CREATE TABLE cnpj.socios_history (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    cnpj_basico CHAR(8) NOT NULL,
+    socios JSONB NOT NULL,
+    valid_from TEXT NOT NULL,
+    valid_to TEXT
+);
+
+CREATE UNIQUE INDEX idx_socios_history_current ON cnpj.socios_history (cnpj_basico) WHERE valid_to IS NULL;
+CREATE INDEX idx_socios_history_basico ON cnpj.socios_history (cnpj_basico, valid_from);
+
+-- Batches applied to the history, which must come in order.
+CREATE TABLE cnpj.history_batches (
+    batch TEXT PRIMARY KEY,
+    opened BIGINT NOT NULL,
+    closed BIGINT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,36 @@
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOR t IN SELECT c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+        WHERE n.nspname = 'tributario' AND c.relname ~ '^regimes_y[0-9]+_(staging|previous)$' LOOP
+        EXECUTE format('DROP TABLE tributario.%I', t);
+    END LOOP;
+END $$;
+
+ALTER TABLE tributario.regimes RENAME TO regimes_partitioned;
+
+CREATE TABLE tributario.regimes (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    ano INT NOT NULL CHECK (ano >= 1900 AND ano <= 2100),
+    cnpj CHAR(14) NOT NULL,
+    cnpj_da_scp TEXT,
+    forma_de_tributacao VARCHAR(100) NOT NULL,
+    quantidade_de_escrituracoes INT NOT NULL,
+    dataset VARCHAR(50) NOT NULL
+);
+
+INSERT INTO tributario.regimes (id, ano, cnpj, cnpj_da_scp, forma_de_tributacao, quantidade_de_escrituracoes, dataset)
+SELECT id, ano, cnpj, cnpj_da_scp, forma_de_tributacao, quantidade_de_escrituracoes, dataset
+FROM tributario.regimes_partitioned;
+
+DROP TABLE tributario.regimes_partitioned;
+
+SELECT setval(pg_get_serial_sequence('tributario.regimes', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM tributario.regimes;
+
+ALTER TABLE tributario.regimes
+    ADD CONSTRAINT uq_regimes_natural_key UNIQUE NULLS NOT DISTINCT (ano, cnpj, cnpj_da_scp, dataset);
+CREATE INDEX idx_regimes_cnpj ON tributario.regimes(cnpj);
+CREATE INDEX idx_regimes_dataset ON tributario.regimes(dataset);
+CREATE INDEX idx_regimes_year ON tributario.regimes(ano);
+CREATE INDEX idx_regimes_dataset_year ON tributario.regimes(dataset, ano);
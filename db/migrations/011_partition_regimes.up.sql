@@ -0,0 +1,46 @@
+-- Partition tributario.regimes by year. Reloads swap single year partitions,
+-- and the importer creates the partition of a new year.
+DROP TABLE IF EXISTS tributario.regimes_staging;
+DROP TABLE IF EXISTS tributario.regimes_previous;
+
+ALTER TABLE tributario.regimes RENAME TO regimes_unpartitioned;
+
+CREATE TABLE tributario.regimes (
+    id BIGINT NOT NULL,
+    ano INT NOT NULL CHECK (ano >= 1900 AND ano <= 2100),
+    cnpj CHAR(14) NOT NULL,
+    cnpj_da_scp TEXT,
+    forma_de_tributacao VARCHAR(100) NOT NULL,
+    quantidade_de_escrituracoes INT NOT NULL,
+    dataset VARCHAR(50) NOT NULL
+) PARTITION BY LIST (ano);
+
+-- the CHECK lets reloads attach a swapped partition without scanning it
+DO $$
+DECLARE
+    y INT;
+BEGIN
+    FOR y IN SELECT DISTINCT ano FROM tributario.regimes_unpartitioned LOOP
+        EXECUTE format(
+            'CREATE TABLE tributario.%I PARTITION OF tributario.regimes (CONSTRAINT %I CHECK (ano = %s)) FOR VALUES IN (%s)',
+            'regimes_y' || y, 'regimes_y' || y || '_ano', y, y
+        );
+    END LOOP;
+END $$;
+
+INSERT INTO tributario.regimes (id, ano, cnpj, cnpj_da_scp, forma_de_tributacao, quantidade_de_escrituracoes, dataset)
+SELECT id, ano, cnpj, cnpj_da_scp, forma_de_tributacao, quantidade_de_escrituracoes, dataset
+FROM tributario.regimes_unpartitioned;
+
+-- dropping the old table frees its index names and id sequence
+DROP TABLE tributario.regimes_unpartitioned;
+
+CREATE SEQUENCE tributario.regimes_id_seq OWNED BY tributario.regimes.id;
+ALTER TABLE tributario.regimes ALTER COLUMN id SET DEFAULT nextval('tributario.regimes_id_seq');
+SELECT setval('tributario.regimes_id_seq', COALESCE(MAX(id), 0) + 1, false) FROM tributario.regimes;
+
+ALTER TABLE tributario.regimes ADD CONSTRAINT regimes_pkey PRIMARY KEY (id, ano);
+ALTER TABLE tributario.regimes
+    ADD CONSTRAINT uq_regimes_natural_key UNIQUE NULLS NOT DISTINCT (ano, cnpj, cnpj_da_scp, dataset);
+CREATE INDEX idx_regimes_cnpj ON tributario.regimes(cnpj);
+CREATE INDEX idx_regimes_dataset_year ON tributario.regimes(dataset, ano);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cnpj.simples_staging;
+DROP TABLE IF EXISTS cnpj.simples_previous;
+DROP TABLE IF EXISTS cnpj.simples;
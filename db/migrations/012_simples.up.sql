@@ -0,0 +1,10 @@
+-- Simples Nacional and MEI options, one row per company
+CREATE TABLE cnpj.simples (
+    cnpj_basico CHAR(8) PRIMARY KEY,
+    opcao_simples BOOLEAN,
+    data_opcao_simples DATE,
+    data_exclusao_simples DATE,
+    opcao_mei BOOLEAN,
+    data_opcao_mei DATE,
+    data_exclusao_mei DATE
+);
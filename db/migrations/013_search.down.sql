@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS cnpj.idx_socios_nome_trgm;
+DROP INDEX IF EXISTS cnpj.idx_estabelecimentos_nome_fantasia_trgm;
+DROP INDEX IF EXISTS cnpj.idx_empresas_razao_social_trgm;
+DROP FUNCTION IF EXISTS cnpj.search_name(TEXT);
@@ -0,0 +1,16 @@
+-- Accent-insensitive fuzzy search of company and partner names.
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+CREATE EXTENSION IF NOT EXISTS unaccent WITH SCHEMA public;
+
+-- unaccent() is only STABLE, since its dictionary could change; naming the
+-- dictionary makes the wrapper safe to index.
+CREATE FUNCTION cnpj.search_name(name TEXT) RETURNS TEXT
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+    AS $$ SELECT lower(public.unaccent('public.unaccent'::regdictionary, name)) $$;
+
+CREATE INDEX idx_empresas_razao_social_trgm
+    ON cnpj.empresas USING gin (cnpj.search_name(razao_social) public.gin_trgm_ops);
+CREATE INDEX idx_estabelecimentos_nome_fantasia_trgm
+    ON cnpj.estabelecimentos USING gin (cnpj.search_name(nome_fantasia) public.gin_trgm_ops);
+CREATE INDEX idx_socios_nome_trgm
+    ON cnpj.socios USING gin (cnpj.search_name(nome_socio) public.gin_trgm_ops);
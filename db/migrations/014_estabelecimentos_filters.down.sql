@@ -0,0 +1 @@
+DROP INDEX IF EXISTS cnpj.idx_estabelecimentos_cnaes_secundarios;
@@ -0,0 +1,4 @@
+-- Lets the estabelecimentos listing match secondary CNAEs without a scan.
+
+CREATE INDEX idx_estabelecimentos_cnaes_secundarios
+    ON cnpj.estabelecimentos USING gin (cnaes_secundarios);
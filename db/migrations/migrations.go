@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations so the binary can apply them
+// itself. Files follow golang-migrate naming: NNN_name.up.sql and
+// NNN_name.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
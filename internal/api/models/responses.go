@@ -1,6 +1,9 @@
 package models
 
-import "github.com/BrunoGuimaraesSilva/receitago/internal/downloader/usecase/download"
+import (
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/usecase/download"
+)
 
 type SuccessResponse struct {
 	Status  string `json:"status" example:"success"`
@@ -34,6 +37,8 @@ type HealthResponse struct {
 	Status    string `json:"status" example:"healthy"`
 	Timestamp string `json:"timestamp" example:"2026-02-10T21:53:00Z"`
 	Version   string `json:"version" example:"1.0.0"`
+	// Schema is the database schema version, absent when it can't be read
+	Schema *database.MigrationStatus `json:"schema,omitempty"`
 }
 
 type DownloadResults struct {
@@ -9,7 +9,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
 
@@ -17,8 +17,11 @@ import (
 
 	"github.com/BrunoGuimaraesSilva/receitago/config"
 	"github.com/BrunoGuimaraesSilva/receitago/internal/api/models"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/changes"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
 	download "github.com/BrunoGuimaraesSilva/receitago/internal/downloader"
 	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/lookup"
 	"github.com/BrunoGuimaraesSilva/receitago/internal/scheduler"
 
 	_ "github.com/BrunoGuimaraesSilva/receitago/docs" // Swagger docs
@@ -30,7 +33,7 @@ type Server struct {
 	logger    zerolog.Logger
 }
 
-func NewServer(cfg *config.Config, logger zerolog.Logger, pg *pgx.Conn, mongo *mongo.Client) (*Server, error) {
+func NewServer(cfg *config.Config, logger zerolog.Logger, pg *pgxpool.Pool, mongo *mongo.Client, migrator *database.Migrator) (*Server, error) {
 	r := chi.NewRouter()
 	r.Use(
 		middleware.RequestID,
@@ -43,7 +46,7 @@ func NewServer(cfg *config.Config, logger zerolog.Logger, pg *pgx.Conn, mongo *m
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
 
 	// @Summary Health check
-	// @Description Returns API health status and version information
+	// @Description Returns API health status, version information and the database schema version. Status is "degraded" when the schema can't be read, is dirty or has pending migrations.
 	// @Tags health
 	// @Accept json
 	// @Produce json
@@ -55,15 +58,38 @@ func NewServer(cfg *config.Config, logger zerolog.Logger, pg *pgx.Conn, mongo *m
 			Timestamp: time.Now().Format(time.RFC3339),
 			Version:   "1.0.0",
 		}
+		st, err := migrator.Status(r.Context())
+		if err != nil {
+			logger.Warn().Err(err).Msg("⚠️ read schema version failed")
+			response.Status = "degraded"
+		} else {
+			response.Schema = &st
+			if st.Dirty || len(st.Pending) > 0 {
+				response.Status = "degraded"
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	})
 
+	pipeline, err := scheduler.NewPipeline(cfg, pg, mongo, logger)
+	if err != nil {
+		return nil, fmt.Errorf("create pipeline: %w", err)
+	}
+
+	store, err := lookup.NewStore(cfg.LookupStore, pg, mongo.Database(cfg.MongoDB))
+	if err != nil {
+		return nil, fmt.Errorf("create lookup store: %w", err)
+	}
+
 	// modules
 	// v1 API routes
 	r.Route("/v1", func(v1 chi.Router) {
 		download.RegisterRoutes(v1, cfg, logger)
 		ingestion.RegisterRoutes(v1, pg, mongo, cfg, logger)
+		lookup.RegisterRoutes(v1, pg, store, cfg, logger)
+		changes.RegisterRoutes(v1, pg, cfg, logger)
+		scheduler.RegisterRoutes(v1, pipeline)
 	})
 
 	_ = chi.Walk(r, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
@@ -71,11 +97,6 @@ func NewServer(cfg *config.Config, logger zerolog.Logger, pg *pgx.Conn, mongo *m
 		return nil
 	})
 
-	pipeline, err := scheduler.NewPipeline(cfg, pg, logger)
-	if err != nil {
-		return nil, fmt.Errorf("create pipeline: %w", err)
-	}
-
 	cronScheduler, err := scheduler.NewScheduler(pipeline, logger)
 	if err != nil {
 		return nil, fmt.Errorf("create scheduler: %w", err)
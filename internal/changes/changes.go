@@ -0,0 +1,430 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+
+	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+)
+
+const (
+	snapshotsTable = "cnpj.snapshots"
+	batchesTable   = "cnpj.snapshot_batches"
+	changesTable   = "cnpj.changes"
+	diffsTable     = "cnpj.diffs"
+	// a diff needs the snapshot of both batches
+	minSnapshotsKept = 2
+)
+
+const (
+	KindInserted = "inserted"
+	KindRemoved  = "removed"
+	KindUpdated  = "updated"
+)
+
+// Fields are the snapshot columns the diff compares. endereco holds the
+// address columns and socios the partners of the company.
+var Fields = []string{
+	"razao_social", "nome_fantasia", "situacao_cadastral", "data_situacao", "motivo_situacao",
+	"cnae_principal", "natureza_juridica", "capital_social", "endereco", "socios",
+}
+
+// ErrNoSnapshot is returned when a batch to diff was never snapshotted.
+var ErrNoSnapshot = errors.New("no snapshot of batch")
+
+// Snapshot reports the snapshot of a batch.
+type Snapshot struct {
+	Batch    string        `json:"batch" example:"2025-09"`
+	Rows     int64         `json:"rows"`
+	TakenAt  time.Time     `json:"taken_at"`
+	Dropped  []string      `json:"dropped,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Diff counts the changes between two batches; Fields counts the updates
+// per field.
+type Diff struct {
+	From       string           `json:"from" example:"2025-08"`
+	To         string           `json:"to" example:"2025-09"`
+	Inserted   int64            `json:"inserted"`
+	Removed    int64            `json:"removed"`
+	Updated    int64            `json:"updated"`
+	Fields     map[string]int64 `json:"fields"`
+	ComputedAt time.Time        `json:"computed_at"`
+}
+
+// Change is a CNPJ inserted, removed or updated between two batches.
+// Before and After hold the changed fields of an update, or the whole
+// snapshot row of a removal or insertion.
+type Change struct {
+	ID     int64           `json:"id"`
+	CNPJ   string          `json:"cnpj" example:"11222333000181"`
+	Kind   string          `json:"kind" example:"updated"`
+	Fields []string        `json:"fields,omitempty" example:"situacao_cadastral,endereco"`
+	UF     string          `json:"uf,omitempty" example:"PR"`
+	Before json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After  json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+}
+
+// Filter selects the changes of a diff. CNPJ matches a prefix, so a CNPJ
+// básico finds every estabelecimento; AfterID pages forward.
+type Filter struct {
+	From    string
+	To      string
+	Kind    string
+	Field   string
+	UF      string
+	CNPJ    string
+	AfterID int64
+	Limit   uint64
+}
+
+type Repo struct {
+	conn postgres.DB
+	psql sq.StatementBuilderType
+}
+
+func NewRepo(conn postgres.DB) *Repo {
+	return &Repo{
+		conn: conn,
+		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+// partition is the snapshot partition of a batch, e.g. cnpj.snapshots_2025_09.
+func partition(batch string) pgx.Identifier {
+	return pgx.Identifier{"cnpj", "snapshots_" + nonWord.ReplaceAllString(strings.ToLower(batch), "_")}
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// lock takes the session advisory lock that serializes snapshots and diffs,
+// so conn must be held by the caller.
+func (r *Repo) lock(ctx context.Context) (unlock func(), err error) {
+	var ok bool
+	if err := r.conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext('receitago:changes'))").Scan(&ok); err != nil {
+		return nil, fmt.Errorf("lock changes: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("a snapshot or diff is already running")
+	}
+	return func() {
+		_, _ = r.conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext('receitago:changes'))")
+	}, nil
+}
+
+// Snapshot copies the compared fields of the current cnpj tables into the
+// partition of batch, replacing an earlier snapshot of the same batch. Only
+// the keep newest batches are kept, and never fewer than two.
+func (r *Repo) Snapshot(ctx context.Context, batch string, keep int, logger zerolog.Logger) (Snapshot, error) {
+	start := time.Now()
+	res := Snapshot{Batch: batch}
+	if batch == "" {
+		return res, fmt.Errorf("batch is required")
+	}
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return res, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	part := partition(batch).Sanitize()
+	if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+part); err != nil {
+		return res, fmt.Errorf("drop snapshot %s: %w", batch, err)
+	}
+	if _, err := tx.Exec(ctx, "CREATE TABLE "+part+" PARTITION OF "+snapshotsTable+" FOR VALUES IN ("+quoteLiteral(batch)+")"); err != nil {
+		return res, fmt.Errorf("create snapshot %s: %w", batch, err)
+	}
+	tag, err := tx.Exec(ctx, `INSERT INTO `+snapshotsTable+` (batch, cnpj, `+strings.Join(Fields, ", ")+`)
+		SELECT $1, e.cnpj, m.razao_social, e.nome_fantasia, e.situacao_cadastral, e.data_situacao, e.motivo_situacao,
+			e.cnae_principal, m.natureza_juridica, m.capital_social,
+			jsonb_build_object(
+				'tipo_logradouro', e.tipo_logradouro, 'logradouro', e.logradouro, 'numero', e.numero,
+				'complemento', e.complemento, 'bairro', e.bairro, 'cep', e.cep, 'uf', e.uf, 'municipio', e.municipio
+			),
+			COALESCE(s.socios, '[]')
+		FROM cnpj.estabelecimentos e
+		LEFT JOIN cnpj.empresas m ON m.cnpj_basico = e.cnpj_basico
+		LEFT JOIN (`+postgres.SociosSetQuery+`) s ON s.cnpj_basico = e.cnpj_basico`, batch)
+	if err != nil {
+		return res, fmt.Errorf("fill snapshot %s: %w", batch, err)
+	}
+	res.Rows = tag.RowsAffected()
+
+	err = tx.QueryRow(ctx, `INSERT INTO `+batchesTable+` (batch, rows) VALUES ($1, $2)
+		ON CONFLICT (batch) DO UPDATE SET rows = EXCLUDED.rows, taken_at = now()
+		RETURNING taken_at`, batch, res.Rows).Scan(&res.TakenAt)
+	if err != nil {
+		return res, fmt.Errorf("record snapshot %s: %w", batch, err)
+	}
+
+	rows, err := tx.Query(ctx, "SELECT batch FROM "+batchesTable+" ORDER BY batch DESC OFFSET $1", max(keep, minSnapshotsKept))
+	if err != nil {
+		return res, fmt.Errorf("list old snapshots: %w", err)
+	}
+	old, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return res, fmt.Errorf("list old snapshots: %w", err)
+	}
+	for _, b := range old {
+		if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+partition(b).Sanitize()); err != nil {
+			return res, fmt.Errorf("drop snapshot %s: %w", b, err)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM "+batchesTable+" WHERE batch = $1", b); err != nil {
+			return res, fmt.Errorf("drop snapshot %s: %w", b, err)
+		}
+	}
+	res.Dropped = old
+
+	if err := tx.Commit(ctx); err != nil {
+		return res, fmt.Errorf("commit snapshot: %w", err)
+	}
+	res.Duration = time.Since(start)
+	logger.Info().Str("batch", batch).Int64("rows", res.Rows).Strs("dropped", old).Dur("duration", res.Duration).Msg("📸 CNPJ snapshot taken")
+	return res, nil
+}
+
+// Snapshots lists the snapshotted batches, newest first.
+func (r *Repo) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	rows, err := r.conn.Query(ctx, "SELECT batch, rows, taken_at FROM "+batchesTable+" ORDER BY batch DESC")
+	if err != nil {
+		return nil, fmt.Errorf("query snapshots: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Snapshot, error) {
+		var s Snapshot
+		err := row.Scan(&s.Batch, &s.Rows, &s.TakenAt)
+		return s, err
+	})
+}
+
+// Previous returns the newest snapshotted batch before batch, or "".
+func (r *Repo) Previous(ctx context.Context, batch string) (string, error) {
+	var prev string
+	err := r.conn.QueryRow(ctx, "SELECT batch FROM "+batchesTable+" WHERE batch < $1 ORDER BY batch DESC LIMIT 1", batch).Scan(&prev)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("query previous snapshot: %w", err)
+	}
+	return prev, nil
+}
+
+// Diff compares the snapshots of two batches by CNPJ and stores the change
+// set, replacing an earlier diff of the same batches.
+func (r *Repo) Diff(ctx context.Context, from, to string, logger zerolog.Logger) (Diff, error) {
+	start := time.Now()
+	res := Diff{From: from, To: to}
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	for _, b := range []string{from, to} {
+		var ok bool
+		if err := r.conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM "+batchesTable+" WHERE batch = $1)", b).Scan(&ok); err != nil {
+			return res, fmt.Errorf("check snapshot %s: %w", b, err)
+		}
+		if !ok {
+			return res, fmt.Errorf("%w %s", ErrNoSnapshot, b)
+		}
+	}
+
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return res, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM "+changesTable+" WHERE from_batch = $1 AND to_batch = $2", from, to); err != nil {
+		return res, fmt.Errorf("clear changes: %w", err)
+	}
+
+	whole := "to_jsonb(%s) - 'batch' - 'cnpj'"
+	tag, err := tx.Exec(ctx, `INSERT INTO `+changesTable+` (from_batch, to_batch, cnpj, kind, uf, after)
+		SELECT $1, $2, t.cnpj, '`+KindInserted+`', t.endereco->>'uf', `+fmt.Sprintf(whole, "t")+`
+		FROM `+snapshotsTable+` t
+		WHERE t.batch = $2 AND NOT EXISTS (SELECT 1 FROM `+snapshotsTable+` f WHERE f.batch = $1 AND f.cnpj = t.cnpj)`, from, to)
+	if err != nil {
+		return res, fmt.Errorf("insert inserted changes: %w", err)
+	}
+	res.Inserted = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `INSERT INTO `+changesTable+` (from_batch, to_batch, cnpj, kind, uf, before)
+		SELECT $1, $2, f.cnpj, '`+KindRemoved+`', f.endereco->>'uf', `+fmt.Sprintf(whole, "f")+`
+		FROM `+snapshotsTable+` f
+		WHERE f.batch = $1 AND NOT EXISTS (SELECT 1 FROM `+snapshotsTable+` t WHERE t.batch = $2 AND t.cnpj = f.cnpj)`, from, to)
+	if err != nil {
+		return res, fmt.Errorf("insert removed changes: %w", err)
+	}
+	res.Removed = tag.RowsAffected()
+
+	var values, fromCols, toCols []string
+	for i, f := range Fields {
+		values = append(values, fmt.Sprintf("(%d, '%s', to_jsonb(f.%s), to_jsonb(t.%s))", i, f, f, f))
+		fromCols = append(fromCols, "f."+f)
+		toCols = append(toCols, "t."+f)
+	}
+	tag, err = tx.Exec(ctx, `INSERT INTO `+changesTable+` (from_batch, to_batch, cnpj, kind, fields, uf, before, after)
+		SELECT $1, $2, t.cnpj, '`+KindUpdated+`', d.fields, t.endereco->>'uf', d.before, d.after
+		FROM `+snapshotsTable+` f
+		JOIN `+snapshotsTable+` t ON t.batch = $2 AND t.cnpj = f.cnpj
+		CROSS JOIN LATERAL (
+			SELECT array_agg(k ORDER BY i) AS fields, jsonb_object_agg(k, a) AS before, jsonb_object_agg(k, b) AS after
+			FROM (VALUES `+strings.Join(values, ", ")+`) v(i, k, a, b)
+			WHERE a IS DISTINCT FROM b
+		) d
+		WHERE f.batch = $1 AND (`+strings.Join(fromCols, ", ")+`) IS DISTINCT FROM (`+strings.Join(toCols, ", ")+`)`, from, to)
+	if err != nil {
+		return res, fmt.Errorf("insert updated changes: %w", err)
+	}
+	res.Updated = tag.RowsAffected()
+
+	err = tx.QueryRow(ctx, `INSERT INTO `+diffsTable+` (from_batch, to_batch, inserted, removed, updated, fields)
+		VALUES ($1, $2, $3, $4, $5, COALESCE((
+			SELECT jsonb_object_agg(field, n) FROM (
+				SELECT field, count(*) AS n FROM `+changesTable+`, unnest(fields) AS field
+				WHERE from_batch = $1 AND to_batch = $2
+				GROUP BY field
+			) c
+		), '{}'))
+		ON CONFLICT (from_batch, to_batch) DO UPDATE SET inserted = EXCLUDED.inserted, removed = EXCLUDED.removed,
+			updated = EXCLUDED.updated, fields = EXCLUDED.fields, computed_at = now()
+		RETURNING fields, computed_at`, from, to, res.Inserted, res.Removed, res.Updated).Scan(&res.Fields, &res.ComputedAt)
+	if err != nil {
+		return res, fmt.Errorf("record diff: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return res, fmt.Errorf("commit diff: %w", err)
+	}
+	logger.Info().Str("from", from).Str("to", to).Int64("inserted", res.Inserted).Int64("removed", res.Removed).Int64("updated", res.Updated).Dur("duration", time.Since(start)).Msg("🔍 Batch diff computed")
+	return res, nil
+}
+
+func (r *Repo) diffs() sq.SelectBuilder {
+	return r.psql.Select("from_batch", "to_batch", "inserted", "removed", "updated", "fields", "computed_at").From(diffsTable)
+}
+
+func scanDiff(row pgx.CollectableRow) (Diff, error) {
+	var d Diff
+	err := row.Scan(&d.From, &d.To, &d.Inserted, &d.Removed, &d.Updated, &d.Fields, &d.ComputedAt)
+	return d, err
+}
+
+// Diffs lists the computed diffs, newest batches first.
+func (r *Repo) Diffs(ctx context.Context) ([]Diff, error) {
+	sql, args, err := r.diffs().OrderBy("to_batch DESC", "from_batch DESC").ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query diffs: %w", err)
+	}
+	return pgx.CollectRows(rows, scanDiff)
+}
+
+// GetDiff returns the diff of two batches, or pgx.ErrNoRows when it was
+// never computed.
+func (r *Repo) GetDiff(ctx context.Context, from, to string) (Diff, error) {
+	sql, args, err := r.diffs().Where(sq.Eq{"from_batch": from, "to_batch": to}).ToSql()
+	if err != nil {
+		return Diff{}, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return Diff{}, fmt.Errorf("query diff: %w", err)
+	}
+	return pgx.CollectExactlyOneRow(rows, scanDiff)
+}
+
+func (r *Repo) changes(f Filter) sq.SelectBuilder {
+	q := r.psql.Select("id", "cnpj", "kind", "fields", "COALESCE(uf, '')", "before", "after").
+		From(changesTable).
+		Where(sq.Eq{"from_batch": f.From, "to_batch": f.To}).
+		OrderBy("id")
+	if f.Kind != "" {
+		q = q.Where(sq.Eq{"kind": f.Kind})
+	}
+	if f.Field != "" {
+		q = q.Where("? = ANY(fields)", f.Field)
+	}
+	if f.UF != "" {
+		q = q.Where(sq.Eq{"uf": strings.ToUpper(f.UF)})
+	}
+	if f.CNPJ != "" {
+		q = q.Where(sq.Like{"cnpj": f.CNPJ + "%"})
+	}
+	if f.AfterID > 0 {
+		q = q.Where(sq.Gt{"id": f.AfterID})
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	return q
+}
+
+func scanChange(row pgx.CollectableRow) (Change, error) {
+	var c Change
+	var before, after []byte
+	err := row.Scan(&c.ID, &c.CNPJ, &c.Kind, &c.Fields, &c.UF, &before, &after)
+	c.Before, c.After = before, after
+	return c, err
+}
+
+// List returns a page of changes ordered by id.
+func (r *Repo) List(ctx context.Context, f Filter) ([]Change, error) {
+	sql, args, err := r.changes(f).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query changes: %w", err)
+	}
+	return pgx.CollectRows(rows, scanChange)
+}
+
+// Each calls fn for every change matching f, streaming them from the
+// database for reports.
+func (r *Repo) Each(ctx context.Context, f Filter, fn func(Change) error) error {
+	sql, args, err := r.changes(f).ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("query changes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanChange(rows)
+		if err != nil {
+			return fmt.Errorf("scan change: %w", err)
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
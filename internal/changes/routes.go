@@ -0,0 +1,323 @@
+package changes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/infra/providers"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/httputil"
+)
+
+// Page is a page of changes with the totals of their diff. NextAfterID is
+// the after_id of the next page, zero on the last one.
+type Page struct {
+	Diff        Diff     `json:"diff"`
+	Changes     []Change `json:"changes"`
+	NextAfterID int64    `json:"next_after_id,omitempty"`
+}
+
+// parseFilter reads the batches and filters shared by the listing and the
+// report.
+func parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+	f := Filter{From: q.Get("from"), To: q.Get("to"), Kind: q.Get("kind"), Field: q.Get("field"), UF: q.Get("uf"), CNPJ: q.Get("cnpj")}
+	if f.From == "" || f.To == "" {
+		return f, fmt.Errorf("from and to batches are required")
+	}
+	if f.Kind != "" && !slices.Contains([]string{KindInserted, KindRemoved, KindUpdated}, f.Kind) {
+		return f, fmt.Errorf("invalid kind %q", f.Kind)
+	}
+	if f.Field != "" && !slices.Contains(Fields, f.Field) {
+		return f, fmt.Errorf("invalid field %q, expected one of %s", f.Field, strings.Join(Fields, ", "))
+	}
+	if strings.Trim(f.CNPJ, "0123456789") != "" {
+		return f, fmt.Errorf("invalid cnpj %q, expected digits only", f.CNPJ)
+	}
+	return f, nil
+}
+
+func RegisterRoutes(r chi.Router, pg *pgxpool.Pool, cfg *config.Config, logger zerolog.Logger) {
+	repo := NewRepo(pg)
+	withRepo := func(r *http.Request, fn func(repo *Repo) error) error {
+		return database.WithConn(r.Context(), pg, cfg.PGImportTimeout, func(conn *pgx.Conn) error {
+			return fn(NewRepo(conn))
+		})
+	}
+	// getDiff writes the error response when the diff is missing
+	getDiff := func(w http.ResponseWriter, r *http.Request, f Filter) (Diff, bool) {
+		diff, err := repo.GetDiff(r.Context(), f.From, f.To)
+		if errors.Is(err, pgx.ErrNoRows) {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("no diff from %s to %s, compute it with POST /v1/changes/diff", f.From, f.To))
+			return diff, false
+		}
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return diff, false
+		}
+		return diff, true
+	}
+
+	// @Summary Snapshot the CNPJ tables
+	// @Description Stores the compared fields of every estabelecimento of the current cnpj tables as a batch snapshot, replacing an earlier snapshot of the batch. Old snapshots beyond CHANGES_SNAPSHOTS_KEPT are dropped.
+	// @Tags changes
+	// @Produce json
+	// @Security BearerAuth
+	// @Param batch query string false "Batch name (defaults to the last downloaded Receita batch)"
+	// @Success 200 {object} changes.Snapshot "Snapshot taken"
+	// @Failure 400 {object} models.BadRequestResponse "No batch given or downloaded"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/changes/snapshot [post]
+	r.Post("/changes/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		batch := r.URL.Query().Get("batch")
+		if batch == "" {
+			batch = providers.LastDownloadedBatch(cfg.DataDir + "/receita")
+		}
+		if batch == "" {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("no batch given and none downloaded"))
+			return
+		}
+		var res Snapshot
+		err := withRepo(r, func(repo *Repo) (err error) {
+			res, err = repo.Snapshot(r.Context(), batch, cfg.SnapshotsKept, logger)
+			return err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary List snapshots
+	// @Description Lists the batches with a snapshot, which are the ones a diff can compare
+	// @Tags changes
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {array} changes.Snapshot "Snapshots, newest first"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/changes/snapshots [get]
+	r.Get("/changes/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		list, err := repo.Snapshots(r.Context())
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, list)
+	})
+
+	// @Summary Compute a batch diff
+	// @Description Compares the snapshots of two batches by CNPJ and stores the inserted, removed and updated CNPJs with the changed fields, replacing an earlier diff of the same batches
+	// @Tags changes
+	// @Produce json
+	// @Security BearerAuth
+	// @Param from query string true "Older batch" example(2025-08)
+	// @Param to query string true "Newer batch" example(2025-09)
+	// @Success 200 {object} changes.Diff "Diff totals"
+	// @Failure 400 {object} models.BadRequestResponse "Missing batches"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "A batch has no snapshot"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/changes/diff [post]
+	r.Post("/changes/diff", func(w http.ResponseWriter, r *http.Request) {
+		from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		if from == "" || to == "" {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("from and to batches are required"))
+			return
+		}
+		var res Diff
+		err := withRepo(r, func(repo *Repo) (err error) {
+			res, err = repo.Diff(r.Context(), from, to, logger)
+			return err
+		})
+		if errors.Is(err, ErrNoSnapshot) {
+			httputil.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary List computed diffs
+	// @Description Lists the batch pairs with a computed diff and their totals
+	// @Tags changes
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {array} changes.Diff "Diffs, newest first"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/changes/diffs [get]
+	r.Get("/changes/diffs", func(w http.ResponseWriter, r *http.Request) {
+		list, err := repo.Diffs(r.Context())
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, list)
+	})
+
+	// @Summary List changes between batches
+	// @Description Lists the CNPJs inserted, removed or updated from one batch to the next, with the changed fields and their values before and after
+	// @Tags changes
+	// @Produce json
+	// @Security BearerAuth
+	// @Param from query string true "Older batch" example(2025-08)
+	// @Param to query string true "Newer batch" example(2025-09)
+	// @Param kind query string false "Change kind" Enums(inserted, removed, updated)
+	// @Param field query string false "Only updates of this field" Enums(razao_social, nome_fantasia, situacao_cadastral, data_situacao, motivo_situacao, cnae_principal, natureza_juridica, capital_social, endereco, socios)
+	// @Param uf query string false "State"
+	// @Param cnpj query string false "CNPJ or CNPJ prefix, e.g. the CNPJ básico"
+	// @Param after_id query int false "Only changes after this id, for paging"
+	// @Param limit query int false "Maximum changes returned (default 100, at most 1000)"
+	// @Success 200 {object} changes.Page "Changes and diff totals"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid parameters"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Diff not computed"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/changes [get]
+	r.Get("/changes", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilter(r)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		q := r.URL.Query()
+		if v := q.Get("after_id"); v != "" {
+			if f.AfterID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid after_id: %w", err))
+				return
+			}
+		}
+		f.Limit = 100
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || n == 0 || n > 1000 {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+			f.Limit = n
+		}
+
+		diff, ok := getDiff(w, r, f)
+		if !ok {
+			return
+		}
+		// filtered pages of a large diff can scan far, like the report
+		var list []Change
+		err = withRepo(r, func(repo *Repo) (err error) {
+			list, err = repo.List(r.Context(), f)
+			return err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		page := Page{Diff: diff, Changes: list}
+		if uint64(len(list)) == f.Limit {
+			page.NextAfterID = list[len(list)-1].ID
+		}
+		httputil.WriteJSON(w, http.StatusOK, page)
+	})
+
+	// @Summary Download a change report
+	// @Description Streams every change between two batches matching the filters as a CSV (before and after as JSON columns) or NDJSON attachment
+	// @Tags changes
+	// @Produce text/csv
+	// @Produce application/x-ndjson
+	// @Security BearerAuth
+	// @Param from query string true "Older batch" example(2025-08)
+	// @Param to query string true "Newer batch" example(2025-09)
+	// @Param kind query string false "Change kind" Enums(inserted, removed, updated)
+	// @Param field query string false "Only updates of this field"
+	// @Param uf query string false "State"
+	// @Param cnpj query string false "CNPJ or CNPJ prefix"
+	// @Param format query string false "Report format (default csv)" Enums(csv, ndjson)
+	// @Success 200 {file} file "Change report"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid parameters"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Diff not computed"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/changes/report [get]
+	r.Get("/changes/report", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilter(r)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "ndjson" {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q", format))
+			return
+		}
+		if _, ok := getDiff(w, r, f); !ok {
+			return
+		}
+
+		name := fmt.Sprintf("changes-%s-%s.%s", f.From, f.To, format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		var write func(Change) error
+		var flush func() error
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			cw := csv.NewWriter(w)
+			_ = cw.Write([]string{"id", "cnpj", "kind", "fields", "uf", "before", "after"})
+			write = func(c Change) error {
+				return cw.Write([]string{strconv.FormatInt(c.ID, 10), c.CNPJ, c.Kind, strings.Join(c.Fields, "|"), c.UF, string(c.Before), string(c.After)})
+			}
+			flush = func() error {
+				cw.Flush()
+				return cw.Error()
+			}
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(w)
+			write = func(c Change) error { return enc.Encode(c) }
+			flush = func() error { return nil }
+		}
+
+		n := 0
+		err = withRepo(r, func(repo *Repo) error {
+			return repo.Each(r.Context(), f, func(c Change) error {
+				if err := write(c); err != nil {
+					return err
+				}
+				if n++; n%1000 == 0 {
+					if err := flush(); err != nil {
+						return err
+					}
+					if fl, ok := w.(http.Flusher); ok {
+						fl.Flush()
+					}
+				}
+				return nil
+			})
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			// the status is sent already, so the report just ends early
+			logger.Error().Err(err).Str("from", f.From).Str("to", f.To).Msg("❌ change report failed")
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// migrationsLock is the advisory lock key held while migrations run, so
+// several instances starting together apply them once.
+const migrationsLock = "receitago:migrations"
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus reports the schema version against the embedded
+// migrations. Version 0 means no migration was applied.
+type MigrationStatus struct {
+	Version int64    `json:"version"`
+	Dirty   bool     `json:"dirty"`
+	Latest  int64    `json:"latest"`
+	Pending []string `json:"pending"`
+}
+
+// Migrator applies SQL migrations, keeping the version in the
+// schema_migrations table the way golang-migrate does, so the two can be
+// used against the same database.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []migration
+	logger     zerolog.Logger
+}
+
+func NewMigrator(pool *pgxpool.Pool, fsys fs.FS, logger zerolog.Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := make(map[int64]*migration)
+	for _, de := range entries {
+		m := migrationFile.FindStringSubmatch(de.Name())
+		if m == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		sql, err := fs.ReadFile(fsys, de.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", de.Name(), err)
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if m[3] == "up" {
+			mig.up = string(sql)
+		} else {
+			mig.down = string(sql)
+		}
+	}
+
+	migrator := &Migrator{pool: pool, logger: logger}
+	for _, mig := range byVersion {
+		if mig.up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", mig.Version)
+		}
+		migrator.migrations = append(migrator.migrations, *mig)
+	}
+	slices.SortFunc(migrator.migrations, func(a, b migration) int { return cmp.Compare(a.Version, b.Version) })
+	return migrator, nil
+}
+
+// Latest is the version of the last embedded migration.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reads the schema version without taking the migrations lock.
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	st := MigrationStatus{Latest: m.Latest(), Pending: []string{}}
+	var exists bool
+	if err := m.pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return st, fmt.Errorf("check schema_migrations: %w", err)
+	}
+	if exists {
+		var err error
+		if st.Version, st.Dirty, err = readVersion(ctx, m.pool); err != nil {
+			return st, err
+		}
+	}
+	for _, mig := range m.migrations {
+		if mig.Version > st.Version {
+			st.Pending = append(st.Pending, fmt.Sprintf("%03d_%s", mig.Version, mig.Name))
+		}
+	}
+	return st, nil
+}
+
+// Up applies every pending migration and returns how many ran.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *pgx.Conn, version int64) error {
+		for _, mig := range m.migrations {
+			if mig.Version <= version {
+				continue
+			}
+			m.logger.Info().Int64("version", mig.Version).Str("name", mig.Name).Msg("⬆️ Applying migration")
+			if err := m.run(ctx, conn, mig.Version, mig.up, mig.Version); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations and returns how many ran.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(conn *pgx.Conn, version int64) error {
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mig := m.migrations[i]
+			if mig.Version > version {
+				continue
+			}
+			if mig.down == "" {
+				return fmt.Errorf("migration %d has no down file", mig.Version)
+			}
+			var previous int64
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+			m.logger.Info().Int64("version", mig.Version).Str("name", mig.Name).Msg("⬇️ Reverting migration")
+			if err := m.run(ctx, conn, mig.Version, mig.down, previous); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// locked runs fn on one connection holding the migrations lock, with the
+// current version of a clean schema.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgx.Conn, version int64) error) error {
+	return WithConn(ctx, m.pool, 0, func(conn *pgx.Conn) error {
+		if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrationsLock); err != nil {
+			return fmt.Errorf("lock migrations: %w", err)
+		}
+		defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", migrationsLock)
+
+		if _, err := conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)"); err != nil {
+			return fmt.Errorf("create schema_migrations: %w", err)
+		}
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("schema is dirty at version %d: a migration failed halfway, fix the database and reset schema_migrations", version)
+		}
+		return fn(conn, version)
+	})
+}
+
+// run executes one file of migration version and records target as the
+// schema version. version is marked dirty while the file runs, so a failure
+// leaves a trace instead of a half-applied schema that looks clean.
+func (m *Migrator) run(ctx context.Context, conn *pgx.Conn, version int64, sql string, target int64) error {
+	if err := setVersion(ctx, conn, version, true); err != nil {
+		return err
+	}
+	// no arguments, so pgx sends the file as one simple query
+	if _, err := conn.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %d: %w", version, err)
+	}
+	return setVersion(ctx, conn, target, false)
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func readVersion(ctx context.Context, q rowQuerier) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := q.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+func setVersion(ctx context.Context, conn *pgx.Conn, version int64, dirty bool) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "TRUNCATE schema_migrations"); err != nil {
+			return fmt.Errorf("reset schema version: %w", err)
+		}
+		if version == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)", version, dirty); err != nil {
+			return fmt.Errorf("set schema version: %w", err)
+		}
+		return nil
+	})
+}
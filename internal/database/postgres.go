@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/config"
+)
+
+const (
+	connectAttempts = 10
+	connectBackoff  = 2 * time.Second
+)
+
+// NewPostgresPool opens the pool shared by the API and the scheduler. Queries
+// get cfg.PGStatementTimeout; broken connections are dropped by the pool
+// health check and replaced on the next acquire, so the pool recovers on its
+// own when Postgres restarts.
+func NewPostgresPool(ctx context.Context, cfg *config.Config, logger zerolog.Logger) (*pgxpool.Pool, error) {
+	pc, err := pgxpool.ParseConfig(cfg.PostgresDSN)
+	if err != nil {
+		return nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	pc.MaxConns = cfg.PGMaxConns
+	pc.MinConns = cfg.PGMinConns
+	pc.MaxConnIdleTime = 5 * time.Minute
+	pc.HealthCheckPeriod = 30 * time.Second
+	if cfg.PGStatementTimeout > 0 {
+		pc.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.PGStatementTimeout.Milliseconds(), 10)
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, pc)
+	if err != nil {
+		return nil, fmt.Errorf("create pool: %w", err)
+	}
+
+	// the database may still be starting, e.g. under docker compose
+	for attempt := 1; ; attempt++ {
+		err = pool.Ping(ctx)
+		if err == nil {
+			break
+		}
+		if attempt == connectAttempts {
+			pool.Close()
+			return nil, fmt.Errorf("ping postgres: %w", err)
+		}
+		logger.Warn().Err(err).Int("attempt", attempt).Msg("⏳ postgres not ready, retrying")
+		select {
+		case <-ctx.Done():
+			pool.Close()
+			return nil, ctx.Err()
+		case <-time.After(connectBackoff * time.Duration(attempt)):
+		}
+	}
+	return pool, nil
+}
+
+// WithConn runs fn on a connection held for its whole duration, which
+// imports need for temporary tables and advisory locks. statementTimeout
+// replaces the pool timeout for the session, zero disabling it, and is
+// restored before the connection goes back to the pool.
+func WithConn(ctx context.Context, pool *pgxpool.Pool, statementTimeout time.Duration, fn func(conn *pgx.Conn) error) error {
+	c, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer func() {
+		// a connection we can't reset must not serve other queries
+		if _, err := c.Exec(context.Background(), "RESET statement_timeout"); err != nil {
+			c.Conn().Close(context.Background())
+		}
+		c.Release()
+	}()
+
+	if _, err := c.Exec(ctx, "SELECT set_config('statement_timeout', $1, false)", strconv.FormatInt(statementTimeout.Milliseconds(), 10)); err != nil {
+		return fmt.Errorf("set statement timeout: %w", err)
+	}
+	return fn(c.Conn())
+}
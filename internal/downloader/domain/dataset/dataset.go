@@ -12,12 +12,28 @@ type Dataset struct {
 	URL       string    `json:"url"`
 	Filename  string    `json:"filename"`
 	Published time.Time `json:"published,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
 }
 
 type DatasetProvider interface {
 	ListNeeded(ctx context.Context) ([]Dataset, error)
 }
 
+// Release is what a provider currently publishes, listed without recording
+// anything, and whether its ledger says it was already fetched.
+type Release struct {
+	Batch          string
+	AlreadyFetched bool
+	Datasets       []Dataset
+}
+
+// ReleasePreviewer is implemented by providers whose ListNeeded has side
+// effects, so a dry run can list the same datasets safely.
+type ReleasePreviewer interface {
+	PreviewRelease(ctx context.Context) (Release, error)
+}
+
 type DownloaderPort interface {
 	Download(ctx context.Context, url string) (iox.ReadSeekCloser, error)
 }
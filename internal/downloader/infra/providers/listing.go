@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var hrefPattern = regexp.MustCompile(`href="([^"?#]+)"`)
+
+// remoteEntry is a single file or folder found in a remote directory listing.
+type remoteEntry struct {
+	Name         string
+	URL          string
+	IsDir        bool
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// directoryLister lists the entries directly below a remote directory.
+type directoryLister interface {
+	List(ctx context.Context, dirURL string) ([]remoteEntry, error)
+}
+
+// htmlLister reads Apache-style HTML indexes. Only names are available,
+// so size, ETag and modification time are left empty.
+type htmlLister struct {
+	client    *http.Client
+	userAgent string
+}
+
+func (l *htmlLister) List(ctx context.Context, dirURL string) ([]remoteEntry, error) {
+	if !strings.HasSuffix(dirURL, "/") {
+		dirURL += "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dirURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("User-Agent", l.userAgent)
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get %s: %w", dirURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http %s: %s", dirURL, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+
+	var out []remoteEntry
+	for _, m := range hrefPattern.FindAllStringSubmatch(string(b), -1) {
+		href := m[1]
+		// skip parent links, absolute paths and links to other hosts
+		if href == "../" || strings.HasPrefix(href, "/") || strings.Contains(href, "://") {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(href, "/"))
+		if err != nil {
+			name = strings.TrimSuffix(href, "/")
+		}
+		out = append(out, remoteEntry{
+			Name:  name,
+			URL:   dirURL + href,
+			IsDir: strings.HasSuffix(href, "/"),
+		})
+	}
+	return out, nil
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -27,119 +26,107 @@ const (
 )
 
 var (
-	yearMonthPattern = regexp.MustCompile(`href="(\d{4}-\d{2}/)"`)
-	filePattern      = regexp.MustCompile(`href="(\w+\d?\.zip)"`)
-	taxFilePattern   = regexp.MustCompile(`href="((Imune|Lucro).+\.zip)"`)
+	yearMonthPattern = regexp.MustCompile(`^\d{4}-\d{2}$`)
+	filePattern      = regexp.MustCompile(`^\w+\d?\.zip$`)
+	taxFilePattern   = regexp.MustCompile(`^(Imune|Lucro).+\.zip$`)
 )
 
 type ReceitaProvider struct {
 	client  *http.Client
+	baseURL string
 	baseDir string
 	logger  zerolog.Logger
+	lister  directoryLister
 }
 
-func NewReceitaProvider(baseDir string, logger zerolog.Logger) *ReceitaProvider {
+// NewReceitaProvider lists Receita batches under baseURL, which may be the
+// classic HTML index or a Nextcloud public share. An empty baseURL uses the
+// official Receita address.
+func NewReceitaProvider(baseURL, baseDir string, logger zerolog.Logger) *ReceitaProvider {
+	if baseURL == "" {
+		baseURL = federalRevenueURL
+	}
 	return &ReceitaProvider{
 		client:  &http.Client{Timeout: httpTimeout},
+		baseURL: baseURL,
 		baseDir: baseDir,
 		logger:  logger,
 	}
 }
 
-func (p *ReceitaProvider) get(ctx context.Context, url string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return "", fmt.Errorf("new request: %w", err)
-	}
-	req.Header.Set("User-Agent", receitaUserAgent)
-
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("http get %s: %w", url, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("http %s: %s", url, resp.Status)
-	}
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("read body: %w", err)
+// detectLister picks the listing strategy once per provider. A share link
+// is rewritten into its WebDAV root, so baseURL must be read afterwards.
+func (p *ReceitaProvider) detectLister(ctx context.Context) {
+	if p.lister != nil {
+		return
 	}
-	return string(b), nil
+	lister, root := newDirectoryLister(ctx, p.client, p.baseURL)
+	p.lister, p.baseURL = lister, root
+	p.logger.Info().Str("url", p.baseURL).Str("strategy", fmt.Sprintf("%T", lister)).Msg("Detected Receita listing strategy")
 }
 
-func (p *ReceitaProvider) mostRecentFolder(ctx context.Context, url string) (string, string, error) {
+func (p *ReceitaProvider) mostRecentFolder(ctx context.Context, url string) (remoteEntry, error) {
 	p.logger.Debug().Str("url", url).Msg("Fetching folder list")
 
-	if !strings.HasSuffix(url, "/") {
-		url += "/"
-	}
-	b, err := p.get(ctx, url)
+	entries, err := p.lister.List(ctx, url)
 	if err != nil {
-		return "", "", err
+		return remoteEntry{}, err
 	}
-	var batches []string
-	for _, m := range yearMonthPattern.FindAllStringSubmatch(b, -1) {
-		batches = append(batches, m[1])
+	var batches []remoteEntry
+	for _, e := range entries {
+		if e.IsDir && yearMonthPattern.MatchString(e.Name) {
+			batches = append(batches, e)
+		}
 	}
-	slices.Sort(batches)
 	if len(batches) == 0 {
-		return "", "", fmt.Errorf("no batches found in %s", url)
+		return remoteEntry{}, fmt.Errorf("no batches found in %s", url)
 	}
+	slices.SortFunc(batches, func(a, b remoteEntry) int { return strings.Compare(a.Name, b.Name) })
 	latest := batches[len(batches)-1]
-	p.logger.Info().Str("latest", latest).Msg("Found most recent CNPJ batch")
-	return strings.TrimSuffix(latest, "/"), url + latest, nil
+	p.logger.Info().Str("latest", latest.Name).Msg("Found most recent CNPJ batch")
+	return latest, nil
 }
 
-func (p *ReceitaProvider) taxRegimeURLs(ctx context.Context, url string) ([]string, error) {
-	if !strings.HasSuffix(url, "/") {
-		url += "/"
-	}
-	b, err := p.get(ctx, url)
+func (p *ReceitaProvider) matchingFiles(ctx context.Context, url string, pattern *regexp.Regexp) ([]remoteEntry, error) {
+	entries, err := p.lister.List(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	var urls []string
-	for _, m := range taxFilePattern.FindAllStringSubmatch(b, -1) {
-		urls = append(urls, url+m[1])
+	var files []remoteEntry
+	for _, e := range entries {
+		if !e.IsDir && pattern.MatchString(e.Name) {
+			files = append(files, e)
+		}
 	}
-	return urls, nil
+	return files, nil
 }
 
-func (p *ReceitaProvider) sourceURLs(ctx context.Context, baseURL string) (string, []string, error) {
+// latestRelease lists the most recent batch and the tax regime files
+// without consulting or updating the ledger.
+func (p *ReceitaProvider) latestRelease(ctx context.Context) (string, []remoteEntry, error) {
+	p.detectLister(ctx)
+	baseURL := p.baseURL
 	if !strings.HasSuffix(baseURL, "/") {
 		baseURL += "/"
 	}
 
-	folderName, folderURL, err := p.mostRecentFolder(ctx, baseURL+federalRevenueSourcePath)
+	folder, err := p.mostRecentFolder(ctx, baseURL+federalRevenueSourcePath)
 	if err != nil {
 		return "", nil, fmt.Errorf("get most recent folder: %w", err)
 	}
 
-	if p.isAlreadyDownloaded(folderName) {
-		return "", nil, fmt.Errorf("latest base already downloaded: %s", folderName)
-	}
-	if err := p.saveLastDownloaded(folderName); err != nil {
-		return "", nil, fmt.Errorf("save last downloaded: %w", err)
-	}
-
-	b, err := p.get(ctx, folderURL)
+	files, err := p.matchingFiles(ctx, folder.URL, filePattern)
 	if err != nil {
 		return "", nil, fmt.Errorf("list files: %w", err)
 	}
-	var urls []string
-	for _, m := range filePattern.FindAllStringSubmatch(b, -1) {
-		urls = append(urls, folderURL+m[1])
-	}
 
-	ts, err := p.taxRegimeURLs(ctx, baseURL+federalRevenueTaxesPath)
+	taxes, err := p.matchingFiles(ctx, baseURL+federalRevenueTaxesPath, taxFilePattern)
 	if err != nil {
 		return "", nil, fmt.Errorf("list tax regime: %w", err)
 	}
-	urls = append(urls, ts...)
+	files = append(files, taxes...)
 
-	return folderName, urls, nil
+	return folder.Name, files, nil
 }
 
 func (p *ReceitaProvider) saveLastDownloaded(name string) error {
@@ -160,37 +147,67 @@ func (p *ReceitaProvider) saveLastDownloaded(name string) error {
 }
 
 func (p *ReceitaProvider) isAlreadyDownloaded(name string) bool {
-	path := filepath.Join(p.baseDir, lastDownloadedFile)
-	b, err := os.ReadFile(path)
+	last := LastDownloadedBatch(p.baseDir)
+	return last != "" && last == name
+}
+
+// LastDownloadedBatch returns the Receita batch (YYYY-MM) recorded in the
+// ledger under baseDir, or "" when nothing was downloaded yet.
+func LastDownloadedBatch(baseDir string) string {
+	b, err := os.ReadFile(filepath.Join(baseDir, lastDownloadedFile))
 	if err != nil {
-		return false
+		return ""
 	}
-	last := strings.TrimSpace(string(b))
-	return last == name
+	return strings.TrimSpace(string(b))
 }
 
 func (p *ReceitaProvider) ListNeeded(ctx context.Context) ([]dataset.Dataset, error) {
-	folderName, urls, err := p.sourceURLs(ctx, federalRevenueURL)
+	folderName, files, err := p.latestRelease(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if p.isAlreadyDownloaded(folderName) {
+		return nil, fmt.Errorf("latest base already downloaded: %s", folderName)
+	}
+	if err := p.saveLastDownloaded(folderName); err != nil {
+		return nil, fmt.Errorf("save last downloaded: %w", err)
+	}
+
+	p.logger.Info().Int("count", len(files)).Str("batch", folderName).Msg("Listing Receita datasets")
+	return p.datasets(folderName, files), nil
+}
+
+// PreviewRelease lists the latest batch like ListNeeded but leaves the
+// ledger untouched.
+func (p *ReceitaProvider) PreviewRelease(ctx context.Context) (dataset.Release, error) {
+	folderName, files, err := p.latestRelease(ctx)
+	if err != nil {
+		return dataset.Release{}, err
+	}
+	return dataset.Release{
+		Batch:          folderName,
+		AlreadyFetched: p.isAlreadyDownloaded(folderName),
+		Datasets:       p.datasets(folderName, files),
+	}, nil
+}
 
+func (p *ReceitaProvider) datasets(folderName string, files []remoteEntry) []dataset.Dataset {
 	// Parse YYYY-MM format to time.Time
 	publishedDate, err := time.Parse("2006-01", folderName)
 	if err != nil {
 		p.logger.Warn().Str("folderName", folderName).Err(err).Msg("Failed to parse published date, using zero time")
 	}
 
-	p.logger.Info().Int("count", len(urls)).Str("batch", folderName).Msg("Listing Receita datasets")
-
-	out := make([]dataset.Dataset, len(urls))
-	for i, u := range urls {
+	out := make([]dataset.Dataset, len(files))
+	for i, f := range files {
 		out[i] = dataset.Dataset{
-			ID:        fmt.Sprintf("receita-%s-%s", folderName, filepath.Base(u)),
-			URL:       u,
-			Filename:  filepath.Base(u),
+			ID:        fmt.Sprintf("receita-%s-%s", folderName, f.Name),
+			URL:       f.URL,
+			Filename:  f.Name,
 			Published: publishedDate,
+			Size:      f.Size,
+			ETag:      f.ETag,
 		}
 	}
-	return out, nil
+	return out
 }
@@ -0,0 +1,206 @@
+package providers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getetag/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+var (
+	// https://host/index.php/s/<token> is how Nextcloud publishes a public share link
+	nextcloudSharePattern = regexp.MustCompile(`^(https?://.+?)/(?:index\.php/)?s/([A-Za-z0-9]+)/?$`)
+	webdavPathPattern     = regexp.MustCompile(`/(public\.php/webdav|public\.php/dav|remote\.php/dav|remote\.php/webdav)(/|$)`)
+)
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	ETag          string `xml:"DAV: getetag"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+}
+
+// webdavLister lists folders with PROPFIND depth 1, which is what Nextcloud
+// public shares answer instead of HTML indexes.
+type webdavLister struct {
+	client    *http.Client
+	userAgent string
+	// user is sent as basic auth username; Nextcloud expects the share token here
+	user string
+}
+
+func (l *webdavLister) propfind(ctx context.Context, dirURL, depth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", dirURL, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("User-Agent", l.userAgent)
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	if l.user != "" {
+		req.SetBasicAuth(l.user, "")
+	}
+	return l.client.Do(req)
+}
+
+// probe reports whether dirURL answers PROPFIND with a multi-status response.
+func (l *webdavLister) probe(ctx context.Context, dirURL string) bool {
+	resp, err := l.propfind(ctx, dirURL, "0")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusMultiStatus
+}
+
+func (l *webdavLister) List(ctx context.Context, dirURL string) ([]remoteEntry, error) {
+	if !strings.HasSuffix(dirURL, "/") {
+		dirURL += "/"
+	}
+	base, err := url.Parse(dirURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url %s: %w", dirURL, err)
+	}
+
+	resp, err := l.propfind(ctx, dirURL, "1")
+	if err != nil {
+		return nil, fmt.Errorf("propfind %s: %w", dirURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("propfind %s: %s", dirURL, resp.Status)
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("decode multistatus: %w", err)
+	}
+
+	var out []remoteEntry
+	for _, r := range ms.Responses {
+		ref, err := url.Parse(strings.TrimSpace(r.Href))
+		if err != nil {
+			continue
+		}
+		abs := base.ResolveReference(ref)
+		// the first response describes the folder itself
+		if strings.TrimSuffix(abs.Path, "/") == strings.TrimSuffix(base.Path, "/") {
+			continue
+		}
+
+		entry := remoteEntry{
+			Name: path.Base(strings.TrimSuffix(abs.Path, "/")),
+			URL:  abs.String(),
+		}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			entry.IsDir = ps.Prop.ResourceType.Collection != nil
+			entry.ETag = strings.Trim(ps.Prop.ETag, `"`)
+			if n, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+				entry.Size = n
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				entry.LastModified = t
+			}
+		}
+		if entry.IsDir && !strings.HasSuffix(entry.URL, "/") {
+			entry.URL += "/"
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// ShareTransport sends the token of a Nextcloud public share link as basic
+// auth on the requests below its WebDAV root, so dataset URLs, which end up
+// in ledgers, logs and plans, never carry it. For any other baseURL it
+// returns next, or http.DefaultTransport when next is nil.
+func ShareTransport(baseURL string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	root, token, ok := nextcloudShareRoot(baseURL)
+	if !ok {
+		return next
+	}
+	return &shareAuth{root: root, token: token, next: next}
+}
+
+type shareAuth struct {
+	root  string
+	token string
+	next  http.RoundTripper
+}
+
+func (t *shareAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.String(), t.root) && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(t.token, "")
+	}
+	return t.next.RoundTrip(req)
+}
+
+// nextcloudShareRoot converts a public share link into its WebDAV root and token.
+func nextcloudShareRoot(shareURL string) (root, token string, ok bool) {
+	m := nextcloudSharePattern.FindStringSubmatch(shareURL)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1] + "/public.php/webdav/", m[2], true
+}
+
+// newDirectoryLister picks the listing strategy for baseURL: Nextcloud share
+// links and known WebDAV paths use PROPFIND directly, anything else is probed
+// and falls back to HTML indexes. It returns the URL listings should start at.
+func newDirectoryLister(ctx context.Context, client *http.Client, baseURL string) (directoryLister, string) {
+	if root, token, ok := nextcloudShareRoot(baseURL); ok {
+		return &webdavLister{client: client, userAgent: receitaUserAgent, user: token}, root
+	}
+
+	dav := &webdavLister{client: client, userAgent: receitaUserAgent}
+	if webdavPathPattern.MatchString(baseURL) {
+		return dav, baseURL
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if dav.probe(probeCtx, baseURL) {
+		return dav, baseURL
+	}
+	return &htmlLister{client: client, userAgent: receitaUserAgent}, baseURL
+}
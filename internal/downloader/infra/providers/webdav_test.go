@@ -0,0 +1,163 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const multistatus = `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>/public.php/webdav/dados_abertos_cnpj/</d:href>
+    <d:propstat>
+      <d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/public.php/webdav/dados_abertos_cnpj/2025-09/</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:resourcetype><d:collection/></d:resourcetype>
+        <d:getlastmodified>Sun, 14 Sep 2025 10:00:00 GMT</d:getlastmodified>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/public.php/webdav/dados_abertos_cnpj/Empresas0.zip</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:resourcetype/>
+        <d:getcontentlength>123456</d:getcontentlength>
+        <d:getetag>"abc123"</d:getetag>
+        <d:getlastmodified>Mon, 15 Sep 2025 08:30:00 GMT</d:getlastmodified>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop><d:getcontenttype/></d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+
+// davServer stands in for a Nextcloud share: PROPFIND answers the
+// multistatus above and records the basic auth user it was sent.
+func davServer(t *testing.T, user *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if user != nil {
+			*user, _, _ = r.BasicAuth()
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatus)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebdavListerList(t *testing.T) {
+	var user string
+	srv := davServer(t, &user)
+	l := &webdavLister{client: srv.Client(), userAgent: receitaUserAgent, user: "token123"}
+
+	entries, err := l.List(context.Background(), srv.URL+"/public.php/webdav/dados_abertos_cnpj")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if user != "token123" {
+		t.Errorf("basic auth user = %q, want token123", user)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2 (the folder itself is skipped): %+v", len(entries), entries)
+	}
+
+	dir := entries[0]
+	if dir.Name != "2025-09" || !dir.IsDir || !strings.HasSuffix(dir.URL, "/2025-09/") {
+		t.Errorf("folder = %+v", dir)
+	}
+
+	file := entries[1]
+	want := remoteEntry{
+		Name:         "Empresas0.zip",
+		URL:          srv.URL + "/public.php/webdav/dados_abertos_cnpj/Empresas0.zip",
+		Size:         123456,
+		ETag:         "abc123",
+		LastModified: time.Date(2025, 9, 15, 8, 30, 0, 0, time.UTC),
+	}
+	if file != want {
+		t.Errorf("file = %+v, want %+v", file, want)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.URL, "token123") || strings.Contains(e.URL, "@") {
+			t.Errorf("URL %q carries the share token", e.URL)
+		}
+	}
+}
+
+func TestNewDirectoryListerDetection(t *testing.T) {
+	dav := davServer(t, nil)
+	lister, root := newDirectoryLister(context.Background(), dav.Client(), dav.URL+"/dados/")
+	if _, ok := lister.(*webdavLister); !ok {
+		t.Errorf("PROPFIND server got %T, want *webdavLister", lister)
+	}
+	if root != dav.URL+"/dados/" {
+		t.Errorf("root = %q", root)
+	}
+
+	html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprint(w, `<html><a href="../">Parent</a><a href="2025-09/">2025-09/</a><a href="Empresas0.zip">Empresas0.zip</a></html>`)
+	}))
+	defer html.Close()
+
+	lister, _ = newDirectoryLister(context.Background(), html.Client(), html.URL+"/dados/")
+	if _, ok := lister.(*htmlLister); !ok {
+		t.Fatalf("HTML server got %T, want *htmlLister", lister)
+	}
+	entries, err := lister.List(context.Background(), html.URL+"/dados/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "2025-09" || !entries[0].IsDir || entries[1].Name != "Empresas0.zip" {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestShareTransport(t *testing.T) {
+	var user string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ = r.BasicAuth()
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: ShareTransport(srv.URL+"/index.php/s/token123", nil)}
+	if _, err := client.Get(srv.URL + "/public.php/webdav/Empresas0.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if user != "token123" {
+		t.Errorf("download under the share root sent user %q, want token123", user)
+	}
+
+	user = ""
+	if _, err := client.Get(srv.URL + "/elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		t.Errorf("request outside the share root sent user %q", user)
+	}
+}
@@ -0,0 +1,42 @@
+package download
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/infra/providers"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/usecase/download"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/downloader"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage/local"
+)
+
+var ErrUnknownProvider = errors.New("unknown provider")
+
+// NewFilestorer returns the filestorer every download writes through.
+func NewFilestorer(cfg *config.Config) *storage.SmartFilestorer {
+	return storage.NewSmartFilestorer(cfg.DataDir, local.LocalFS{}, storage.StdZipReader{})
+}
+
+// NewInteractor wires the provider, downloader and retry policy for a
+// provider name ("receita" or "tesouro").
+func NewInteractor(name string, cfg *config.Config, logger zerolog.Logger) (*download.Interactor, error) {
+	fs := NewFilestorer(cfg)
+
+	switch name {
+	case "receita":
+		provider := providers.NewReceitaProvider(cfg.ReceitaURL, cfg.DataDir+"/receita", logger)
+		dl := downloader.NewChunkDownloader(downloader.DefaultChunkConfig())
+		// share links authenticate downloads instead of their URLs
+		dl.Client.Transport = providers.ShareTransport(cfg.ReceitaURL, nil)
+		return download.NewInteractor(provider, dl, fs, 3, 5*time.Second)
+	case "tesouro":
+		provider := providers.NewTesouroProvider(cfg.DataDir+"/tesouro", logger)
+		dl := downloader.NewHTTPDownloader(downloader.DefaultHTTPConfig())
+		return download.NewInteractor(provider, dl, fs, 2, 2*time.Second)
+	}
+	return nil, ErrUnknownProvider
+}
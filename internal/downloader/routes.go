@@ -2,19 +2,15 @@ package download
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 
 	"github.com/BrunoGuimaraesSilva/receitago/config"
-	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/infra/providers"
-	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/usecase/download"
-	"github.com/BrunoGuimaraesSilva/receitago/pkg/downloader"
-	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage"
-	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage/local"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/httputil"
 )
 
 func RegisterRoutes(r chi.Router, cfg *config.Config, logger zerolog.Logger) {
@@ -36,11 +32,7 @@ func RegisterRoutes(r chi.Router, cfg *config.Config, logger zerolog.Logger) {
 	r.Get("/download/receita", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		provider := providers.NewReceitaProvider(cfg.DataDir+"/receita", logger)
-		dl := downloader.NewChunkDownloader(downloader.DefaultChunkConfig())
-		fs := storage.NewSmartFilestorer(cfg.DataDir, local.LocalFS{}, storage.StdZipReader{})
-
-		uc, err := download.NewInteractor(provider, dl, fs, 3, 5*time.Second)
+		uc, err := NewInteractor("receita", cfg, logger)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("create interactor: %v", err), http.StatusInternalServerError)
 			return
@@ -69,11 +61,7 @@ func RegisterRoutes(r chi.Router, cfg *config.Config, logger zerolog.Logger) {
 	r.Get("/download/tesouro", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		provider := providers.NewTesouroProvider(cfg.DataDir+"/tesouro", logger)
-		dl := downloader.NewHTTPDownloader(downloader.DefaultHTTPConfig())
-		fs := storage.NewSmartFilestorer(cfg.DataDir, local.LocalFS{}, storage.StdZipReader{})
-
-		uc, err := download.NewInteractor(provider, dl, fs, 2, 2*time.Second)
+		uc, err := NewInteractor("tesouro", cfg, logger)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("create interactor: %v", err), http.StatusInternalServerError)
 			return
@@ -85,4 +73,33 @@ func RegisterRoutes(r chi.Router, cfg *config.Config, logger zerolog.Logger) {
 		}
 		_ = json.NewEncoder(w).Encode(results)
 	})
+
+	// @Summary Preview a download
+	// @Description Lists the datasets a provider would fetch or skip, their sizes and the estimated disk use, without downloading anything
+	// @Tags download
+	// @Produce json
+	// @Security BearerAuth
+	// @Param provider path string true "Provider" Enums(receita, tesouro)
+	// @Success 200 {object} download.Plan "Download plan"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown provider"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/plan/{provider} [get]
+	r.Get("/plan/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		uc, err := NewInteractor(chi.URLParam(r, "provider"), cfg, logger)
+		if errors.Is(err, ErrUnknownProvider) {
+			httputil.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, fmt.Errorf("create interactor: %w", err))
+			return
+		}
+		plan, err := uc.Plan(r.Context())
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, plan)
+	})
 }
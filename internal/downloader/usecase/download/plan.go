@@ -0,0 +1,70 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/domain/dataset"
+)
+
+// zipExpansionRatio approximates how much larger the extracted Receita CSVs
+// are than their zips; zips are kept next to the extracted files.
+const zipExpansionRatio = 4
+
+// Plan lists what Run would fetch and skip without downloading or saving
+// anything. Providers implementing dataset.ReleasePreviewer are listed through
+// it so their ledger is left untouched.
+func (uc *Interactor) Plan(ctx context.Context) (Plan, error) {
+	var release dataset.Release
+	if pv, ok := uc.Provider.(dataset.ReleasePreviewer); ok {
+		r, err := pv.PreviewRelease(ctx)
+		if err != nil {
+			return Plan{}, fmt.Errorf("preview release: %w", err)
+		}
+		release = r
+	} else {
+		items, err := uc.Provider.ListNeeded(ctx)
+		if err != nil {
+			return Plan{}, fmt.Errorf("list datasets: %w", err)
+		}
+		release = dataset.Release{Datasets: items}
+	}
+
+	plan := Plan{Batch: release.Batch, Items: make([]PlanItem, 0, len(release.Datasets))}
+	if release.AlreadyFetched {
+		plan.Blocked = fmt.Sprintf("latest base already downloaded: %s", release.Batch)
+	}
+	for _, ds := range release.Datasets {
+		item := PlanItem{ID: ds.ID, Filename: ds.Filename, URL: ds.URL, Size: ds.Size}
+
+		switch {
+		case release.AlreadyFetched:
+			item.Action, item.Reason = ActionSkip, fmt.Sprintf("batch %s already downloaded", release.Batch)
+		default:
+			item.Action = ActionFetch
+		}
+
+		if item.Action == ActionSkip {
+			plan.Skipped++
+		} else {
+			plan.ToFetch++
+			if ds.Size > 0 {
+				plan.DownloadBytes += ds.Size
+				plan.EstimatedDiskBytes += estimateDiskUse(ds)
+			} else {
+				plan.UnknownSizes++
+			}
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	return plan, nil
+}
+
+func estimateDiskUse(ds dataset.Dataset) int64 {
+	if strings.ToLower(filepath.Ext(ds.Filename)) == ".zip" {
+		return ds.Size * (1 + zipExpansionRatio)
+	}
+	return ds.Size
+}
@@ -24,3 +24,34 @@ type Result struct {
 	UnzipTime    time.Duration `json:"unzip_time"`
 	Attempts     int           `json:"attempts"`
 }
+
+type PlanAction string
+
+const (
+	ActionFetch PlanAction = "fetch"
+	ActionSkip  PlanAction = "skip"
+)
+
+// PlanItem is what Run would do with a single dataset.
+type PlanItem struct {
+	ID       string     `json:"id"`
+	Filename string     `json:"filename"`
+	URL      string     `json:"url"`
+	Size     int64      `json:"size"`
+	Action   PlanAction `json:"action"`
+	Reason   string     `json:"reason,omitempty"`
+}
+
+// Plan is the outcome of a dry run. Sizes are only known when the provider
+// listing reports them; UnknownSizes counts the datasets left out of the totals.
+// Blocked holds the error Run would return instead of downloading.
+type Plan struct {
+	Batch              string     `json:"batch,omitempty"`
+	Blocked            string     `json:"blocked,omitempty"`
+	Items              []PlanItem `json:"items"`
+	ToFetch            int        `json:"to_fetch"`
+	Skipped            int        `json:"skipped"`
+	UnknownSizes       int        `json:"unknown_sizes"`
+	DownloadBytes      int64      `json:"download_bytes"`
+	EstimatedDiskBytes int64      `json:"estimated_disk_bytes"`
+}
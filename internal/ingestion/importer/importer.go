@@ -0,0 +1,301 @@
+package importer
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/csvx"
+)
+
+const DefaultBatchSize = 5000
+
+// Sink receives parsed rows in batches, one batch at a time per Importer.
+// The slice may be reused after Write returns, so sinks must copy anything
+// they keep.
+type Sink interface {
+	Write(ctx context.Context, rows []layout.Row) error
+}
+
+// FileStats counts the rows of a file. Skipped rows were rejected and
+// quarantined; ConversionErrors counts values that could not be converted
+// and were stored as null, per field.
+type FileStats struct {
+	File             string         `json:"file"`
+	Rows             int            `json:"rows"`
+	Skipped          int            `json:"skipped"`
+	ConversionErrors map[string]int `json:"conversion_errors,omitempty"`
+}
+
+// Conversions returns the total number of conversion errors.
+func (st FileStats) Conversions() int {
+	n := 0
+	for _, c := range st.ConversionErrors {
+		n += c
+	}
+	return n
+}
+
+// Importer streams delimited files described by a Layout into a Sink.
+// Rejected rows go to Quarantine when set; Budget, when set, bounds the
+// memory of the rows in flight.
+type Importer struct {
+	Layout     layout.Layout
+	Sink       Sink
+	BatchSize  int
+	Quarantine *Quarantine
+	Budget     *Budget
+	source     string
+	logger     zerolog.Logger
+}
+
+func New(l layout.Layout, sink Sink, batchSize int, logger zerolog.Logger) *Importer {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Importer{Layout: l, Sink: sink, BatchSize: batchSize, logger: logger}
+}
+
+// ImportZip imports every file inside the zip at zipPath.
+func (im *Importer) ImportZip(ctx context.Context, zipPath string) ([]FileStats, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("open zip: %w", err)
+	}
+	defer zr.Close()
+	im.source = filepath.Base(zipPath)
+
+	var stats []FileStats
+	for _, f := range zr.File {
+		st, err := im.importZipFile(ctx, f)
+		stats = append(stats, st)
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func (im *Importer) importZipFile(ctx context.Context, f *zip.File) (FileStats, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return FileStats{File: f.Name}, fmt.Errorf("open file inside zip: %w", err)
+	}
+	defer rc.Close()
+	return im.Import(ctx, f.Name, rc)
+}
+
+// Import reads a single file from r. Malformed rows are skipped and
+// quarantined. Reading, decoding, parsing and writing run as pipelined
+// stages, each on its own goroutine, and batches waiting between them
+// hold their size of the Budget.
+func (im *Importer) Import(ctx context.Context, name string, r io.Reader) (FileStats, error) {
+	st := FileStats{File: name}
+	g, ctx := errgroup.WithContext(ctx)
+	records := make(chan recordBatch, stageDepth)
+	rows := make(chan rowBatch, stageDepth)
+
+	r = readAhead(ctx, g, r)
+	g.Go(func() error {
+		defer close(records)
+		return im.decode(ctx, name, r, records)
+	})
+	g.Go(func() error {
+		defer close(rows)
+		return im.transform(ctx, &st, records, rows)
+	})
+	g.Go(func() error {
+		return im.write(ctx, rows)
+	})
+	err := g.Wait()
+
+	// batches left behind by a failed stage give their budget back
+	for b := range records {
+		im.Budget.release(b.size)
+	}
+	for b := range rows {
+		im.Budget.release(b.size)
+	}
+	if err != nil {
+		return st, err
+	}
+
+	im.logger.Info().Str("file", name).Int("total", st.Rows).Int("skipped", st.Skipped).Int("conversion_errors", st.Conversions()).Msg("🎯 Finished processing file")
+	return st, im.Quarantine.check(st, true)
+}
+
+// record is a raw record and its line. Err is set for lines the CSV
+// reader could not split.
+type record struct {
+	fields []string
+	line   int
+	err    error
+}
+
+type recordBatch struct {
+	records []record
+	size    int64
+}
+
+type rowBatch struct {
+	rows []layout.Row
+	size int64
+}
+
+// recordSize approximates the memory a record holds until written: its
+// text and string headers, once raw and once parsed.
+func recordSize(fields []string) int64 {
+	n := 0
+	for _, f := range fields {
+		n += len(f) + 16
+	}
+	return int64(2 * n)
+}
+
+// decode converts the file to UTF-8 and splits it into batches of records.
+func (im *Importer) decode(ctx context.Context, name string, r io.Reader, out chan<- recordBatch) error {
+	if im.Layout.Encoding != "" {
+		enc, err := csvx.ParseEncoding(im.Layout.Encoding)
+		if err != nil {
+			return err
+		}
+		if enc == csvx.Auto {
+			if enc, r, err = csvx.DecodeAuto(r); err != nil {
+				return fmt.Errorf("detect encoding: %w", err)
+			}
+			im.logger.Info().Str("file", name).Str("encoding", string(enc)).Msg("Detected file encoding")
+		} else {
+			r = csvx.Decode(r, enc)
+		}
+	}
+
+	reader := csvx.NewReader(r, im.Layout.Comma)
+	if im.Layout.Header {
+		if _, err := reader.Read(); err != nil {
+			return fmt.Errorf("read header: %w", err)
+		}
+	}
+
+	im.logger.Info().Str("file", name).Str("layout", im.Layout.Name).Msg("Processing file")
+
+	batch := recordBatch{records: make([]record, 0, im.BatchSize)}
+	send := func() error {
+		size, err := im.Budget.acquire(ctx, batch.size)
+		if err != nil {
+			return err
+		}
+		batch.size = size
+		select {
+		case out <- batch:
+		case <-ctx.Done():
+			im.Budget.release(size)
+			return ctx.Err()
+		}
+		batch = recordBatch{records: make([]record, 0, im.BatchSize)}
+		return nil
+	}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		rec := record{fields: fields}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rec.line, rec.err = parseErr.Line, parseErr.Err
+		} else if err != nil {
+			return fmt.Errorf("read row: %w", err)
+		} else {
+			rec.line, _ = reader.FieldPos(0)
+		}
+		batch.records = append(batch.records, rec)
+		batch.size += recordSize(fields)
+
+		if len(batch.records) >= im.BatchSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(batch.records) > 0 {
+		return send()
+	}
+	return nil
+}
+
+// transform parses records into rows, counting them in st and rejecting
+// the ones that don't fit the layout.
+func (im *Importer) transform(ctx context.Context, st *FileStats, in <-chan recordBatch, out chan<- rowBatch) error {
+	for b := range in {
+		rows := make([]layout.Row, 0, len(b.records))
+		for _, rec := range b.records {
+			if rec.err != nil {
+				if err := im.reject(st, rec.line, rec.fields, rec.err.Error()); err != nil {
+					im.Budget.release(b.size)
+					return err
+				}
+				continue
+			}
+			row, conversions, err := im.Layout.Parse(rec.fields)
+			if err != nil {
+				if err := im.reject(st, rec.line, rec.fields, err.Error()); err != nil {
+					im.Budget.release(b.size)
+					return err
+				}
+				continue
+			}
+			for _, c := range conversions {
+				if st.ConversionErrors == nil {
+					st.ConversionErrors = make(map[string]int)
+				}
+				st.ConversionErrors[c.Field]++
+				im.logger.Debug().Str("file", st.File).Str("field", c.Field).Str("reason", c.Reason).Msg("Value stored as null")
+			}
+			rows = append(rows, row)
+			st.Rows++
+		}
+		select {
+		case out <- rowBatch{rows: rows, size: b.size}:
+		case <-ctx.Done():
+			im.Budget.release(b.size)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// write hands the rows to the Sink and frees their budget.
+func (im *Importer) write(ctx context.Context, in <-chan rowBatch) error {
+	for b := range in {
+		var err error
+		if len(b.rows) > 0 {
+			err = im.Sink.Write(ctx, b.rows)
+		}
+		im.Budget.release(b.size)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reject quarantines a record and fails the file once too many were
+// rejected.
+func (im *Importer) reject(st *FileStats, line int, record []string, reason string) error {
+	st.Skipped++
+	im.logger.Debug().Str("file", st.File).Int("line", line).Str("reason", reason).Msg("⚠️ Skipping malformed row")
+	r := Rejection{Source: im.source, File: st.File, Line: line, Raw: strings.Join(record, string(im.Layout.Comma)), Reason: reason}
+	if err := im.Quarantine.Add(r); err != nil {
+		return err
+	}
+	return im.Quarantine.check(*st, false)
+}
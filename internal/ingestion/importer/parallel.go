@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// stageDepth is how many batches may wait between two stages of a file.
+	stageDepth = 2
+	// chunkSize is the size of the blocks read ahead of decoding.
+	chunkSize = 1 << 20
+)
+
+// Parallelism configures concurrent imports: up to Workers files are
+// imported at once, and MemoryBudget caps the bytes of rows read but not
+// yet written, across all files of the import. Zero values import one
+// file at a time without a cap.
+type Parallelism struct {
+	Workers      int
+	MemoryBudget int64
+}
+
+// Budget is a memory budget shared by the files of an import. A nil
+// Budget never blocks.
+type Budget struct {
+	size int64
+	sem  *semaphore.Weighted
+}
+
+func NewBudget(bytes int64) *Budget {
+	if bytes <= 0 {
+		return nil
+	}
+	return &Budget{size: bytes, sem: semaphore.NewWeighted(bytes)}
+}
+
+// acquire waits until n bytes are free. A batch larger than the whole
+// budget takes all of it, so it runs alone instead of never.
+func (b *Budget) acquire(ctx context.Context, n int64) (int64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	n = min(n, b.size)
+	return n, b.sem.Acquire(ctx, n)
+}
+
+func (b *Budget) release(n int64) {
+	if b != nil && n > 0 {
+		b.sem.Release(n)
+	}
+}
+
+// ForEach calls fn for every file on up to workers goroutines. The first
+// error cancels the context of the others and is returned.
+func ForEach(ctx context.Context, workers int, files []string, fn func(ctx context.Context, i int, path string) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(workers, 1))
+	for i, path := range files {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(ctx, i, path)
+		})
+	}
+	return g.Wait()
+}
+
+// readAhead reads r in chunks on its own goroutine, so decompression
+// overlaps with decoding. The returned reader fails once ctx is done.
+func readAhead(ctx context.Context, g *errgroup.Group, r io.Reader) io.Reader {
+	chunks := make(chan []byte, stageDepth)
+	g.Go(func() error {
+		defer close(chunks)
+		for {
+			buf := make([]byte, chunkSize)
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				select {
+				case chunks <- buf[:n]:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	return &chunkReader{ctx: ctx, chunks: chunks}
+}
+
+type chunkReader struct {
+	ctx    context.Context
+	chunks <-chan []byte
+	buf    []byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		select {
+		case chunk, ok := <-c.chunks:
+			if !ok {
+				if err := c.ctx.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+			c.buf = chunk
+		case <-c.ctx.Done():
+			return 0, c.ctx.Err()
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,133 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// minRejectSample is the number of rows read before the rejection ratio can
+// fail a file early, so a few bad rows at the top don't stop an import.
+const minRejectSample = 1000
+
+// Rejects configures rejected rows: they are appended to an NDJSON file in
+// Dir, and a file fails the import once more than MaxRatio of its rows are
+// rejected. A zero MaxRatio never fails.
+type Rejects struct {
+	Dir      string
+	MaxRatio float64
+}
+
+// Rejection is a quarantined row. Raw is the record's fields joined with
+// the file delimiter.
+type Rejection struct {
+	Source string `json:"source"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Raw    string `json:"raw"`
+	Reason string `json:"reason"`
+}
+
+// ErrTooManyRejected fails an import whose files reject too many rows.
+type ErrTooManyRejected struct {
+	File     string
+	Rejected int
+	Rows     int
+	MaxRatio float64
+}
+
+func (e *ErrTooManyRejected) Error() string {
+	return fmt.Sprintf("%s: %d of %d rows rejected, above the %.2f%% limit", e.File, e.Rejected, e.Rows, e.MaxRatio*100)
+}
+
+// Quarantine collects the rejected rows of one import run. The file is
+// created with the first rejection, so clean imports leave nothing behind.
+type Quarantine struct {
+	Rejects
+	name string
+
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	enc  *json.Encoder
+	n    int
+}
+
+// NewQuarantine returns the quarantine of an import run; name identifies
+// the run in the file name, e.g. "cnpj-empresas".
+func NewQuarantine(rejects Rejects, name string) *Quarantine {
+	return &Quarantine{Rejects: rejects, name: name}
+}
+
+// Add appends a rejected row. A nil Quarantine drops it.
+func (q *Quarantine) Add(r Rejection) error {
+	if q == nil || q.Dir == "" {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.f == nil {
+		if err := os.MkdirAll(q.Dir, 0o755); err != nil {
+			return fmt.Errorf("create quarantine dir: %w", err)
+		}
+		path := filepath.Join(q.Dir, q.name+"-"+time.Now().Format("20060102-150405")+".ndjson")
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create quarantine file: %w", err)
+		}
+		q.path, q.f, q.enc = path, f, json.NewEncoder(f)
+	}
+	q.n++
+	return q.enc.Encode(r)
+}
+
+// Path is the quarantine file, empty when no row was rejected.
+func (q *Quarantine) Path() string {
+	if q == nil {
+		return ""
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.path
+}
+
+// Len is the number of rows quarantined so far.
+func (q *Quarantine) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// check fails a file whose rejections exceed the ratio. Unless final, it
+// waits for minRejectSample rows before judging.
+func (q *Quarantine) check(st FileStats, final bool) error {
+	if q == nil || q.MaxRatio <= 0 {
+		return nil
+	}
+	total := st.Rows + st.Skipped
+	if total == 0 || (!final && total < minRejectSample) {
+		return nil
+	}
+	if float64(st.Skipped) > float64(total)*q.MaxRatio {
+		return &ErrTooManyRejected{File: st.File, Rejected: st.Skipped, Rows: total, MaxRatio: q.MaxRatio}
+	}
+	return nil
+}
+
+func (q *Quarantine) Close() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.f == nil {
+		return nil
+	}
+	return q.f.Close()
+}
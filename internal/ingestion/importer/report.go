@@ -0,0 +1,29 @@
+package importer
+
+// Report sums the rows of an import run: accepted rows reached the store,
+// rejected ones went to the Quarantine file.
+type Report struct {
+	Accepted   int    `json:"accepted"`
+	Rejected   int    `json:"rejected"`
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+func (r *Report) Add(stats ...FileStats) {
+	for _, st := range stats {
+		r.Accepted += st.Rows
+		r.Rejected += st.Skipped
+	}
+}
+
+// Summary describes an import run for the run history: the files read and
+// the rows written per table or collection.
+type Summary struct {
+	Report
+	Files  []string
+	Tables map[string]int64
+}
+
+// Summarizer is implemented by import results.
+type Summarizer interface {
+	Summary() Summary
+}
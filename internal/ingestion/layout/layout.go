@@ -0,0 +1,204 @@
+package layout
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FieldType string
+
+const (
+	String  FieldType = "string"
+	Int     FieldType = "int"
+	List    FieldType = "list"
+	Decimal FieldType = "decimal"
+	Date    FieldType = "date"
+)
+
+// DecimalValue is an exact decimal in dot notation, e.g. "1000.50". Stores
+// convert it to their own decimal type.
+type DecimalValue string
+
+// Parser converts the trimmed raw value of a field into the value stored.
+type Parser func(raw string) (any, error)
+
+// Field maps a CSV position to a named, typed value. Parse overrides the
+// default parser of Type. Concat builds the raw value by joining the
+// record values at those positions instead of reading Pos. Empty values of
+// Nullable fields become nil, and so do values of Nullable fields that fail
+// to convert; those are reported as conversion errors instead of rejecting
+// the row. Required rejects rows where the field is empty.
+type Field struct {
+	Name     string
+	Pos      int
+	Concat   []int
+	Type     FieldType
+	Parse    Parser
+	Nullable bool
+	Required bool
+}
+
+// Layout describes one kind of delimited file: its dialect and the fields
+// of each record. Encoding is a csvx encoding name, "auto" to detect it, or
+// empty to read the bytes as UTF-8. Rows with fewer than MinFields columns
+// are rejected; zero means every field position must be present.
+type Layout struct {
+	Name      string
+	Comma     rune
+	Header    bool
+	Encoding  string
+	MinFields int
+	Fields    []Field
+}
+
+// Row holds the parsed values of a record in Fields order.
+type Row []any
+
+// RowError explains why a record could not be parsed.
+type RowError struct {
+	Field  string
+	Reason string
+}
+
+func (e *RowError) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+func (l Layout) minFields() int {
+	if l.MinFields > 0 {
+		return l.MinFields
+	}
+	n := 0
+	for _, f := range l.Fields {
+		for _, pos := range append([]int{f.Pos}, f.Concat...) {
+			if pos+1 > n {
+				n = pos + 1
+			}
+		}
+	}
+	return n
+}
+
+// Index returns the position of the named field in a Row, or -1.
+func (l Layout) Index(name string) int {
+	for i, f := range l.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Names returns the field names in Row order.
+func (l Layout) Names() []string {
+	names := make([]string, len(l.Fields))
+	for i, f := range l.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Parse converts a CSV record into a Row. The error rejects the record;
+// conversions lists the Nullable fields that were set to nil because their
+// value could not be converted.
+func (l Layout) Parse(record []string) (row Row, conversions []RowError, err error) {
+	if min := l.minFields(); len(record) < min {
+		return nil, nil, &RowError{Reason: fmt.Sprintf("expected at least %d fields, got %d", min, len(record))}
+	}
+
+	row = make(Row, len(l.Fields))
+	for i, f := range l.Fields {
+		raw := f.raw(record)
+		if raw == "" && f.Required {
+			return nil, nil, &RowError{Field: f.Name, Reason: "empty value"}
+		}
+		if raw == "" && f.Nullable {
+			continue
+		}
+		v, err := f.parse(raw)
+		if err != nil {
+			if f.Nullable {
+				conversions = append(conversions, RowError{Field: f.Name, Reason: err.Error()})
+				continue
+			}
+			return nil, nil, &RowError{Field: f.Name, Reason: err.Error()}
+		}
+		row[i] = v
+	}
+	return row, conversions, nil
+}
+
+func (f Field) raw(record []string) string {
+	if len(f.Concat) == 0 {
+		if f.Pos < len(record) {
+			return strings.TrimSpace(record[f.Pos])
+		}
+		return ""
+	}
+	var b strings.Builder
+	for _, pos := range f.Concat {
+		if pos < len(record) {
+			b.WriteString(strings.TrimSpace(record[pos]))
+		}
+	}
+	return b.String()
+}
+
+func (f Field) parse(raw string) (any, error) {
+	if f.Parse != nil {
+		return f.Parse(raw)
+	}
+	switch f.Type {
+	case Int:
+		return strconv.Atoi(raw)
+	case List:
+		return parseList(raw), nil
+	case Decimal:
+		return parseDecimal(raw)
+	case Date:
+		return parseDate(raw)
+	}
+	return raw, nil
+}
+
+func parseList(raw string) []string {
+	out := []string{}
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parseDecimal accepts the Brazilian notation used by Receita ("1.000,50")
+// as well as plain dot decimals.
+func parseDecimal(raw string) (any, error) {
+	if strings.Contains(raw, ",") {
+		raw = strings.ReplaceAll(raw, ".", "")
+		raw = strings.ReplaceAll(raw, ",", ".")
+	}
+	if _, ok := new(big.Rat).SetString(raw); !ok || strings.ContainsAny(raw, "/eE") {
+		return nil, fmt.Errorf("invalid decimal %q", raw)
+	}
+	return DecimalValue(raw), nil
+}
+
+// parseDate reads YYYYMMDD dates. Receita writes "0" or "00000000" when a
+// date is unknown; those are nil rather than errors.
+func parseDate(raw string) (any, error) {
+	if strings.Trim(raw, "0") == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("20060102", raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", raw)
+	}
+	return t, nil
+}
@@ -0,0 +1,139 @@
+package layout
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Layouts of the Receita Federal CNPJ open data files. The files have no
+// header and use ';' as separator and are published in ISO-8859-1; imports
+// can pass "auto" to detect the encoding instead. Domain codes are integers, except CNAEs
+// whose leading zeros are part of the code; dates, codes and capital that
+// can't be converted become nil and are counted.
+
+var Empresas = Layout{
+	Name:     "empresas",
+	Comma:    ';',
+	Encoding: "iso-8859-1",
+	Fields: []Field{
+		{Name: "cnpj_basico", Pos: 0, Type: String, Required: true},
+		{Name: "razao_social", Pos: 1, Type: String},
+		{Name: "natureza_juridica", Pos: 2, Type: Int, Nullable: true},
+		{Name: "qualificacao_resp", Pos: 3, Type: Int, Nullable: true},
+		{Name: "capital_social", Pos: 4, Type: Decimal, Nullable: true},
+		{Name: "porte_empresa", Pos: 5, Type: Int, Nullable: true},
+		{Name: "ente_federativo_resp", Pos: 6, Type: String},
+	},
+}
+
+var Estabelecimentos = Layout{
+	Name:     "estabelecimentos",
+	Comma:    ';',
+	Encoding: "iso-8859-1",
+	Fields: []Field{
+		{Name: "cnpj_basico", Pos: 0, Type: String, Required: true},
+		{Name: "cnpj_ordem", Pos: 1, Type: String},
+		{Name: "cnpj_dv", Pos: 2, Type: String},
+		{Name: "cnpj", Concat: []int{0, 1, 2}, Type: String},
+		{Name: "matriz_filial", Pos: 3, Type: Int, Nullable: true},
+		{Name: "nome_fantasia", Pos: 4, Type: String},
+		{Name: "situacao_cadastral", Pos: 5, Type: Int, Nullable: true},
+		{Name: "data_situacao", Pos: 6, Type: Date, Nullable: true},
+		{Name: "motivo_situacao", Pos: 7, Type: Int, Nullable: true},
+		{Name: "nome_cidade_exterior", Pos: 8, Type: String},
+		{Name: "pais", Pos: 9, Type: Int, Nullable: true},
+		{Name: "data_inicio_atividade", Pos: 10, Type: Date, Nullable: true},
+		{Name: "cnae_principal", Pos: 11, Type: String},
+		{Name: "cnaes_secundarios", Pos: 12, Type: List},
+		{Name: "tipo_logradouro", Pos: 13, Type: String},
+		{Name: "logradouro", Pos: 14, Type: String},
+		{Name: "numero", Pos: 15, Type: String},
+		{Name: "complemento", Pos: 16, Type: String},
+		{Name: "bairro", Pos: 17, Type: String},
+		{Name: "cep", Pos: 18, Type: String},
+		{Name: "uf", Pos: 19, Type: String},
+		{Name: "municipio", Pos: 20, Type: Int, Nullable: true},
+		{Name: "ddd1", Pos: 21, Type: String},
+		{Name: "telefone1", Pos: 22, Type: String},
+		{Name: "ddd2", Pos: 23, Type: String},
+		{Name: "telefone2", Pos: 24, Type: String},
+		{Name: "ddd_fax", Pos: 25, Type: String},
+		{Name: "fax", Pos: 26, Type: String},
+		{Name: "email", Pos: 27, Type: String},
+		{Name: "situacao_especial", Pos: 28, Type: String},
+		{Name: "data_situacao_especial", Pos: 29, Type: Date, Nullable: true},
+	},
+}
+
+var Socios = Layout{
+	Name:     "socios",
+	Comma:    ';',
+	Encoding: "iso-8859-1",
+	Fields: []Field{
+		{Name: "cnpj_basico", Pos: 0, Type: String, Required: true},
+		{Name: "identificador_socio", Pos: 1, Type: Int, Nullable: true},
+		{Name: "nome_socio", Pos: 2, Type: String},
+		{Name: "cnpj_cpf_socio", Pos: 3, Type: String},
+		{Name: "qualificacao_socio", Pos: 4, Type: Int, Nullable: true},
+		{Name: "data_entrada_sociedade", Pos: 5, Type: Date, Nullable: true},
+		{Name: "pais", Pos: 6, Type: Int, Nullable: true},
+		{Name: "cpf_representante_legal", Pos: 7, Type: String},
+		{Name: "nome_representante_legal", Pos: 8, Type: String},
+		{Name: "qualificacao_representante", Pos: 9, Type: Int, Nullable: true},
+		{Name: "faixa_etaria", Pos: 10, Type: Int, Nullable: true},
+	},
+}
+
+// Dictionary is shared by Cnaes, Motivos, Qualificacoes, Municipios, Paises
+// and Naturezas: a code and its description.
+var Dictionary = Layout{
+	Name:     "dictionary",
+	Comma:    ';',
+	Encoding: "iso-8859-1",
+	Fields: []Field{
+		{Name: "code", Pos: 0, Type: String, Required: true},
+		{Name: "description", Pos: 1, Type: String},
+	},
+}
+
+// Regimes is the tax regime export (Lucro Real, Presumido, Arbitrado and
+// Imunes e Isentas), the only Receita file with a header.
+var Regimes = Layout{
+	Name:   "regimes",
+	Comma:  ',',
+	Header: true,
+	Fields: []Field{
+		{Name: "ano", Pos: 0, Type: Int},
+		{Name: "cnpj", Pos: 1, Type: String, Required: true},
+		{Name: "cnpj_da_scp", Pos: 2, Type: String, Nullable: true},
+		{Name: "forma_de_tributacao", Pos: 3, Type: String},
+		{Name: "quantidade_de_escrituracoes", Pos: 4, Type: Int},
+	},
+}
+
+// Simples lists the Simples Nacional and MEI options of each company. The
+// options are "S" or "N"; anything else becomes nil.
+var Simples = Layout{
+	Name:     "simples",
+	Comma:    ';',
+	Encoding: "iso-8859-1",
+	Fields: []Field{
+		{Name: "cnpj_basico", Pos: 0, Type: String, Required: true},
+		{Name: "opcao_simples", Pos: 1, Type: String, Parse: parseFlag, Nullable: true},
+		{Name: "data_opcao_simples", Pos: 2, Type: Date, Nullable: true},
+		{Name: "data_exclusao_simples", Pos: 3, Type: Date, Nullable: true},
+		{Name: "opcao_mei", Pos: 4, Type: String, Parse: parseFlag, Nullable: true},
+		{Name: "data_opcao_mei", Pos: 5, Type: Date, Nullable: true},
+		{Name: "data_exclusao_mei", Pos: 6, Type: Date, Nullable: true},
+	},
+}
+
+func parseFlag(raw string) (any, error) {
+	switch strings.ToUpper(raw) {
+	case "S":
+		return true, nil
+	case "N":
+		return false, nil
+	}
+	return nil, fmt.Errorf("invalid flag %q", raw)
+}
@@ -2,27 +2,86 @@ package ingestion
 
 import (
 	"context"
-	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
 )
 
-// safe field getter
-func getField(row []string, idx int) string {
-	if idx < len(row) {
-		return strings.TrimSpace(row[idx])
-	}
-	return ""
-}
+const (
+	batchSize  = 5000
+	batchField = "_batch"
+)
 
-// batch insert with logging
-func insertBatch(ctx context.Context, coll *mongo.Collection, batch []interface{}, logger zerolog.Logger) error {
-	opts := options.InsertMany().SetOrdered(false)
-	_, err := coll.InsertMany(ctx, batch, opts)
+// batch upsert with logging
+func upsertBatch(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel, logger zerolog.Logger) error {
+	opts := options.BulkWrite().SetOrdered(false)
+	res, err := coll.BulkWrite(ctx, models, opts)
 	if err == nil {
-		logger.Debug().Int("count", len(batch)).Str("collection", coll.Name()).Msg("✅ Inserted batch")
+		logger.Debug().Int64("upserted", res.UpsertedCount).Int64("replaced", res.ModifiedCount).Str("collection", coll.Name()).Msg("✅ Upserted batch")
 	}
 	return err
 }
+
+// collectionSink replaces the document matching each row's natural key,
+// inserting it when missing.
+type collectionSink struct {
+	coll   *mongo.Collection
+	entity Entity
+	keyIdx []int
+	batch  string
+	logger zerolog.Logger
+}
+
+func newCollectionSink(coll *mongo.Collection, e Entity, batch string, logger zerolog.Logger) *collectionSink {
+	keyIdx := make([]int, len(e.Key))
+	for i, k := range e.Key {
+		keyIdx[i] = e.Layout.Index(k)
+	}
+	return &collectionSink{coll: coll, entity: e, keyIdx: keyIdx, batch: batch, logger: logger}
+}
+
+func (s *collectionSink) Write(ctx context.Context, rows []layout.Row) error {
+	now := time.Now()
+	models := make([]mongo.WriteModel, len(rows))
+	for i, row := range rows {
+		doc := make(bson.D, 0, len(row)+2)
+		for j, f := range s.entity.Layout.Fields {
+			doc = append(doc, bson.E{Key: f.Name, Value: bsonValue(row[j])})
+		}
+		doc = append(doc, bson.E{Key: batchField, Value: s.batch}, bson.E{Key: "_imported_at", Value: now})
+
+		filter := make(bson.D, len(s.keyIdx))
+		for k, idx := range s.keyIdx {
+			filter[k] = bson.E{Key: s.entity.Key[k], Value: row[idx]}
+		}
+		models[i] = mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true)
+	}
+	return upsertBatch(ctx, s.coll, models, s.logger)
+}
+
+// bsonValue converts layout values that have a native BSON counterpart.
+func bsonValue(v any) any {
+	if d, ok := v.(layout.DecimalValue); ok {
+		if dec, err := primitive.ParseDecimal128(string(d)); err == nil {
+			return dec
+		}
+	}
+	return v
+}
+
+// ImportZip upserts every file of a Receita zip into coll as described by
+// the entity layout. Rejected rows go to quarantine and rows in flight are
+// bounded by budget; both may be nil.
+func ImportZip(ctx context.Context, coll *mongo.Collection, e Entity, batch, zipPath string, quarantine *importer.Quarantine, budget *importer.Budget, logger zerolog.Logger) ([]importer.FileStats, error) {
+	sink := newCollectionSink(coll, e, batch, logger)
+	im := importer.New(e.Layout, sink, batchSize, logger)
+	im.Quarantine, im.Budget = quarantine, budget
+	return im.ImportZip(ctx, zipPath)
+}
@@ -0,0 +1,364 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+)
+
+const (
+	CompaniesCollection = "companies"
+	companiesBatchSize  = 1000
+	// keeps company documents well below the 16MB BSON limit
+	maxEmbeddedEstabelecimentos = 5000
+)
+
+// Dictionaries holds Receita dictionary descriptions by dictionary name
+// (cnaes, motivos, municipios, naturezas, paises, qualificacoes) and code.
+type Dictionaries map[string]map[string]string
+
+// NewDictionaries indexes raw descriptions by code without leading zeros,
+// so "0049" and the integer 49 find the same entry.
+func NewDictionaries(raw map[string]map[string]string) Dictionaries {
+	d := make(Dictionaries, len(raw))
+	for name, codes := range raw {
+		m := make(map[string]string, len(codes))
+		for code, desc := range codes {
+			m[codeKey(code)] = desc
+		}
+		d[name] = m
+	}
+	return d
+}
+
+func codeKey(code string) string {
+	if k := strings.TrimLeft(strings.TrimSpace(code), "0"); k != "" {
+		return k
+	}
+	return "0"
+}
+
+func (d Dictionaries) describe(dict string, code any) (string, bool) {
+	var key string
+	switch v := code.(type) {
+	case int32:
+		key = strconv.Itoa(int(v))
+	case int64:
+		key = strconv.FormatInt(v, 10)
+	case int:
+		key = strconv.Itoa(v)
+	case string:
+		key = codeKey(v)
+	default:
+		return "", false
+	}
+	desc, ok := d[dict][key]
+	return desc, ok
+}
+
+// codeRef names the dictionary that describes a code field.
+type codeRef struct {
+	field string
+	dict  string
+}
+
+var (
+	empresaCodes = []codeRef{
+		{"natureza_juridica", "naturezas"},
+		{"qualificacao_resp", "qualificacoes"},
+	}
+	estabelecimentoCodes = []codeRef{
+		{"cnae_principal", "cnaes"},
+		{"motivo_situacao", "motivos"},
+		{"municipio", "municipios"},
+		{"pais", "paises"},
+	}
+	socioCodes = []codeRef{
+		{"qualificacao_socio", "qualificacoes"},
+		{"qualificacao_representante", "qualificacoes"},
+		{"pais", "paises"},
+	}
+)
+
+// BuildResult reports a companies build. Truncated counts companies whose
+// estabelecimentos were cut at the embedding limit.
+type BuildResult struct {
+	Collection       string        `json:"collection"`
+	Companies        int64         `json:"companies"`
+	Estabelecimentos int64         `json:"estabelecimentos"`
+	Socios           int64         `json:"socios"`
+	Truncated        int64         `json:"truncated"`
+	Duration         time.Duration `json:"duration"`
+}
+
+// Summary records the build as one document per company.
+func (r BuildResult) Summary() importer.Summary {
+	return importer.Summary{
+		Report: importer.Report{Accepted: int(r.Companies)},
+		Tables: map[string]int64{r.Collection: r.Companies},
+	}
+}
+
+// sortedCursor walks a collection in cnpj_basico order and hands out the
+// documents of one company at a time.
+type sortedCursor struct {
+	cur  *mongo.Cursor
+	head bson.D
+	key  string
+	done bool
+}
+
+func openSorted(ctx context.Context, coll *mongo.Collection, sortField string) (*sortedCursor, error) {
+	cur, err := coll.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: sortField, Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("find %s: %w", coll.Name(), err)
+	}
+	c := &sortedCursor{cur: cur}
+	return c, c.advance(ctx)
+}
+
+func (c *sortedCursor) advance(ctx context.Context) error {
+	if !c.cur.Next(ctx) {
+		c.done = true
+		return c.cur.Err()
+	}
+	c.head = nil
+	if err := c.cur.Decode(&c.head); err != nil {
+		return fmt.Errorf("decode document: %w", err)
+	}
+	c.key, _ = lookup(c.head, "cnpj_basico").(string)
+	return nil
+}
+
+// take returns the documents of the company key, which must not be
+// behind the cursor.
+func (c *sortedCursor) take(ctx context.Context, key string) ([]bson.D, error) {
+	var out []bson.D
+	for !c.done && c.key == key {
+		out = append(out, c.head)
+		if err := c.advance(ctx); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func (c *sortedCursor) close(ctx context.Context) { c.cur.Close(ctx) }
+
+func lookup(d bson.D, key string) any {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// strip drops the import bookkeeping and the fields the company document
+// already carries.
+func strip(d bson.D, drop ...string) bson.D {
+	out := make(bson.D, 0, len(d))
+	for _, e := range d {
+		if e.Key == "_id" || e.Key == batchField || e.Key == "_imported_at" || slices.Contains(drop, e.Key) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// decorate appends <field>_descricao for every code that has a description.
+func (d Dictionaries) decorate(doc bson.D, refs []codeRef) bson.D {
+	for _, ref := range refs {
+		if desc, ok := d.describe(ref.dict, lookup(doc, ref.field)); ok {
+			doc = append(doc, bson.E{Key: ref.field + "_descricao", Value: desc})
+		}
+	}
+	return doc
+}
+
+func (d Dictionaries) estabelecimento(doc bson.D) bson.D {
+	doc = d.decorate(strip(doc, "cnpj_basico"), estabelecimentoCodes)
+	if codes, ok := lookup(doc, "cnaes_secundarios").(bson.A); ok {
+		descs := make(bson.A, len(codes))
+		for i, code := range codes {
+			if desc, ok := d.describe("cnaes", code); ok {
+				descs[i] = desc
+			}
+		}
+		doc = append(doc, bson.E{Key: "cnaes_secundarios_descricao", Value: descs})
+	}
+	return doc
+}
+
+// BuildCompanies materialises one document per cnpj_basico with the empresa
+// fields, its estabelecimentos, socios and Simples options, and dictionary
+// descriptions. The four collections are merged in cnpj_basico order into
+// a scratch collection that replaces companies once it is complete and
+// indexed.
+func BuildCompanies(ctx context.Context, db *mongo.Database, dicts Dictionaries, logger zerolog.Logger) (BuildResult, error) {
+	start := time.Now()
+	res := BuildResult{Collection: CompaniesCollection}
+
+	build := db.Collection(CompaniesCollection + "_build")
+	if err := build.Drop(ctx); err != nil {
+		return res, fmt.Errorf("drop build collection: %w", err)
+	}
+
+	empresas, err := openSorted(ctx, db.Collection(Entities["empresas"].Collection), "cnpj_basico")
+	if err != nil {
+		return res, err
+	}
+	defer empresas.close(ctx)
+	// the full cnpj starts with the fixed-width básico, so its order matches
+	estabelecimentos, err := openSorted(ctx, db.Collection(Entities["estabelecimentos"].Collection), "cnpj")
+	if err != nil {
+		return res, err
+	}
+	defer estabelecimentos.close(ctx)
+	socios, err := openSorted(ctx, db.Collection(Entities["socios"].Collection), "cnpj_basico")
+	if err != nil {
+		return res, err
+	}
+	defer socios.close(ctx)
+	simples, err := openSorted(ctx, db.Collection(Entities["simples"].Collection), "cnpj_basico")
+	if err != nil {
+		return res, err
+	}
+	defer simples.close(ctx)
+
+	logger.Info().Msg("🏗️ Building companies collection")
+
+	now := time.Now()
+	docs := make([]any, 0, companiesBatchSize)
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		if _, err := build.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+			return fmt.Errorf("insert companies: %w", err)
+		}
+		docs = docs[:0]
+		return nil
+	}
+
+	cursors := []*sortedCursor{empresas, estabelecimentos, socios, simples}
+	for {
+		key, ok := "", false
+		for _, c := range cursors {
+			if !c.done && (!ok || c.key < key) {
+				key, ok = c.key, true
+			}
+		}
+		if !ok {
+			break
+		}
+
+		emp, err := empresas.take(ctx, key)
+		if err != nil {
+			return res, err
+		}
+		ests, err := estabelecimentos.take(ctx, key)
+		if err != nil {
+			return res, err
+		}
+		socs, err := socios.take(ctx, key)
+		if err != nil {
+			return res, err
+		}
+		opts, err := simples.take(ctx, key)
+		if err != nil {
+			return res, err
+		}
+
+		doc := bson.D{{Key: "_id", Value: key}, {Key: "cnpj_basico", Value: key}}
+		if len(emp) > 0 {
+			doc = append(doc, dicts.decorate(strip(emp[0], "cnpj_basico"), empresaCodes)...)
+		}
+
+		total := len(ests)
+		if total > maxEmbeddedEstabelecimentos {
+			ests = ests[:maxEmbeddedEstabelecimentos]
+			doc = append(doc, bson.E{Key: "estabelecimentos_truncados", Value: true})
+			res.Truncated++
+		}
+		embedded := make(bson.A, len(ests))
+		for i, e := range ests {
+			embedded[i] = dicts.estabelecimento(e)
+		}
+		partners := make(bson.A, len(socs))
+		for i, s := range socs {
+			partners[i] = dicts.decorate(strip(s, "cnpj_basico"), socioCodes)
+		}
+
+		doc = append(doc,
+			bson.E{Key: "estabelecimentos", Value: embedded},
+			bson.E{Key: "total_estabelecimentos", Value: total},
+			bson.E{Key: "socios", Value: partners},
+		)
+		if len(opts) > 0 {
+			doc = append(doc, bson.E{Key: "simples", Value: strip(opts[0], "cnpj_basico")})
+		}
+		doc = append(doc, bson.E{Key: "_built_at", Value: now})
+		docs = append(docs, doc)
+		res.Companies++
+		res.Estabelecimentos += int64(total)
+		res.Socios += int64(len(socs))
+
+		if len(docs) >= companiesBatchSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+		if res.Companies%500000 == 0 {
+			logger.Info().Int64("companies", res.Companies).Msg("Companies built so far")
+		}
+	}
+	if err := flush(); err != nil {
+		return res, err
+	}
+
+	if _, err := build.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "estabelecimentos.cnpj", Value: 1}}},
+		{Keys: bson.D{{Key: "razao_social", Value: 1}}},
+		{Keys: bson.D{{Key: "estabelecimentos.cnae_principal", Value: 1}}},
+		{Keys: bson.D{{Key: "estabelecimentos.uf", Value: 1}, {Key: "estabelecimentos.municipio", Value: 1}}},
+		{Keys: bson.D{{Key: "socios.cnpj_cpf_socio", Value: 1}}},
+		{
+			Keys: bson.D{
+				{Key: "razao_social", Value: "text"},
+				{Key: "estabelecimentos.nome_fantasia", Value: "text"},
+				{Key: "socios.nome_socio", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("companies_search").
+				SetDefaultLanguage("portuguese").
+				SetWeights(bson.D{{Key: "razao_social", Value: 3}, {Key: "estabelecimentos.nome_fantasia", Value: 2}, {Key: "socios.nome_socio", Value: 1}}),
+		},
+	}); err != nil {
+		return res, fmt.Errorf("create companies indexes: %w", err)
+	}
+
+	rename := bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + build.Name()},
+		{Key: "to", Value: db.Name() + "." + CompaniesCollection},
+		{Key: "dropTarget", Value: true},
+	}
+	if err := db.Client().Database("admin").RunCommand(ctx, rename).Err(); err != nil {
+		return res, fmt.Errorf("swap companies collection: %w", err)
+	}
+
+	res.Duration = time.Since(start)
+	logger.Info().Int64("companies", res.Companies).Int64("truncated", res.Truncated).Dur("duration", res.Duration).Msg("🎉 Companies collection built")
+	return res, nil
+}
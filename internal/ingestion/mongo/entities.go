@@ -0,0 +1,297 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/csvx"
+)
+
+// Entity describes a Receita file family and the collection it is imported
+// into. Key lists the layout fields that identify a document; reimports
+// replace the document with the same key.
+type Entity struct {
+	Name       string
+	Collection string
+	Pattern    *regexp.Regexp
+	Layout     layout.Layout
+	Key        []string
+}
+
+// Entities lists the Receita files that have a Mongo importer, keyed by name.
+var Entities = map[string]Entity{
+	"empresas": {
+		Name:       "empresas",
+		Collection: "empresas",
+		Pattern:    regexp.MustCompile(`^Empresas\d*\.zip$`),
+		Layout:     layout.Empresas,
+		Key:        []string{"cnpj_basico"},
+	},
+	"estabelecimentos": {
+		Name:       "estabelecimentos",
+		Collection: "estabelecimentos",
+		Pattern:    regexp.MustCompile(`^Estabelecimentos\d*\.zip$`),
+		Layout:     layout.Estabelecimentos,
+		Key:        []string{"cnpj"},
+	},
+	"socios": {
+		Name:       "socios",
+		Collection: "socios",
+		Pattern:    regexp.MustCompile(`^Socios\d*\.zip$`),
+		Layout:     layout.Socios,
+		// CPFs are masked in the open data, so the name is part of the key
+		Key: []string{"cnpj_basico", "identificador_socio", "cnpj_cpf_socio", "nome_socio", "qualificacao_socio"},
+	},
+	"simples": {
+		Name:       "simples",
+		Collection: "simples",
+		Pattern:    regexp.MustCompile(`^Simples\.zip$`),
+		Layout:     layout.Simples,
+		Key:        []string{"cnpj_basico"},
+	},
+}
+
+// EntityNames is the order the pipeline imports entities in.
+var EntityNames = []string{"empresas", "estabelecimentos", "socios", "simples"}
+
+type FileResult struct {
+	File             string         `json:"file"`
+	Rows             int            `json:"rows"`
+	Skipped          int            `json:"skipped"`
+	ConversionErrors map[string]int `json:"conversion_errors,omitempty"`
+	Duration         time.Duration  `json:"duration"`
+}
+
+// ImportResult reports an entity import. Stale counts documents of the
+// collection that were not part of the batch; Removed is how many of them
+// were deleted when pruning was requested.
+type ImportResult struct {
+	importer.Report
+	Entity     string       `json:"entity"`
+	Collection string       `json:"collection"`
+	Batch      string       `json:"batch,omitempty"`
+	Files      []FileResult `json:"files"`
+	Rows       int          `json:"rows"`
+	Stale      int64        `json:"stale"`
+	Removed    int64        `json:"removed"`
+}
+
+func (r ImportResult) Summary() importer.Summary {
+	sum := importer.Summary{Report: r.Report, Tables: map[string]int64{r.Collection: int64(r.Rows)}}
+	for _, f := range r.Files {
+		sum.Files = append(sum.Files, f.File)
+	}
+	return sum
+}
+
+// Files lists the zips of the entity found in dir, e.g. Empresas0.zip to Empresas9.zip.
+func (e Entity) Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+	var files []string
+	for _, de := range entries {
+		if !de.IsDir() && e.Pattern.MatchString(de.Name()) {
+			files = append(files, filepath.Join(dir, de.Name()))
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// ImportOptions tunes an entity import. Batch tags the documents and Prune
+// deletes documents from other batches. Encoding overrides the encoding of
+// the entity layout. Rejects configures the quarantine of rejected rows and
+// Parallel how many files are imported at once.
+type ImportOptions struct {
+	Batch    string
+	Prune    bool
+	Encoding string
+	Rejects  importer.Rejects
+	Parallel importer.Parallelism
+}
+
+// ImportEntity upserts every zip of the entity found in dir and reports the
+// row count of each file. Documents are tagged with the batch; once all
+// files are in, documents left from other batches are counted and, with
+// Prune, deleted.
+func ImportEntity(ctx context.Context, db *mongo.Database, name, dir string, opts ImportOptions, logger zerolog.Logger) (res ImportResult, err error) {
+	e, ok := Entities[name]
+	if !ok {
+		return ImportResult{}, fmt.Errorf("unknown entity %q", name)
+	}
+	if opts.Encoding != "" {
+		if _, err := csvx.ParseEncoding(opts.Encoding); err != nil {
+			return ImportResult{}, err
+		}
+		e.Layout.Encoding = opts.Encoding
+	}
+	batch, prune := opts.Batch, opts.Prune
+	res = ImportResult{Entity: e.Name, Collection: e.Collection, Batch: batch, Files: []FileResult{}}
+	quarantine := importer.NewQuarantine(opts.Rejects, "mongo-"+e.Name)
+	defer func() {
+		quarantine.Close()
+		res.Quarantine = quarantine.Path()
+	}()
+
+	files, err := e.Files(dir)
+	if err != nil {
+		return res, err
+	}
+	if len(files) == 0 {
+		logger.Warn().Str("entity", e.Name).Str("dir", dir).Msg("No files found for entity")
+	}
+
+	coll := db.Collection(e.Collection)
+	var mu sync.Mutex
+	results := make([]*FileResult, len(files))
+	budget := importer.NewBudget(opts.Parallel.MemoryBudget)
+	err = importer.ForEach(ctx, opts.Parallel.Workers, files, func(ctx context.Context, i int, path string) error {
+		start := time.Now()
+		logger.Info().Str("entity", e.Name).Str("file", filepath.Base(path)).Str("batch", batch).Msg("Importing into Mongo")
+
+		stats, err := ImportZip(ctx, coll, e, batch, path, quarantine, budget, logger)
+		fr := FileResult{File: filepath.Base(path)}
+		for _, st := range stats {
+			fr.Rows += st.Rows
+			fr.Skipped += st.Skipped
+			for field, n := range st.ConversionErrors {
+				if fr.ConversionErrors == nil {
+					fr.ConversionErrors = make(map[string]int)
+				}
+				fr.ConversionErrors[field] += n
+			}
+		}
+		fr.Duration = time.Since(start)
+		mu.Lock()
+		res.Add(stats...)
+		results[i] = &fr
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("import %s: %w", filepath.Base(path), err)
+		}
+		return nil
+	})
+	for _, fr := range results {
+		if fr != nil {
+			res.Rows += fr.Rows
+			res.Files = append(res.Files, *fr)
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
+	if batch == "" || len(files) == 0 {
+		return res, nil
+	}
+	stale := bson.M{batchField: bson.M{"$ne": batch}}
+	if res.Stale, err = coll.CountDocuments(ctx, stale); err != nil {
+		return res, fmt.Errorf("count stale documents: %w", err)
+	}
+	if prune && res.Stale > 0 {
+		dr, err := coll.DeleteMany(ctx, stale)
+		if err != nil {
+			return res, fmt.Errorf("remove stale documents: %w", err)
+		}
+		res.Removed = dr.DeletedCount
+	}
+	if res.Stale > 0 {
+		logger.Info().Str("entity", e.Name).Str("batch", batch).Int64("stale", res.Stale).Int64("removed", res.Removed).Msg("Documents missing from batch")
+	}
+	return res, nil
+}
+
+// Index names are fixed so a change of key is applied by replacing the
+// index, not by adding a second one next to it.
+const (
+	naturalKeyIndex = "uniq_natural_key"
+	batchIndex      = "idx_batch"
+)
+
+// EnsureIndexes creates the unique natural-key index of every entity.
+// Indexes left by an older key, or with the same keys under another name,
+// are dropped first; Mongo refuses to create them otherwise.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	for _, name := range EntityNames {
+		e := Entities[name]
+		keys := bson.D{}
+		for _, k := range e.Key {
+			keys = append(keys, bson.E{Key: k, Value: 1})
+		}
+		models := []mongo.IndexModel{
+			{Keys: keys, Options: options.Index().SetUnique(true).SetName(naturalKeyIndex)},
+			{Keys: bson.D{{Key: batchField, Value: 1}}, Options: options.Index().SetName(batchIndex)},
+		}
+		coll := db.Collection(e.Collection)
+		if err := dropConflictingIndexes(ctx, coll, models); err != nil {
+			return fmt.Errorf("drop old indexes on %s: %w", e.Collection, err)
+		}
+		if _, err := coll.Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("create indexes on %s: %w", e.Collection, err)
+		}
+	}
+	return nil
+}
+
+// dropConflictingIndexes drops the indexes that share a name with one of
+// models but not its keys, or its keys but not its name.
+func dropConflictingIndexes(ctx context.Context, coll *mongo.Collection, models []mongo.IndexModel) error {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var existing []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+	}
+	if err := cur.All(ctx, &existing); err != nil {
+		return err
+	}
+	for _, idx := range existing {
+		for _, m := range models {
+			name, keys := *m.Options.Name, m.Keys.(bson.D)
+			if idx.Name == name && sameKeys(idx.Key, keys) {
+				break
+			}
+			if idx.Name == name || sameKeys(idx.Key, keys) {
+				if _, err := coll.Indexes().DropOne(ctx, idx.Name); err != nil {
+					return fmt.Errorf("drop %s: %w", idx.Name, err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// sameKeys compares index keys regardless of the integer type the server
+// returned the directions in.
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || fmt.Sprint(a[i].Value) != fmt.Sprint(b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/csvx"
+)
+
+// RepairResult reports an encoding repair. Fields counts the repaired
+// values per field; with DryRun nothing was written.
+type RepairResult struct {
+	Entity     string         `json:"entity"`
+	Collection string         `json:"collection"`
+	DryRun     bool           `json:"dry_run"`
+	Scanned    int64          `json:"scanned"`
+	Repaired   int64          `json:"repaired"`
+	Fields     map[string]int `json:"fields"`
+}
+
+// RepairEncoding rewrites the text fields of documents imported before the
+// Receita files were decoded, turning raw Latin-1 bytes and double-decoded
+// UTF-8 back into proper text.
+func RepairEncoding(ctx context.Context, db *mongo.Database, name string, dryRun bool, logger zerolog.Logger) (RepairResult, error) {
+	e, ok := Entities[name]
+	if !ok {
+		return RepairResult{}, fmt.Errorf("unknown entity %q", name)
+	}
+	res := RepairResult{Entity: e.Name, Collection: e.Collection, DryRun: dryRun, Fields: map[string]int{}}
+
+	var fields []string
+	projection := bson.D{}
+	for _, f := range e.Layout.Fields {
+		if f.Type == layout.String {
+			fields = append(fields, f.Name)
+			projection = append(projection, bson.E{Key: f.Name, Value: 1})
+		}
+	}
+
+	coll := db.Collection(e.Collection)
+	cur, err := coll.Find(ctx, bson.D{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return res, fmt.Errorf("find documents: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 || dryRun {
+			models = models[:0]
+			return nil
+		}
+		if _, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("write repairs: %w", err)
+		}
+		models = models[:0]
+		return nil
+	}
+
+	for cur.Next(ctx) {
+		res.Scanned++
+		set := bson.D{}
+		for _, field := range fields {
+			v, err := cur.Current.LookupErr(field)
+			if err != nil || v.Type != bsontype.String {
+				continue
+			}
+			if fixed, changed := csvx.RepairMojibake(v.StringValue()); changed {
+				set = append(set, bson.E{Key: field, Value: fixed})
+				res.Fields[field]++
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		res.Repaired++
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: cur.Current.Lookup("_id")}}).
+			SetUpdate(bson.D{{Key: "$set", Value: set}}))
+		if len(models) >= batchSize {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return res, fmt.Errorf("scan documents: %w", err)
+	}
+	if err := flush(); err != nil {
+		return res, err
+	}
+
+	logger.Info().Str("entity", e.Name).Int64("scanned", res.Scanned).Int64("repaired", res.Repaired).Bool("dry_run", dryRun).Msg("🔤 Encoding repair finished")
+	return res, nil
+}
@@ -0,0 +1,235 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+)
+
+const importRunsTable = "ingestion.import_runs"
+
+const (
+	RunRunning = "running"
+	RunSuccess = "success"
+	RunFailed  = "failed"
+)
+
+// ImportRun is a row of ingestion.import_runs. Tables counts the rows
+// written per table or collection; Result is the full import result.
+type ImportRun struct {
+	ID            int64            `json:"id"`
+	Kind          string           `json:"kind" example:"cnpj empresas"`
+	Trigger       string           `json:"trigger" example:"pipeline"`
+	Batch         string           `json:"batch,omitempty" example:"2025-09"`
+	Status        string           `json:"status" example:"success"`
+	Files         []string         `json:"files"`
+	Tables        map[string]int64 `json:"tables"`
+	Accepted      int64            `json:"accepted"`
+	Rejected      int64            `json:"rejected"`
+	Quarantine    string           `json:"quarantine,omitempty"`
+	Error         string           `json:"error,omitempty"`
+	StartedAt     time.Time        `json:"started_at"`
+	FinishedAt    *time.Time       `json:"finished_at,omitempty"`
+	DurationMS    *int64           `json:"duration_ms,omitempty"`
+	RowsPerSecond *float64         `json:"rows_per_second,omitempty"`
+	Result        json.RawMessage  `json:"result,omitempty" swaggertype:"object"`
+}
+
+// ImportRunFilter narrows a run listing. BeforeID pages backwards from a
+// run id; Limit defaults to 50.
+type ImportRunFilter struct {
+	Kind     string
+	Status   string
+	BeforeID int64
+	Limit    uint64
+}
+
+type ImportRunRepo struct {
+	conn DB
+	psql sq.StatementBuilderType
+}
+
+func NewImportRunRepo(conn DB) *ImportRunRepo {
+	return &ImportRunRepo{
+		conn: conn,
+		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// Start inserts a running run and sets its id and start time.
+func (r *ImportRunRepo) Start(ctx context.Context, run *ImportRun) error {
+	sql, args, err := r.psql.Insert(importRunsTable).
+		Columns("kind", "trigger", "batch", "status").
+		Values(run.Kind, run.Trigger, sq.Expr("NULLIF(?, '')", run.Batch), RunRunning).
+		Suffix("RETURNING id, started_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	run.Status = RunRunning
+	if err := r.conn.QueryRow(ctx, sql, args...).Scan(&run.ID, &run.StartedAt); err != nil {
+		return fmt.Errorf("insert import run: %w", err)
+	}
+	return nil
+}
+
+// Finish stores the outcome of a started run.
+func (r *ImportRunRepo) Finish(ctx context.Context, run *ImportRun) error {
+	tables, err := json.Marshal(run.Tables)
+	if err != nil {
+		return err
+	}
+	sql, args, err := r.psql.Update(importRunsTable).SetMap(map[string]any{
+		"status":          run.Status,
+		"files":           run.Files,
+		"tables":          string(tables),
+		"accepted":        run.Accepted,
+		"rejected":        run.Rejected,
+		"quarantine":      sq.Expr("NULLIF(?, '')", run.Quarantine),
+		"result":          nullJSON(run.Result),
+		"error":           sq.Expr("NULLIF(?, '')", run.Error),
+		"finished_at":     run.FinishedAt,
+		"duration_ms":     run.DurationMS,
+		"rows_per_second": run.RowsPerSecond,
+	}).Where(sq.Eq{"id": run.ID}).ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := r.conn.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("update import run %d: %w", run.ID, err)
+	}
+	return nil
+}
+
+func nullJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+func (r *ImportRunRepo) runs(withResult bool) sq.SelectBuilder {
+	result := "NULL::jsonb"
+	if withResult {
+		result = "result"
+	}
+	return r.psql.Select("id", "kind", "trigger", "COALESCE(batch, '')", "status", "files", "tables",
+		"accepted", "rejected", "COALESCE(quarantine, '')", "COALESCE(error, '')",
+		"started_at", "finished_at", "duration_ms", "rows_per_second", result).
+		From(importRunsTable)
+}
+
+func scanRun(row pgx.CollectableRow) (ImportRun, error) {
+	var run ImportRun
+	var result []byte
+	err := row.Scan(&run.ID, &run.Kind, &run.Trigger, &run.Batch, &run.Status, &run.Files, &run.Tables,
+		&run.Accepted, &run.Rejected, &run.Quarantine, &run.Error,
+		&run.StartedAt, &run.FinishedAt, &run.DurationMS, &run.RowsPerSecond, &result)
+	run.Result = result
+	return run, err
+}
+
+// List returns runs, newest first, without their full results.
+func (r *ImportRunRepo) List(ctx context.Context, f ImportRunFilter) ([]ImportRun, error) {
+	if f.Limit == 0 {
+		f.Limit = 50
+	}
+	q := r.runs(false).OrderBy("id DESC").Limit(f.Limit)
+	if f.Kind != "" {
+		q = q.Where(sq.Eq{"kind": f.Kind})
+	}
+	if f.Status != "" {
+		q = q.Where(sq.Eq{"status": f.Status})
+	}
+	if f.BeforeID > 0 {
+		q = q.Where(sq.Lt{"id": f.BeforeID})
+	}
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query import runs: %w", err)
+	}
+	return pgx.CollectRows(rows, scanRun)
+}
+
+// Get returns a run with its full result, or pgx.ErrNoRows.
+func (r *ImportRunRepo) Get(ctx context.Context, id int64) (ImportRun, error) {
+	sql, args, err := r.runs(true).Where(sq.Eq{"id": id}).ToSql()
+	if err != nil {
+		return ImportRun{}, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return ImportRun{}, fmt.Errorf("query import run: %w", err)
+	}
+	return pgx.CollectExactlyOneRow(rows, scanRun)
+}
+
+type triggerKey struct{}
+
+// WithTrigger tags the imports run with ctx as started by trigger
+// (pipeline, cli); imports without one are recorded as api.
+func WithTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+func triggerOf(ctx context.Context) string {
+	if t, ok := ctx.Value(triggerKey{}).(string); ok {
+		return t
+	}
+	return "api"
+}
+
+// RecordImport runs an import and records it in ingestion.import_runs.
+// Recording failures are logged and never fail the import.
+func RecordImport(ctx context.Context, repo *ImportRunRepo, kind, batch string, logger zerolog.Logger, fn func() (importer.Summarizer, error)) error {
+	start := time.Now()
+	run := &ImportRun{Kind: kind, Trigger: triggerOf(ctx), Batch: batch, StartedAt: start}
+	if err := repo.Start(ctx, run); err != nil {
+		logger.Warn().Err(err).Str("kind", kind).Msg("⚠️ Could not record import run")
+	}
+
+	res, err := fn()
+
+	now := time.Now()
+	run.FinishedAt = &now
+	duration := now.Sub(start).Milliseconds()
+	run.DurationMS = &duration
+	run.Status = RunSuccess
+	if err != nil {
+		run.Status, run.Error = RunFailed, err.Error()
+	}
+	if res != nil {
+		sum := res.Summary()
+		run.Files, run.Tables = sum.Files, sum.Tables
+		run.Accepted, run.Rejected, run.Quarantine = int64(sum.Accepted), int64(sum.Rejected), sum.Quarantine
+		if duration > 0 {
+			rate := float64(sum.Accepted) / (float64(duration) / 1000)
+			run.RowsPerSecond = &rate
+		}
+		if raw, err := json.Marshal(res); err == nil {
+			run.Result = raw
+		}
+	}
+	if run.Files == nil {
+		run.Files = []string{}
+	}
+
+	if run.ID != 0 {
+		// the import context may be canceled already
+		if err := repo.Finish(context.WithoutCancel(ctx), run); err != nil {
+			logger.Warn().Err(err).Int64("run", run.ID).Msg("⚠️ Could not record import run")
+		}
+	}
+	return err
+}
@@ -0,0 +1,352 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
+)
+
+// cnpjBatchSize is the number of rows sent per COPY.
+const cnpjBatchSize = 50000
+
+// CNPJTable maps a Receita file family to its table in the cnpj schema.
+// Codes names the dictionary table each code column references.
+type CNPJTable struct {
+	Name    string
+	Table   string
+	Pattern *regexp.Regexp
+	Layout  layout.Layout
+	Codes   map[string]string
+}
+
+var CNPJTables = map[string]CNPJTable{
+	"empresas": {
+		Name:    "empresas",
+		Table:   "cnpj.empresas",
+		Pattern: regexp.MustCompile(`^Empresas\d*\.zip$`),
+		Layout:  layout.Empresas,
+		Codes: map[string]string{
+			"natureza_juridica": "dictionaries.naturezas",
+			"qualificacao_resp": "dictionaries.qualificacoes",
+		},
+	},
+	"estabelecimentos": {
+		Name:    "estabelecimentos",
+		Table:   "cnpj.estabelecimentos",
+		Pattern: regexp.MustCompile(`^Estabelecimentos\d*\.zip$`),
+		Layout:  layout.Estabelecimentos,
+		Codes: map[string]string{
+			"motivo_situacao": "dictionaries.motivos",
+			"pais":            "dictionaries.paises",
+			"cnae_principal":  "dictionaries.cnaes",
+			"municipio":       "dictionaries.municipios",
+		},
+	},
+	"socios": {
+		Name:    "socios",
+		Table:   "cnpj.socios",
+		Pattern: regexp.MustCompile(`^Socios\d*\.zip$`),
+		Layout:  layout.Socios,
+		Codes: map[string]string{
+			"qualificacao_socio":         "dictionaries.qualificacoes",
+			"pais":                       "dictionaries.paises",
+			"qualificacao_representante": "dictionaries.qualificacoes",
+		},
+	},
+	"simples": {
+		Name:    "simples",
+		Table:   "cnpj.simples",
+		Pattern: regexp.MustCompile(`^Simples\.zip$`),
+		Layout:  layout.Simples,
+	},
+}
+
+// CNPJTableNames is the order the pipeline loads the tables in.
+var CNPJTableNames = []string{"empresas", "estabelecimentos", "socios", "simples"}
+
+type CNPJFileResult struct {
+	File             string         `json:"file"`
+	Rows             int            `json:"rows"`
+	Skipped          int            `json:"skipped"`
+	ConversionErrors map[string]int `json:"conversion_errors,omitempty"`
+}
+
+// CNPJImportResult reports a table load. UnknownCodes counts, per column,
+// codes missing from their dictionary that were stored as NULL.
+type CNPJImportResult struct {
+	importer.Report
+	Entity       string           `json:"entity"`
+	Table        string           `json:"table"`
+	Files        []CNPJFileResult `json:"files"`
+	Rows         int64            `json:"rows"`
+	UnknownCodes map[string]int   `json:"unknown_codes,omitempty"`
+	Swap         SwapResult       `json:"swap"`
+	Duration     time.Duration    `json:"duration"`
+}
+
+func (r CNPJImportResult) Summary() importer.Summary {
+	sum := importer.Summary{Report: r.Report, Tables: map[string]int64{r.Table: r.Rows}}
+	for _, f := range r.Files {
+		sum.Files = append(sum.Files, f.File)
+	}
+	return sum
+}
+
+// Files lists the zips of the table found in dir.
+func (t CNPJTable) Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+	var files []string
+	for _, de := range entries {
+		if !de.IsDir() && t.Pattern.MatchString(de.Name()) {
+			files = append(files, filepath.Join(dir, de.Name()))
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+type CNPJRepo struct {
+	conn DB
+	psql sq.StatementBuilderType
+}
+
+func NewCNPJRepo(conn DB) *CNPJRepo {
+	return &CNPJRepo{
+		conn: conn,
+		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// dictionaryCodes maps Receita codes, without leading zeros, to the codes
+// of a dictionary table, so parsed integers find their zero-padded code.
+func (r *CNPJRepo) dictionaryCodes(ctx context.Context, table string) (map[string]string, error) {
+	sql, args, err := r.psql.Select("code").From(table).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	codes := make(map[string]string)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", table, err)
+		}
+		codes[codeKey(code)] = code
+	}
+	return codes, rows.Err()
+}
+
+func codeKey(code string) string {
+	if k := strings.TrimLeft(strings.TrimSpace(code), "0"); k != "" {
+		return k
+	}
+	return "0"
+}
+
+// copier is satisfied by both connections and transactions.
+type copier interface {
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+}
+
+// cnpjSink copies parsed rows into a table, resolving code columns to
+// dictionary codes.
+type cnpjSink struct {
+	conn    copier
+	table   pgx.Identifier
+	columns []string
+	codes   map[int]map[string]string
+	unknown map[string]int
+	rows    [][]any
+	copied  int64
+}
+
+func newCNPJSink(conn copier, table pgx.Identifier, t CNPJTable, dicts map[string]map[string]string) *cnpjSink {
+	s := &cnpjSink{
+		conn:    conn,
+		table:   table,
+		columns: t.Layout.Names(),
+		codes:   make(map[int]map[string]string),
+		unknown: make(map[string]int),
+	}
+	for field, dict := range t.Codes {
+		s.codes[t.Layout.Index(field)] = dicts[dict]
+	}
+	return s
+}
+
+func (s *cnpjSink) Write(ctx context.Context, rows []layout.Row) error {
+	s.rows = s.rows[:0]
+	for _, row := range rows {
+		values := make([]any, len(row))
+		for i, v := range row {
+			values[i] = s.value(i, v)
+		}
+		s.rows = append(s.rows, values)
+	}
+	n, err := s.conn.CopyFrom(ctx, s.table, s.columns, pgx.CopyFromRows(s.rows))
+	s.copied += n
+	return err
+}
+
+func (s *cnpjSink) value(i int, v any) any {
+	if codes, ok := s.codes[i]; ok && v != nil {
+		var key string
+		switch c := v.(type) {
+		case int:
+			key = strconv.Itoa(c)
+		case string:
+			key = codeKey(c)
+		}
+		if code, ok := codes[key]; ok {
+			return code
+		}
+		s.unknown[s.columns[i]]++
+		return nil
+	}
+	switch c := v.(type) {
+	case string:
+		// Postgres rejects NUL bytes in text, and a few Receita rows carry them
+		return strings.ReplaceAll(c, "\x00", "")
+	case layout.DecimalValue:
+		var n pgtype.Numeric
+		if err := n.Scan(string(c)); err != nil {
+			return nil
+		}
+		return n
+	}
+	return v
+}
+
+// ImportCNPJTable replaces the content of a cnpj table with every zip of
+// the entity found in dir. Rows are copied into a staging table that is
+// swapped with the live one once complete, so readers see either the old
+// or the new data. Files are copied concurrently as configured by opts.
+func ImportCNPJTable(ctx context.Context, repo *CNPJRepo, name, dir string, opts ImportOptions, logger zerolog.Logger) (res CNPJImportResult, err error) {
+	start := time.Now()
+	t, ok := CNPJTables[name]
+	if !ok {
+		return CNPJImportResult{}, fmt.Errorf("unknown entity %q", name)
+	}
+	res = CNPJImportResult{Entity: t.Name, Table: t.Table, Files: []CNPJFileResult{}}
+	quarantine := importer.NewQuarantine(opts.Rejects, "cnpj-"+t.Name)
+	defer func() {
+		quarantine.Close()
+		res.Quarantine = quarantine.Path()
+	}()
+
+	files, err := t.Files(dir)
+	if err != nil {
+		return res, err
+	}
+	if len(files) == 0 {
+		logger.Warn().Str("entity", t.Name).Str("dir", dir).Msg("No files found for entity")
+		return res, nil
+	}
+
+	dicts := make(map[string]map[string]string)
+	for _, dict := range t.Codes {
+		if _, ok := dicts[dict]; ok {
+			continue
+		}
+		if dicts[dict], err = repo.dictionaryCodes(ctx, dict); err != nil {
+			return res, err
+		}
+	}
+
+	unlock, err := lockReload(ctx, repo.conn, t.Table)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	swap := newSwapTable(t.Table)
+	if err := swap.prepare(ctx, repo.conn); err != nil {
+		return res, err
+	}
+
+	var (
+		mu      sync.Mutex
+		copied  int64
+		unknown = make(map[string]int)
+		results = make([]*CNPJFileResult, len(files))
+	)
+	budget := importer.NewBudget(opts.Parallel.MemoryBudget)
+	err = importer.ForEach(ctx, opts.workers(), files, func(ctx context.Context, i int, path string) error {
+		return opts.withConn(ctx, repo.conn, func(conn DB) error {
+			logger.Info().Str("entity", t.Name).Str("file", filepath.Base(path)).Msg("Copying into Postgres")
+			sink := newCNPJSink(conn, swap.ident(stagingSuffix), t, dicts)
+			im := importer.New(t.Layout, sink, cnpjBatchSize, logger)
+			im.Quarantine, im.Budget = quarantine, budget
+			stats, err := im.ImportZip(ctx, path)
+
+			fr := CNPJFileResult{File: filepath.Base(path)}
+			for _, st := range stats {
+				fr.Rows += st.Rows
+				fr.Skipped += st.Skipped
+				for field, n := range st.ConversionErrors {
+					if fr.ConversionErrors == nil {
+						fr.ConversionErrors = make(map[string]int)
+					}
+					fr.ConversionErrors[field] += n
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			res.Add(stats...)
+			results[i] = &fr
+			copied += sink.copied
+			for col, n := range sink.unknown {
+				unknown[col] += n
+			}
+			if err != nil {
+				return fmt.Errorf("import %s: %w", filepath.Base(path), err)
+			}
+			return nil
+		})
+	})
+	for _, fr := range results {
+		if fr != nil {
+			res.Files = append(res.Files, *fr)
+			res.Rows += int64(fr.Rows)
+		}
+	}
+	if len(unknown) > 0 {
+		res.UnknownCodes = unknown
+	}
+	if err != nil {
+		return res, err
+	}
+
+	if res.Swap, err = swap.finish(ctx, repo.conn, copied, logger); err != nil {
+		return res, fmt.Errorf("swap %s: %w", t.Table, err)
+	}
+	res.Duration = time.Since(start)
+	logger.Info().Str("table", t.Table).Int64("rows", res.Rows).Int("rejected", res.Rejected).Interface("unknown_codes", res.UnknownCodes).Dur("duration", res.Duration).Msg("🎯 CNPJ table loaded")
+	return res, nil
+}
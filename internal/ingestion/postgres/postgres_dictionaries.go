@@ -1,35 +1,39 @@
 package ingestion
 
 import (
-	"archive/zip"
 	"context"
-	"encoding/csv"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
 
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/transform"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
 )
 
 type DictionaryDTO struct {
-	ID          int64
+	Code        string
 	Description string
 }
 
+// DictionaryEntry is a dictionary row as served by the API. Numeric is the
+// code as a number, for the dictionaries that have one.
+type DictionaryEntry struct {
+	Code        string `json:"code" example:"0111301"`
+	Numeric     *int   `json:"numeric,omitempty"`
+	Description string `json:"description" example:"Cultivo de arroz"`
+}
+
 type DictionaryRepo struct {
-	conn *pgx.Conn
+	conn DB
 	psql sq.StatementBuilderType
 }
 
-func NewDictionaryRepo(conn *pgx.Conn) *DictionaryRepo {
+func NewDictionaryRepo(conn DB) *DictionaryRepo {
 	return &DictionaryRepo{
 		conn: conn,
 		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
@@ -41,9 +45,9 @@ func (r *DictionaryRepo) InsertBatch(ctx context.Context, table string, records
 
 	for _, rec := range records {
 		sql, args, err := r.psql.Insert(table).
-			Columns("id", "description").
-			Values(rec.ID, rec.Description).
-			Suffix("ON CONFLICT (id) DO UPDATE SET description = EXCLUDED.description").
+			Columns("code", "description").
+			Values(rec.Code, rec.Description).
+			Suffix("ON CONFLICT (code) DO UPDATE SET description = EXCLUDED.description").
 			ToSql()
 		if err != nil {
 			return err
@@ -55,94 +59,162 @@ func (r *DictionaryRepo) InsertBatch(ctx context.Context, table string, records
 	return br.Close()
 }
 
-func normalizeID(raw string) (int64, error) {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return 0, fmt.Errorf("empty id")
+// Descriptions returns the descriptions of a dictionary table keyed by the
+// code as published by Receita.
+func (r *DictionaryRepo) Descriptions(ctx context.Context, table string) (map[string]string, error) {
+	sql, args, err := r.psql.Select("code", "description").From(table).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var code, desc string
+		if err := rows.Scan(&code, &desc); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", table, err)
+		}
+		out[code] = desc
+	}
+	return out, rows.Err()
+}
+
+// entries selects dictionary entries; cnaes have no numeric code.
+func (r *DictionaryRepo) entries(table string) sq.SelectBuilder {
+	numeric := "code_num"
+	if table == "dictionaries.cnaes" {
+		numeric = "NULL::integer"
 	}
-	prefixed := "1" + raw
-	return strconv.ParseInt(prefixed, 10, 64)
+	return r.psql.Select("code", numeric, "description").From(table)
+}
+
+func scanEntry(row pgx.CollectableRow) (DictionaryEntry, error) {
+	var e DictionaryEntry
+	err := row.Scan(&e.Code, &e.Numeric, &e.Description)
+	return e, err
 }
 
-func ImportDictionaryZip(ctx context.Context, repo *DictionaryRepo, zipPath, table string, logger zerolog.Logger) error {
-	r, err := zip.OpenReader(zipPath)
+// Entries lists a dictionary table ordered by code.
+func (r *DictionaryRepo) Entries(ctx context.Context, table string) ([]DictionaryEntry, error) {
+	sql, args, err := r.entries(table).OrderBy("code").ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
 	if err != nil {
-		return fmt.Errorf("open zip: %w", err)
+		return nil, fmt.Errorf("query %s: %w", table, err)
 	}
-	defer r.Close()
+	return pgx.CollectRows(rows, scanEntry)
+}
 
-	for _, f := range r.File {
-		rc, err := f.Open()
-		if err != nil {
-			return fmt.Errorf("open file inside zip: %w", err)
-		}
-		defer rc.Close()
-
-		utf8Reader := transform.NewReader(rc, charmap.ISO8859_1.NewDecoder())
-
-		reader := csv.NewReader(utf8Reader)
-		reader.Comma = ';'
-		reader.LazyQuotes = true
-
-		var batch []DictionaryDTO
-
-		for {
-			row, err := reader.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("read row: %w", err)
-			}
-			if len(row) < 2 {
-				continue
-			}
-
-			id, err := normalizeID(row[0])
-			if err != nil {
-				logger.Warn().Str("id", row[0]).Msg("Skipping invalid id")
-				continue
-			}
-
-			desc := strings.TrimSpace(row[1])
-
-			batch = append(batch, DictionaryDTO{ID: id, Description: desc})
-
-			if len(batch) >= 5000 {
-				if err := repo.InsertBatch(ctx, table, batch); err != nil {
-					return err
-				}
-				batch = batch[:0]
-			}
-		}
+// Entry finds a code in a dictionary table, ignoring leading zeros so "49"
+// finds "049". It returns pgx.ErrNoRows when the code is unknown.
+func (r *DictionaryRepo) Entry(ctx context.Context, table, code string) (DictionaryEntry, error) {
+	sql, args, err := r.entries(table).Where("ltrim(code, '0') = ltrim(?, '0')", strings.TrimSpace(code)).Limit(1).ToSql()
+	if err != nil {
+		return DictionaryEntry{}, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return DictionaryEntry{}, fmt.Errorf("query %s: %w", table, err)
+	}
+	return pgx.CollectExactlyOneRow(rows, scanEntry)
+}
 
-		if len(batch) > 0 {
-			if err := repo.InsertBatch(ctx, table, batch); err != nil {
-				return err
-			}
-		}
+// dictionarySink stores dictionary rows in a single table.
+type dictionarySink struct {
+	repo  *DictionaryRepo
+	table string
+}
+
+func (s *dictionarySink) Write(ctx context.Context, rows []layout.Row) error {
+	records := make([]DictionaryDTO, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, DictionaryDTO{Code: row[0].(string), Description: row[1].(string)})
 	}
+	return s.repo.InsertBatch(ctx, s.table, records)
+}
+
+// ImportDictionaryZip upserts a dictionary zip into table. Rejected rows go
+// to quarantine, which may be nil.
+func ImportDictionaryZip(ctx context.Context, repo *DictionaryRepo, zipPath, table string, quarantine *importer.Quarantine, logger zerolog.Logger) ([]importer.FileStats, error) {
+	im := importer.New(layout.Dictionary, &dictionarySink{repo: repo, table: table}, 5000, logger)
+	im.Quarantine = quarantine
+	return im.ImportZip(ctx, zipPath)
+}
 
-	return nil
+// DictionaryImportResult reports a dictionaries import; Tables counts the
+// rows upserted per table.
+type DictionaryImportResult struct {
+	importer.Report
+	Files  []string       `json:"files"`
+	Tables map[string]int `json:"tables"`
 }
 
-func ImportAllDictionaries(ctx context.Context, repo *DictionaryRepo, baseDir string, logger zerolog.Logger) error {
-	files := []struct {
-		Name  string
-		Table string
-	}{
-		{"Cnaes.zip", "dictionaries.cnaes"},
-		{"Motivos.zip", "dictionaries.motivos"},
-		{"Qualificacoes.zip", "dictionaries.qualificacoes"},
-		{"Municipios.zip", "dictionaries.municipios"},
-		{"Paises.zip", "dictionaries.paises"},
-		{"Naturezas.zip", "dictionaries.naturezas"},
+func (r DictionaryImportResult) Summary() importer.Summary {
+	sum := importer.Summary{Report: r.Report, Files: r.Files, Tables: make(map[string]int64, len(r.Tables))}
+	for table, n := range r.Tables {
+		sum.Tables[table] = int64(n)
 	}
+	return sum
+}
+
+type DictionaryFile struct {
+	Name  string
+	Table string
+}
 
+// DictionaryFiles maps each Receita dictionary zip to its table.
+var DictionaryFiles = []DictionaryFile{
+	{"Cnaes.zip", "dictionaries.cnaes"},
+	{"Motivos.zip", "dictionaries.motivos"},
+	{"Qualificacoes.zip", "dictionaries.qualificacoes"},
+	{"Municipios.zip", "dictionaries.municipios"},
+	{"Paises.zip", "dictionaries.paises"},
+	{"Naturezas.zip", "dictionaries.naturezas"},
+}
+
+// DictionaryTable returns the table of a dictionary name (cnaes, motivos,
+// ...), and whether it exists.
+func DictionaryTable(name string) (string, bool) {
+	for _, f := range DictionaryFiles {
+		if f.Table == "dictionaries."+name {
+			return f.Table, true
+		}
+	}
+	return "", false
+}
+
+// LoadDictionaries reads every dictionary table, keyed by its name without
+// the schema (cnaes, motivos, ...).
+func LoadDictionaries(ctx context.Context, repo *DictionaryRepo) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(DictionaryFiles))
+	for _, f := range DictionaryFiles {
+		desc, err := repo.Descriptions(ctx, f.Table)
+		if err != nil {
+			return nil, err
+		}
+		out[strings.TrimPrefix(f.Table, "dictionaries.")] = desc
+	}
+	return out, nil
+}
+
+func ImportAllDictionaries(ctx context.Context, repo *DictionaryRepo, baseDir string, rejects importer.Rejects, logger zerolog.Logger) (res DictionaryImportResult, err error) {
 	cwd, _ := os.Getwd()
 	logger.Debug().Str("cwd", cwd).Msg("Current working directory")
 
-	for _, f := range files {
+	res = DictionaryImportResult{Files: []string{}, Tables: make(map[string]int)}
+	quarantine := importer.NewQuarantine(rejects, "dictionaries")
+	defer func() {
+		quarantine.Close()
+		res.Quarantine = quarantine.Path()
+	}()
+
+	for _, f := range DictionaryFiles {
 		zipPath := filepath.Join(baseDir, f.Name)
 		if _, err := os.Stat(zipPath); os.IsNotExist(err) {
 			logger.Warn().Str("file", zipPath).Msg("Dictionary file missing")
@@ -150,10 +222,16 @@ func ImportAllDictionaries(ctx context.Context, repo *DictionaryRepo, baseDir st
 		}
 
 		logger.Info().Str("file", f.Name).Str("table", f.Table).Msg("Importing dictionary")
-		if err := ImportDictionaryZip(ctx, repo, zipPath, f.Table, logger); err != nil {
-			return fmt.Errorf("import %s: %w", f.Table, err)
+		stats, err := ImportDictionaryZip(ctx, repo, zipPath, f.Table, quarantine, logger)
+		res.Files = append(res.Files, f.Name)
+		res.Add(stats...)
+		for _, st := range stats {
+			res.Tables[f.Table] += st.Rows
+		}
+		if err != nil {
+			return res, fmt.Errorf("import %s: %w", f.Table, err)
 		}
 		logger.Info().Str("table", f.Table).Msg("Dictionary import completed")
 	}
-	return nil
+	return res, nil
 }
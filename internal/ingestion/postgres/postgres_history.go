@@ -0,0 +1,279 @@
+package ingestion
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+)
+
+const (
+	estabelecimentosHistory = "cnpj.estabelecimentos_history"
+	sociosHistory           = "cnpj.socios_history"
+	historyBatches          = "cnpj.history_batches"
+)
+
+// HistoryFields are the estabelecimento columns kept in the history.
+var HistoryFields = []string{
+	"situacao_cadastral", "data_situacao", "motivo_situacao", "cnae_principal",
+	"tipo_logradouro", "logradouro", "numero", "complemento", "bairro", "cep", "uf", "municipio",
+}
+
+// SociosSetQuery aggregates the sócios of each company into a JSON array in
+// a stable order, so equal sets compare equal.
+const SociosSetQuery = `SELECT cnpj_basico, jsonb_agg(jsonb_build_object(
+		'nome', nome_socio, 'documento', cnpj_cpf_socio,
+		'qualificacao', qualificacao_socio, 'entrada', data_entrada_sociedade
+	) ORDER BY cnpj_cpf_socio, nome_socio, qualificacao_socio) AS socios
+	FROM cnpj.socios
+	GROUP BY cnpj_basico`
+
+// HistoryCounts reports a history table after a batch: Opened versions
+// started with it, Closed versions were replaced or left the batch.
+type HistoryCounts struct {
+	Opened int64 `json:"opened"`
+	Closed int64 `json:"closed"`
+}
+
+// HistoryResult reports a batch applied to the history. Skipped is set
+// when the batch was applied already.
+type HistoryResult struct {
+	Batch            string        `json:"batch"`
+	Skipped          bool          `json:"skipped"`
+	Estabelecimentos HistoryCounts `json:"estabelecimentos"`
+	Socios           HistoryCounts `json:"socios"`
+	Duration         time.Duration `json:"duration"`
+}
+
+func (r HistoryResult) Summary() importer.Summary {
+	opened := r.Estabelecimentos.Opened + r.Socios.Opened
+	return importer.Summary{
+		Report: importer.Report{Accepted: int(opened)},
+		Tables: map[string]int64{estabelecimentosHistory: r.Estabelecimentos.Opened, sociosHistory: r.Socios.Opened},
+	}
+}
+
+type HistoryRepo struct {
+	conn DB
+	psql sq.StatementBuilderType
+}
+
+func NewHistoryRepo(conn DB) *HistoryRepo {
+	return &HistoryRepo{
+		conn: conn,
+		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// ApplyHistory records the current cnpj tables as batch in the history
+// tables: versions that changed or left the batch are closed, and changed
+// or new ones are opened. Batches must be applied in order; applying the
+// last batch again does nothing.
+func ApplyHistory(ctx context.Context, repo *HistoryRepo, batch string, logger zerolog.Logger) (HistoryResult, error) {
+	start := time.Now()
+	res := HistoryResult{Batch: batch}
+	if batch == "" {
+		return res, fmt.Errorf("batch is required")
+	}
+	unlock, err := lockReload(ctx, repo.conn, estabelecimentosHistory)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	var last string
+	if err := repo.conn.QueryRow(ctx, "SELECT COALESCE(max(batch), '') FROM "+historyBatches).Scan(&last); err != nil {
+		return res, fmt.Errorf("read last history batch: %w", err)
+	}
+	if batch == last {
+		res.Skipped = true
+		logger.Info().Str("batch", batch).Msg("History already has this batch")
+		return res, nil
+	}
+	if batch < last {
+		return res, fmt.Errorf("batch %s is older than %s, the last one in the history", batch, last)
+	}
+
+	tx, err := repo.conn.Begin(ctx)
+	if err != nil {
+		return res, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cols := strings.Join(HistoryFields, ", ")
+	var live, kept []string
+	for _, f := range HistoryFields {
+		live = append(live, "e."+f)
+		kept = append(kept, "h."+f)
+	}
+	tag, err := tx.Exec(ctx, `UPDATE `+estabelecimentosHistory+` h SET valid_to = $1
+		WHERE h.valid_to IS NULL AND NOT EXISTS (
+			SELECT 1 FROM cnpj.estabelecimentos e
+			WHERE e.cnpj = h.cnpj AND (`+strings.Join(live, ", ")+`) IS NOT DISTINCT FROM (`+strings.Join(kept, ", ")+`)
+		)`, batch)
+	if err != nil {
+		return res, fmt.Errorf("close estabelecimento versions: %w", err)
+	}
+	res.Estabelecimentos.Closed = tag.RowsAffected()
+	tag, err = tx.Exec(ctx, `INSERT INTO `+estabelecimentosHistory+` (cnpj, `+cols+`, valid_from)
+		SELECT e.cnpj, `+strings.Join(live, ", ")+`, $1
+		FROM cnpj.estabelecimentos e
+		WHERE NOT EXISTS (SELECT 1 FROM `+estabelecimentosHistory+` h WHERE h.cnpj = e.cnpj AND h.valid_to IS NULL)`, batch)
+	if err != nil {
+		return res, fmt.Errorf("open estabelecimento versions: %w", err)
+	}
+	res.Estabelecimentos.Opened = tag.RowsAffected()
+
+	if _, err := tx.Exec(ctx, "CREATE TEMP TABLE socios_current ON COMMIT DROP AS "+SociosSetQuery); err != nil {
+		return res, fmt.Errorf("collect socios: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "CREATE INDEX ON socios_current (cnpj_basico)"); err != nil {
+		return res, fmt.Errorf("index socios: %w", err)
+	}
+	tag, err = tx.Exec(ctx, `UPDATE `+sociosHistory+` h SET valid_to = $1
+		WHERE h.valid_to IS NULL AND NOT EXISTS (
+			SELECT 1 FROM socios_current s WHERE s.cnpj_basico = h.cnpj_basico AND s.socios = h.socios
+		)`, batch)
+	if err != nil {
+		return res, fmt.Errorf("close socios versions: %w", err)
+	}
+	res.Socios.Closed = tag.RowsAffected()
+	tag, err = tx.Exec(ctx, `INSERT INTO `+sociosHistory+` (cnpj_basico, socios, valid_from)
+		SELECT s.cnpj_basico, s.socios, $1
+		FROM socios_current s
+		WHERE NOT EXISTS (SELECT 1 FROM `+sociosHistory+` h WHERE h.cnpj_basico = s.cnpj_basico AND h.valid_to IS NULL)`, batch)
+	if err != nil {
+		return res, fmt.Errorf("open socios versions: %w", err)
+	}
+	res.Socios.Opened = tag.RowsAffected()
+
+	opened := res.Estabelecimentos.Opened + res.Socios.Opened
+	closed := res.Estabelecimentos.Closed + res.Socios.Closed
+	if _, err := tx.Exec(ctx, "INSERT INTO "+historyBatches+" (batch, opened, closed) VALUES ($1, $2, $3)", batch, opened, closed); err != nil {
+		return res, fmt.Errorf("record history batch: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return res, fmt.Errorf("commit history: %w", err)
+	}
+	res.Duration = time.Since(start)
+	logger.Info().Str("batch", batch).Int64("opened", opened).Int64("closed", closed).Dur("duration", res.Duration).Msg("🕰️ History updated")
+	return res, nil
+}
+
+// HistoryVersion is a version of a history row, valid from a batch until
+// the batch that replaced it, if any.
+type HistoryVersion struct {
+	ValidFrom string         `json:"valid_from" example:"2025-08"`
+	ValidTo   string         `json:"valid_to,omitempty" example:"2025-09"`
+	Values    map[string]any `json:"values"`
+}
+
+const (
+	EventAdded   = "added"
+	EventChanged = "changed"
+	EventRemoved = "removed"
+)
+
+// HistoryEvent is a change in a timeline. Subject is estabelecimento or
+// socios; Before and After hold the changed fields.
+type HistoryEvent struct {
+	Batch   string         `json:"batch" example:"2025-09"`
+	Subject string         `json:"subject" example:"estabelecimento"`
+	Event   string         `json:"event" example:"changed"`
+	Fields  []string       `json:"fields,omitempty" example:"situacao_cadastral"`
+	Before  map[string]any `json:"before,omitempty"`
+	After   map[string]any `json:"after,omitempty"`
+}
+
+// CNPJHistory is the history of an estabelecimento and of the sócios of
+// its company, with the changes between versions as a timeline.
+type CNPJHistory struct {
+	CNPJ            string           `json:"cnpj" example:"11222333000181"`
+	Estabelecimento []HistoryVersion `json:"estabelecimento"`
+	Socios          []HistoryVersion `json:"socios"`
+	Timeline        []HistoryEvent   `json:"timeline"`
+}
+
+func (r *HistoryRepo) versions(ctx context.Context, table, key, value string) ([]HistoryVersion, error) {
+	sql, args, err := r.psql.
+		Select("valid_from", "COALESCE(valid_to, '')", "to_jsonb(h) - 'id' - '"+key+"' - 'valid_from' - 'valid_to'").
+		From(table + " h").
+		Where(sq.Eq{key: value}).
+		OrderBy("valid_from").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", table, err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (HistoryVersion, error) {
+		var v HistoryVersion
+		var raw []byte
+		if err := row.Scan(&v.ValidFrom, &v.ValidTo, &raw); err != nil {
+			return v, err
+		}
+		return v, json.Unmarshal(raw, &v.Values)
+	})
+}
+
+// History returns the history of a CNPJ; both version lists are empty when
+// it never appeared in a batch.
+func (r *HistoryRepo) History(ctx context.Context, cnpj string) (CNPJHistory, error) {
+	h := CNPJHistory{CNPJ: cnpj}
+	var err error
+	if h.Estabelecimento, err = r.versions(ctx, estabelecimentosHistory, "cnpj", cnpj); err != nil {
+		return h, err
+	}
+	if h.Socios, err = r.versions(ctx, sociosHistory, "cnpj_basico", cnpj[:8]); err != nil {
+		return h, err
+	}
+	h.Timeline = append(timeline("estabelecimento", h.Estabelecimento), timeline("socios", h.Socios)...)
+	slices.SortStableFunc(h.Timeline, func(a, b HistoryEvent) int { return cmp.Compare(a.Batch, b.Batch) })
+	return h, nil
+}
+
+// timeline turns consecutive versions into events. A version that doesn't
+// start where the previous one ended means the row was missing in between.
+func timeline(subject string, versions []HistoryVersion) []HistoryEvent {
+	events := []HistoryEvent{}
+	for i, v := range versions {
+		if i > 0 && versions[i-1].ValidTo == v.ValidFrom {
+			prev := versions[i-1].Values
+			ev := HistoryEvent{Batch: v.ValidFrom, Subject: subject, Event: EventChanged, Before: map[string]any{}, After: map[string]any{}}
+			keys := map[string]bool{}
+			for k := range prev {
+				keys[k] = true
+			}
+			for k := range v.Values {
+				keys[k] = true
+			}
+			for _, k := range slices.Sorted(maps.Keys(keys)) {
+				if !reflect.DeepEqual(prev[k], v.Values[k]) {
+					ev.Fields = append(ev.Fields, k)
+					ev.Before[k], ev.After[k] = prev[k], v.Values[k]
+				}
+			}
+			events = append(events, ev)
+		} else {
+			events = append(events, HistoryEvent{Batch: v.ValidFrom, Subject: subject, Event: EventAdded, After: v.Values})
+		}
+		last := i == len(versions)-1
+		if v.ValidTo != "" && (last || versions[i+1].ValidFrom != v.ValidTo) {
+			events = append(events, HistoryEvent{Batch: v.ValidTo, Subject: subject, Event: EventRemoved, Before: v.Values})
+		}
+	}
+	return events
+}
@@ -0,0 +1,107 @@
+package ingestion
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTimeline(t *testing.T) {
+	ativa := map[string]any{"situacao_cadastral": float64(2), "uf": "PR"}
+	baixada := map[string]any{"situacao_cadastral": float64(8), "uf": "PR"}
+	mudou := map[string]any{"situacao_cadastral": float64(2), "uf": "SC", "email": "a@b.com"}
+
+	tests := []struct {
+		name     string
+		versions []HistoryVersion
+		want     []HistoryEvent
+	}{
+		{
+			name: "no versions",
+			want: []HistoryEvent{},
+		},
+		{
+			name:     "current only",
+			versions: []HistoryVersion{{ValidFrom: "2025-08", Values: ativa}},
+			want: []HistoryEvent{
+				{Batch: "2025-08", Subject: "estabelecimento", Event: EventAdded, After: ativa},
+			},
+		},
+		{
+			name: "changed",
+			versions: []HistoryVersion{
+				{ValidFrom: "2025-07", ValidTo: "2025-08", Values: ativa},
+				{ValidFrom: "2025-08", Values: baixada},
+			},
+			want: []HistoryEvent{
+				{Batch: "2025-07", Subject: "estabelecimento", Event: EventAdded, After: ativa},
+				{
+					Batch: "2025-08", Subject: "estabelecimento", Event: EventChanged,
+					Fields: []string{"situacao_cadastral"},
+					Before: map[string]any{"situacao_cadastral": float64(2)},
+					After:  map[string]any{"situacao_cadastral": float64(8)},
+				},
+			},
+		},
+		{
+			name: "fields added and dropped",
+			versions: []HistoryVersion{
+				{ValidFrom: "2025-07", ValidTo: "2025-08", Values: baixada},
+				{ValidFrom: "2025-08", Values: mudou},
+			},
+			want: []HistoryEvent{
+				{Batch: "2025-07", Subject: "estabelecimento", Event: EventAdded, After: baixada},
+				{
+					Batch: "2025-08", Subject: "estabelecimento", Event: EventChanged,
+					Fields: []string{"email", "situacao_cadastral", "uf"},
+					Before: map[string]any{"email": nil, "situacao_cadastral": float64(8), "uf": "PR"},
+					After:  map[string]any{"email": "a@b.com", "situacao_cadastral": float64(2), "uf": "SC"},
+				},
+			},
+		},
+		{
+			name:     "removed",
+			versions: []HistoryVersion{{ValidFrom: "2025-07", ValidTo: "2025-09", Values: ativa}},
+			want: []HistoryEvent{
+				{Batch: "2025-07", Subject: "estabelecimento", Event: EventAdded, After: ativa},
+				{Batch: "2025-09", Subject: "estabelecimento", Event: EventRemoved, Before: ativa},
+			},
+		},
+		{
+			name: "missing in between",
+			versions: []HistoryVersion{
+				{ValidFrom: "2025-06", ValidTo: "2025-07", Values: ativa},
+				{ValidFrom: "2025-09", Values: ativa},
+			},
+			want: []HistoryEvent{
+				{Batch: "2025-06", Subject: "estabelecimento", Event: EventAdded, After: ativa},
+				{Batch: "2025-07", Subject: "estabelecimento", Event: EventRemoved, Before: ativa},
+				{Batch: "2025-09", Subject: "estabelecimento", Event: EventAdded, After: ativa},
+			},
+		},
+		{
+			name: "changed then removed",
+			versions: []HistoryVersion{
+				{ValidFrom: "2025-07", ValidTo: "2025-08", Values: ativa},
+				{ValidFrom: "2025-08", ValidTo: "2025-09", Values: baixada},
+			},
+			want: []HistoryEvent{
+				{Batch: "2025-07", Subject: "estabelecimento", Event: EventAdded, After: ativa},
+				{
+					Batch: "2025-08", Subject: "estabelecimento", Event: EventChanged,
+					Fields: []string{"situacao_cadastral"},
+					Before: map[string]any{"situacao_cadastral": float64(2)},
+					After:  map[string]any{"situacao_cadastral": float64(8)},
+				},
+				{Batch: "2025-09", Subject: "estabelecimento", Event: EventRemoved, Before: baixada},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := timeline("estabelecimento", tt.versions)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("timeline() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
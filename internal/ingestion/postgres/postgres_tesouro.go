@@ -0,0 +1,542 @@
+package ingestion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/csvx"
+)
+
+type columnType string
+
+const (
+	colBigint  columnType = "bigint"
+	colNumeric columnType = "numeric"
+	colDate    columnType = "date"
+	colText    columnType = "text"
+)
+
+var (
+	intPattern        = regexp.MustCompile(`^-?(0|[1-9]\d{0,17})$`)
+	groupedIntPattern = regexp.MustCompile(`^-?[1-9]\d{0,2}(\.\d{3})+$`)
+	brDecimalPattern  = regexp.MustCompile(`^-?(\d{1,3}(\.\d{3})+|\d+),\d+$`)
+	dotDecimalPattern = regexp.MustCompile(`^-?\d+\.\d+$`)
+	brDatePattern     = regexp.MustCompile(`^\d{2}/\d{2}/\d{4}$`)
+	isoDatePattern    = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	nonIdentPattern   = regexp.MustCompile(`[^a-z0-9]+`)
+	trailingNumbers   = regexp.MustCompile(`[_0-9]+$`)
+)
+
+type TesouroColumn struct {
+	Name string
+	Type columnType
+}
+
+type TesouroImportResult struct {
+	File     string `json:"file"`
+	Table    string `json:"table"`
+	Encoding string `json:"encoding,omitempty"`
+	Rows     int64  `json:"rows"`
+	UpToDate bool   `json:"up_to_date"`
+}
+
+// TesouroImportResults reports an import of every Tesouro file.
+type TesouroImportResults []TesouroImportResult
+
+func (rs TesouroImportResults) Summary() importer.Summary {
+	sum := importer.Summary{Files: []string{}, Tables: map[string]int64{}}
+	for _, r := range rs {
+		sum.Files = append(sum.Files, r.File)
+		if !r.UpToDate {
+			sum.Accepted += int(r.Rows)
+			sum.Tables[r.Table] += r.Rows
+		}
+	}
+	return sum
+}
+
+type TesouroRepo struct {
+	conn DB
+	psql sq.StatementBuilderType
+}
+
+func NewTesouroRepo(conn DB) *TesouroRepo {
+	return &TesouroRepo{
+		conn: conn,
+		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+// IsCurrent reports whether filename was already imported with this checksum.
+func (r *TesouroRepo) IsCurrent(ctx context.Context, filename, checksum string) (bool, error) {
+	sql, args, err := r.psql.Select("checksum").From("tesouro.resources").Where(sq.Eq{"filename": filename}).ToSql()
+	if err != nil {
+		return false, err
+	}
+	var current string
+	err = r.conn.QueryRow(ctx, sql, args...).Scan(&current)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return current == checksum, nil
+}
+
+// Replace loads rows as the new version of filename. The previous rows of
+// the resource are deleted in the same transaction, so a failed load leaves
+// the old version in place.
+func (r *TesouroRepo) Replace(ctx context.Context, res TesouroImportResult, checksum string, comma rune, cols []TesouroColumn, rows *tesouroRows, logger zerolog.Logger) (int64, error) {
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.ensureTable(ctx, tx, res.Table, cols, logger); err != nil {
+		return 0, fmt.Errorf("ensure table %s: %w", res.Table, err)
+	}
+
+	names := make([]string, len(cols))
+	types := make([]string, len(cols))
+	for i, c := range cols {
+		names[i], types[i] = c.Name, string(c.Type)
+	}
+
+	sql, args, err := r.psql.Insert("tesouro.resources").
+		Columns("filename", "table_name", "checksum", "encoding", "delimiter", "columns", "column_types").
+		Values(res.File, res.Table, checksum, res.Encoding, string(comma), names, types).
+		Suffix(`ON CONFLICT (filename) DO UPDATE SET table_name = EXCLUDED.table_name, checksum = EXCLUDED.checksum,
+			encoding = EXCLUDED.encoding, delimiter = EXCLUDED.delimiter, columns = EXCLUDED.columns,
+			column_types = EXCLUDED.column_types, imported_at = now()
+			RETURNING id`).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("register resource: %w", err)
+	}
+
+	table := pgx.Identifier{"tesouro", res.Table}
+	if _, err := tx.Exec(ctx, "DELETE FROM "+table.Sanitize()+" WHERE resource_id = $1", id); err != nil {
+		return 0, fmt.Errorf("delete previous rows: %w", err)
+	}
+
+	rows.resourceID = id
+	n, err := tx.CopyFrom(ctx, table, append([]string{"resource_id"}, names...), rows)
+	if err != nil {
+		return 0, fmt.Errorf("copy rows: %w", err)
+	}
+
+	sql, args, err = r.psql.Update("tesouro.resources").
+		Set("row_count", n).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, sql, args...); err != nil {
+		return 0, fmt.Errorf("update resource: %w", err)
+	}
+
+	return n, tx.Commit(ctx)
+}
+
+// ensureTable creates the data table for a layout and reconciles it with an
+// existing one: new columns are added, and a column whose type already
+// holds the file's values keeps it. Live columns are never retyped; a file
+// needing a wider type than the table has fails until a migration widens
+// it. cols is updated to the types the table has.
+func (r *TesouroRepo) ensureTable(ctx context.Context, tx pgx.Tx, table string, cols []TesouroColumn, logger zerolog.Logger) error {
+	ident := pgx.Identifier{"tesouro", table}.Sanitize()
+
+	defs := []string{"resource_id BIGINT NOT NULL REFERENCES tesouro.resources(id) ON DELETE CASCADE"}
+	for _, c := range cols {
+		defs = append(defs, pgx.Identifier{c.Name}.Sanitize()+" "+string(c.Type))
+	}
+	if _, err := tx.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+ident+" ("+strings.Join(defs, ", ")+")"); err != nil {
+		return err
+	}
+	index := pgx.Identifier{"idx_" + table + "_resource"}.Sanitize()
+	if _, err := tx.Exec(ctx, "CREATE INDEX IF NOT EXISTS "+index+" ON "+ident+" (resource_id)"); err != nil {
+		return err
+	}
+
+	existing := map[string]columnType{}
+	rows, err := tx.Query(ctx, `SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = 'tesouro' AND table_name = $1`, table)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = columnType(typ)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, c := range cols {
+		have, ok := existing[c.Name]
+		switch {
+		case !ok:
+			col := pgx.Identifier{c.Name}.Sanitize()
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+ident+" ADD COLUMN "+col+" "+string(c.Type)); err != nil {
+				return err
+			}
+			logger.Info().Str("table", table).Str("column", c.Name).Str("type", string(c.Type)).Msg("Added Tesouro column")
+		case have != c.Type:
+			if widen(have, c.Type) != have {
+				return fmt.Errorf("column %s is %s but the file needs %s", c.Name, have, c.Type)
+			}
+			cols[i].Type = have
+		}
+	}
+	return nil
+}
+
+// tesouroRows feeds CopyFrom with the rows of the file, converting each
+// field to its column type. The types were inferred from every row, so a
+// value that doesn't fit fails the load instead of being stored as NULL.
+type tesouroRows struct {
+	resourceID int64
+	cols       []TesouroColumn
+	reader     *csv.Reader
+	row        int
+	current    []any
+	err        error
+}
+
+func (t *tesouroRows) Next() bool {
+	record, err := t.reader.Read()
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		t.err = fmt.Errorf("read row: %w", err)
+		return false
+	}
+	t.row++
+
+	t.current = make([]any, len(t.cols)+1)
+	t.current[0] = t.resourceID
+	for i, c := range t.cols {
+		raw := ""
+		if i < len(record) {
+			raw = strings.TrimSpace(record[i])
+		}
+		v, err := c.Type.parse(raw)
+		if err != nil {
+			t.err = fmt.Errorf("row %d, column %s: %w", t.row, c.Name, err)
+			return false
+		}
+		t.current[i+1] = v
+	}
+	return true
+}
+
+func (t *tesouroRows) Values() ([]any, error) { return t.current, nil }
+
+func (t *tesouroRows) Err() error { return t.err }
+
+// classify returns the narrowest type v converts to. A dot between groups
+// of three digits, as in 1.000, is read as a Brazilian thousands separator.
+func classify(v string) columnType {
+	var typ columnType
+	switch {
+	case intPattern.MatchString(v), groupedIntPattern.MatchString(v):
+		typ = colBigint
+	case brDecimalPattern.MatchString(v), dotDecimalPattern.MatchString(v):
+		typ = colNumeric
+	case brDatePattern.MatchString(v), isoDatePattern.MatchString(v):
+		typ = colDate
+	default:
+		return colText
+	}
+	// patterns accept values like 31/02/2024 that don't convert
+	if _, err := typ.parse(v); err != nil {
+		return colText
+	}
+	return typ
+}
+
+func widen(a, b columnType) columnType {
+	if a == b {
+		return a
+	}
+	if (a == colBigint || a == colNumeric) && (b == colBigint || b == colNumeric) {
+		return colNumeric
+	}
+	return colText
+}
+
+// parse converts raw into a value for the column type. Empty strings
+// become NULL.
+func (c columnType) parse(raw string) (any, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	switch c {
+	case colBigint:
+		if groupedIntPattern.MatchString(raw) {
+			raw = strings.ReplaceAll(raw, ".", "")
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a %s", raw, c)
+		}
+		return n, nil
+	case colNumeric:
+		if strings.Contains(raw, ",") || groupedIntPattern.MatchString(raw) {
+			raw = strings.ReplaceAll(raw, ".", "")
+			raw = strings.ReplaceAll(raw, ",", ".")
+		}
+		var n pgtype.Numeric
+		if err := n.Scan(raw); err != nil {
+			return nil, fmt.Errorf("%q is not a %s", raw, c)
+		}
+		return n, nil
+	case colDate:
+		for _, layout := range []string{"02/01/2006", "2006-01-02"} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a %s", raw, c)
+	}
+	return raw, nil
+}
+
+// inferColumns reads every row and picks, for each column, the narrowest
+// type all its non-empty values fit. A column mixing 1.000 with 1.5 can't
+// tell thousands from decimals apart and is kept as text.
+func inferColumns(names []string, reader *csv.Reader) ([]TesouroColumn, error) {
+	types := make([]columnType, len(names))
+	grouped := make([]bool, len(names))
+	dotted := make([]bool, len(names))
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read row: %w", err)
+		}
+		for i := range types {
+			if i >= len(row) || types[i] == colText {
+				continue
+			}
+			v := strings.TrimSpace(row[i])
+			if groupedIntPattern.MatchString(v) {
+				grouped[i] = true
+			} else if dotDecimalPattern.MatchString(v) {
+				dotted[i] = true
+			}
+			if grouped[i] && dotted[i] {
+				types[i] = colText
+				continue
+			}
+			switch {
+			case v == "":
+			case types[i] == "":
+				types[i] = classify(v)
+			default:
+				types[i] = widen(types[i], classify(v))
+			}
+		}
+	}
+	cols := make([]TesouroColumn, len(names))
+	for i, name := range names {
+		if types[i] == "" {
+			types[i] = colText
+		}
+		cols[i] = TesouroColumn{Name: name, Type: types[i]}
+	}
+	return cols, nil
+}
+
+func slug(s string) string {
+	s = nonIdentPattern.ReplaceAllString(strings.ToLower(csvx.Unaccent(s)), "_")
+	return strings.Trim(s, "_")
+}
+
+func identifier(s string) string {
+	s = slug(s)
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "c_" + s
+	}
+	if len(s) > 60 {
+		s = s[:60]
+	}
+	return s
+}
+
+// columnNames turns a CSV header into unique Postgres column names.
+func columnNames(header []string) []string {
+	seen := map[string]int{"resource_id": 1}
+	out := make([]string, len(header))
+	for i, h := range header {
+		name := identifier(h)
+		if name == "" {
+			name = fmt.Sprintf("coluna_%d", i+1)
+		}
+		if n := seen[name]; n > 0 {
+			seen[name] = n + 1
+			name = fmt.Sprintf("%s_%d", name, n+1)
+		}
+		seen[name]++
+		out[i] = name
+	}
+	return out
+}
+
+// tesouroTableName derives the data table from the file name, dropping
+// trailing years or sequence numbers so yearly files share a table.
+func tesouroTableName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name := identifier(trailingNumbers.ReplaceAllString(slug(base), ""))
+	if name == "" || name == "resources" {
+		return strings.TrimSuffix("dados_"+name, "_")
+	}
+	return name
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ImportTesouroCSV loads a single Tesouro CSV. The encoding and delimiter are
+// detected, column types are inferred from a first pass over the whole file,
+// and a file whose checksum matches the last import is skipped.
+func ImportTesouroCSV(ctx context.Context, repo *TesouroRepo, path string, logger zerolog.Logger) (TesouroImportResult, error) {
+	res := TesouroImportResult{File: filepath.Base(path), Table: tesouroTableName(path)}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return res, fmt.Errorf("checksum: %w", err)
+	}
+	current, err := repo.IsCurrent(ctx, res.File, checksum)
+	if err != nil {
+		return res, fmt.Errorf("check resource: %w", err)
+	}
+	if current {
+		res.UpToDate = true
+		logger.Info().Str("file", res.File).Msg("Tesouro resource already imported, skipping")
+		return res, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return res, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	format, decoded, err := csvx.Sniff(f)
+	if err != nil {
+		return res, fmt.Errorf("sniff format: %w", err)
+	}
+	res.Encoding = string(format.Encoding)
+
+	reader := csvx.NewReader(decoded, format.Comma)
+	header, err := reader.Read()
+	if err != nil {
+		return res, fmt.Errorf("read header: %w", err)
+	}
+	names := columnNames(header)
+	cols, err := inferColumns(names, reader)
+	if err != nil {
+		return res, fmt.Errorf("infer columns: %w", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return res, fmt.Errorf("rewind file: %w", err)
+	}
+	reader = csvx.NewReader(csvx.Decode(f, format.Encoding), format.Comma)
+	if _, err := reader.Read(); err != nil {
+		return res, fmt.Errorf("read header: %w", err)
+	}
+
+	logger.Info().Str("file", res.File).Str("table", res.Table).Str("encoding", res.Encoding).
+		Str("delimiter", string(format.Comma)).Int("columns", len(cols)).Msg("Importing Tesouro resource")
+
+	rows := &tesouroRows{cols: cols, reader: reader}
+	n, err := repo.Replace(ctx, res, checksum, format.Comma, cols, rows, logger)
+	if err != nil {
+		return res, err
+	}
+	res.Rows = n
+	return res, nil
+}
+
+func ImportAllTesouro(ctx context.Context, repo *TesouroRepo, baseDir string, logger zerolog.Logger) (TesouroImportResults, error) {
+	files, err := TesouroFiles(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		logger.Warn().Str("dir", baseDir).Msg("No Tesouro CSV files found")
+	}
+
+	results := make(TesouroImportResults, 0, len(files))
+	for _, path := range files {
+		res, err := ImportTesouroCSV(ctx, repo, path, logger)
+		if err != nil {
+			return results, fmt.Errorf("import %s: %w", filepath.Base(path), err)
+		}
+		logger.Info().Str("file", res.File).Int64("rows", res.Rows).Msg("Tesouro import completed")
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// TesouroFiles lists the CSV files in baseDir.
+func TesouroFiles(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(baseDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".csv") {
+			files = append(files, filepath.Join(baseDir, e.Name()))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,129 @@
+package ingestion
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want columnType
+	}{
+		{"0", colBigint},
+		{"-42", colBigint},
+		{"123456789012345678", colBigint},
+		{"1234567890123456789", colText},
+		{"007", colText},
+		{"1.000", colBigint},
+		{"-12.345.678", colBigint},
+		{"1.5", colNumeric},
+		{"0.500", colNumeric},
+		{"2,50", colNumeric},
+		{"1.000,50", colNumeric},
+		{"-0,01", colNumeric},
+		{"31/12/2024", colDate},
+		{"2024-12-31", colDate},
+		{"31/02/2024", colText},
+		{"1.00.000", colText},
+		{"ministerio", colText},
+	}
+	for _, tt := range tests {
+		if got := classify(tt.in); got != tt.want {
+			t.Errorf("classify(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWiden(t *testing.T) {
+	tests := []struct {
+		a, b columnType
+		want columnType
+	}{
+		{colBigint, colBigint, colBigint},
+		{colBigint, colNumeric, colNumeric},
+		{colNumeric, colBigint, colNumeric},
+		{colDate, colDate, colDate},
+		{colBigint, colDate, colText},
+		{colNumeric, colText, colText},
+		{colText, colBigint, colText},
+	}
+	for _, tt := range tests {
+		if got := widen(tt.a, tt.b); got != tt.want {
+			t.Errorf("widen(%s, %s) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func numeric(t *testing.T, s string) pgtype.Numeric {
+	t.Helper()
+	var n pgtype.Numeric
+	if err := n.Scan(s); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		typ     columnType
+		in      string
+		want    any
+		wantErr bool
+	}{
+		{typ: colBigint, in: "", want: nil},
+		{typ: colBigint, in: "42", want: int64(42)},
+		{typ: colBigint, in: "1.000", want: int64(1000)},
+		{typ: colBigint, in: "-12.345.678", want: int64(-12345678)},
+		{typ: colBigint, in: "1.5", wantErr: true},
+		{typ: colNumeric, in: "1.000", want: numeric(t, "1000")},
+		{typ: colNumeric, in: "2,50", want: numeric(t, "2.50")},
+		{typ: colNumeric, in: "1.000,50", want: numeric(t, "1000.50")},
+		{typ: colNumeric, in: "1.5", want: numeric(t, "1.5")},
+		{typ: colNumeric, in: "abc", wantErr: true},
+		{typ: colDate, in: "31/12/2024", want: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{typ: colDate, in: "2024-12-31", want: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{typ: colDate, in: "31/02/2024", wantErr: true},
+		{typ: colText, in: "1.000", want: "1.000"},
+	}
+	for _, tt := range tests {
+		got, err := tt.typ.parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s.parse(%q) error = %v, want error %v", tt.typ, tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.parse(%q) = %v, want %v", tt.typ, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInferColumns(t *testing.T) {
+	rows := strings.Join([]string{
+		"1;1.000;1.000;1.000;2024-01-31;",
+		"2;2,50;1.5;2.000;31/01/2024;",
+		"x;;;3;;",
+	}, "\n")
+	reader := csv.NewReader(strings.NewReader(rows))
+	reader.Comma = ';'
+	got, err := inferColumns([]string{"codigo", "br", "mixed", "grouped", "data", "vazia"}, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TesouroColumn{
+		{Name: "codigo", Type: colText},
+		{Name: "br", Type: colNumeric},
+		{Name: "mixed", Type: colText},
+		{Name: "grouped", Type: colBigint},
+		{Name: "data", Type: colDate},
+		{Name: "vazia", Type: colText},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("inferColumns() = %v, want %v", got, want)
+	}
+}
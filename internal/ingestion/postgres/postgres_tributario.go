@@ -1,58 +1,162 @@
 package ingestion
 
 import (
-	"archive/zip"
 	"context"
-	"encoding/csv"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/layout"
+)
+
+const (
+	regimesTable = "tributario.regimes"
+	// regimesRaw receives one file as is, before duplicates are dropped
+	regimesRaw = "regimes_raw"
 )
 
-type RegimeDTO struct {
-	Ano                     int
-	CNPJ                    string
-	CNPJdaSCP               string
-	FormaTributacao         string
-	QuantidadeEscrituracoes int
-	Dataset                 string
+// regimesLoad collects the rows of all files of a reload before they are
+// split into year partitions.
+var regimesLoad = pgx.Identifier{"tributario", "regimes_load"}
+
+var regimeColumns = []string{"ano", "cnpj", "cnpj_da_scp", "forma_de_tributacao", "quantidade_de_escrituracoes"}
+
+// RegimeImportResult reports the load of one dataset. Staged counts the
+// rows read from the file; Duplicates are rows repeating the natural key
+// within the file, of which only the first was kept.
+type RegimeImportResult struct {
+	Dataset    string `json:"dataset"`
+	File       string `json:"file"`
+	Years      []int  `json:"years"`
+	Staged     int64  `json:"staged"`
+	Skipped    int    `json:"skipped"`
+	Duplicates int64  `json:"duplicates"`
+	Inserted   int64  `json:"inserted"`
+}
+
+// RegimeReloadResult reports a reload of tributario.regimes, with the swap
+// of each year partition the files covered; Partitions is only set once
+// all of them are live. Carried counts the rows kept from datasets no file
+// covered in those years.
+type RegimeReloadResult struct {
+	importer.Report
+	Datasets   []RegimeImportResult `json:"datasets"`
+	Carried    int64                `json:"carried"`
+	Partitions []SwapResult         `json:"partitions"`
+}
+
+func (r RegimeReloadResult) Summary() importer.Summary {
+	sum := importer.Summary{Report: r.Report, Tables: map[string]int64{}}
+	for _, ds := range r.Datasets {
+		sum.Files = append(sum.Files, ds.File)
+		sum.Tables[regimesTable] += ds.Inserted
+	}
+	return sum
 }
 
 type TributarioRepo struct {
-	conn *pgx.Conn
+	conn DB
 	psql sq.StatementBuilderType
 }
 
-func NewTributarioRepo(conn *pgx.Conn) *TributarioRepo {
+func NewTributarioRepo(conn DB) *TributarioRepo {
 	return &TributarioRepo{
 		conn: conn,
 		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
 	}
 }
 
-func (r *TributarioRepo) InsertBatch(ctx context.Context, records []RegimeDTO) error {
-	batch := &pgx.Batch{}
+// stage creates the temporary table a file is copied into. It is dropped
+// when the transaction ends. ord numbers the rows in file order.
+func (r *TributarioRepo) stage(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `CREATE TEMP TABLE `+regimesRaw+` (
+		ord BIGINT GENERATED ALWAYS AS IDENTITY,
+		ano INT NOT NULL,
+		cnpj CHAR(14) NOT NULL,
+		cnpj_da_scp TEXT,
+		forma_de_tributacao VARCHAR(100) NOT NULL,
+		quantidade_de_escrituracoes INT NOT NULL
+	) ON COMMIT DROP`)
+	return err
+}
 
-	for _, rec := range records {
-		sql, args, err := r.psql.Insert("tributario.regimes").
-			Columns("ano", "cnpj", "cnpj_da_scp", "forma_de_tributacao", "quantidade_de_escrituracoes", "dataset").
-			Values(rec.Ano, rec.CNPJ, rec.CNPJdaSCP, rec.FormaTributacao, rec.QuantidadeEscrituracoes, rec.Dataset).
-			ToSql()
-		if err != nil {
-			return err
-		}
-		batch.Queue(sql, args...)
+// keep moves the raw rows into the staging table, dropping rows that repeat
+// the natural key of an earlier row of the file.
+func (r *TributarioRepo) keep(ctx context.Context, tx pgx.Tx, staging pgx.Identifier, res *RegimeImportResult) error {
+	rows, err := tx.Query(ctx, "SELECT DISTINCT ano FROM "+regimesRaw+" ORDER BY ano")
+	if err != nil {
+		return fmt.Errorf("list years: %w", err)
+	}
+	if res.Years, err = pgx.CollectRows(rows, pgx.RowTo[int]); err != nil {
+		return fmt.Errorf("list years: %w", err)
+	}
+
+	cols := strings.Join(regimeColumns, ", ")
+	tag, err := tx.Exec(ctx, `INSERT INTO `+staging.Sanitize()+` (`+cols+`, dataset)
+		SELECT DISTINCT ON (ano, cnpj, cnpj_da_scp) `+cols+`, $1
+		FROM `+regimesRaw+`
+		ORDER BY ano, cnpj, cnpj_da_scp, ord`, res.Dataset)
+	if err != nil {
+		return fmt.Errorf("insert staged rows: %w", err)
+	}
+	res.Inserted = tag.RowsAffected()
+	res.Duplicates = res.Staged - res.Inserted
+	return nil
+}
+
+// regimePartition returns the partition of tributario.regimes holding
+// year, creating it when the year is new. The CHECK constraint lets a
+// reloaded copy be attached without scanning it.
+func (r *TributarioRepo) regimePartition(ctx context.Context, year int, logger zerolog.Logger) (swapTable, error) {
+	t := swapTable{
+		schema: "tributario",
+		name:   fmt.Sprintf("regimes_y%d", year),
+		parent: regimesTable,
+		bound:  fmt.Sprintf("FOR VALUES IN (%d)", year),
+	}
+	var exists bool
+	if err := r.conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", t.qualified("")).Scan(&exists); err != nil {
+		return t, err
+	}
+	if exists {
+		return t, nil
 	}
+	check := pgx.Identifier{t.name + "_ano"}.Sanitize()
+	sql := fmt.Sprintf("CREATE TABLE %s PARTITION OF %s (CONSTRAINT %s CHECK (ano = %d)) %s",
+		t.ident("").Sanitize(), regimesTable, check, year, t.bound)
+	if _, err := r.conn.Exec(ctx, sql); err != nil {
+		return t, fmt.Errorf("create partition %s: %w", t.qualified(""), err)
+	}
+	logger.Info().Int("year", year).Str("partition", t.qualified("")).Msg("📅 Created regimes partition")
+	return t, nil
+}
 
-	br := r.conn.SendBatch(ctx, batch)
-	return br.Close()
+// fill loads the staging copy of a year partition with the year's loaded
+// rows, and carries the live rows of the datasets no file covered.
+func (r *TributarioRepo) fill(ctx context.Context, part swapTable, year int) (loaded, carried int64, err error) {
+	cols := strings.Join(regimeColumns, ", ") + ", dataset"
+	staging := part.ident(stagingSuffix).Sanitize()
+	tag, err := r.conn.Exec(ctx, `INSERT INTO `+staging+` (`+cols+`)
+		SELECT `+cols+` FROM `+regimesLoad.Sanitize()+` WHERE ano = $1`, year)
+	if err != nil {
+		return 0, 0, fmt.Errorf("fill %s: %w", part.qualified(""), err)
+	}
+	loaded = tag.RowsAffected()
+	tag, err = r.conn.Exec(ctx, `INSERT INTO `+staging+` (`+cols+`)
+		SELECT `+cols+` FROM `+part.ident("").Sanitize()+`
+		WHERE dataset NOT IN (SELECT DISTINCT dataset FROM `+regimesLoad.Sanitize()+` WHERE ano = $1)`, year)
+	if err != nil {
+		return 0, 0, fmt.Errorf("carry untouched rows: %w", err)
+	}
+	carried = tag.RowsAffected()
+	return loaded + carried, carried, nil
 }
 
 func normalizeCNPJ(raw string) string {
@@ -62,98 +166,208 @@ func normalizeCNPJ(raw string) string {
 	return fmt.Sprintf("%014s", raw)
 }
 
-func ImportRegimeZip(ctx context.Context, repo *TributarioRepo, zipPath, dataset string, logger zerolog.Logger) error {
-	r, err := zip.OpenReader(zipPath)
+// regimeSink copies tax regime rows into the raw table.
+type regimeSink struct {
+	tx     pgx.Tx
+	rows   [][]any
+	staged int64
+}
+
+func (s *regimeSink) Write(ctx context.Context, rows []layout.Row) error {
+	s.rows = s.rows[:0]
+	for _, row := range rows {
+		s.rows = append(s.rows, []any{row[0], normalizeCNPJ(row[1].(string)), row[2], row[3], row[4]})
+	}
+	n, err := s.tx.CopyFrom(ctx, pgx.Identifier{regimesRaw}, regimeColumns, pgx.CopyFromRows(s.rows))
+	s.staged += n
+	return err
+}
+
+// stageRegimeZip copies one dataset into the staging table through a
+// temporary raw table, keeping the first row of each natural key.
+func stageRegimeZip(ctx context.Context, repo *TributarioRepo, staging pgx.Identifier, zipPath, dataset string, quarantine *importer.Quarantine, budget *importer.Budget, logger zerolog.Logger) (RegimeImportResult, []importer.FileStats, error) {
+	res := RegimeImportResult{Dataset: dataset, File: filepath.Base(zipPath)}
+
+	tx, err := repo.conn.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("open zip: %w", err)
+		return res, nil, fmt.Errorf("begin: %w", err)
 	}
-	defer r.Close()
+	defer tx.Rollback(ctx)
 
-	for _, f := range r.File {
-		rc, err := f.Open()
-		if err != nil {
-			return fmt.Errorf("open file inside zip: %w", err)
-		}
-		defer rc.Close()
+	if err := repo.stage(ctx, tx); err != nil {
+		return res, nil, fmt.Errorf("create raw table: %w", err)
+	}
 
-		reader := csv.NewReader(rc)
-		reader.Comma = ','
-		reader.LazyQuotes = true
+	sink := &regimeSink{tx: tx}
+	im := importer.New(layout.Regimes, sink, 10000, logger)
+	im.Quarantine, im.Budget = quarantine, budget
+	stats, err := im.ImportZip(ctx, zipPath)
+	for _, st := range stats {
+		res.Skipped += st.Skipped
+	}
+	res.Staged = sink.staged
+	if err != nil {
+		return res, stats, err
+	}
 
-		// Skip header
-		if _, err := reader.Read(); err != nil {
-			return fmt.Errorf("read header: %w", err)
-		}
+	if err := repo.keep(ctx, tx, staging, &res); err != nil {
+		return res, stats, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return res, stats, fmt.Errorf("commit: %w", err)
+	}
+	return res, stats, nil
+}
 
-		var batch []RegimeDTO
+type RegimeFile struct {
+	Name    string
+	Dataset string
+}
 
-		for {
-			record, err := reader.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("read row: %w", err)
-			}
-			if len(record) < 5 {
-				continue
-			}
+// RegimeFiles maps each tax regime zip to the dataset name stored with its rows.
+var RegimeFiles = []RegimeFile{
+	{"Lucro Presumido.zip", "Lucro Presumido"},
+	{"Lucro Real.zip", "Lucro Real"},
+	{"Lucro Arbitrado.zip", "Lucro Arbitrado"},
+	{"Imunes e Isentas.zip", "Imunes e Isentas"},
+}
 
-			ano, _ := strconv.Atoi(record[0])
-			cnpj := normalizeCNPJ(record[1])
-			cnpjSCP := record[2]
-			forma := strings.TrimSpace(record[3])
-			qtd, _ := strconv.Atoi(record[4])
-
-			batch = append(batch, RegimeDTO{
-				Ano:                     ano,
-				CNPJ:                    cnpj,
-				CNPJdaSCP:               cnpjSCP,
-				FormaTributacao:         forma,
-				QuantidadeEscrituracoes: qtd,
-				Dataset:                 dataset,
-			})
-
-			if len(batch) >= 10000 {
-				if err := repo.InsertBatch(ctx, batch); err != nil {
-					return err
-				}
-				batch = batch[:0]
-			}
+// ImportAllRegimes reloads tributario.regimes from the tax regime zips in
+// baseDir. Each file replaces its dataset's rows for the years it covers;
+// the other datasets of those years are carried over. The year partitions
+// are built one by one and swapped in together, in one transaction, so a
+// failed reload leaves every year as it was. Years no file covers are
+// left alone.
+// Datasets are staged concurrently as configured by opts.
+func ImportAllRegimes(ctx context.Context, repo *TributarioRepo, baseDir string, opts ImportOptions, logger zerolog.Logger) (res RegimeReloadResult, err error) {
+	res = RegimeReloadResult{Datasets: []RegimeImportResult{}}
+	unlock, err := lockReload(ctx, repo.conn, regimesTable)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	quarantine := importer.NewQuarantine(opts.Rejects, "tributario-regimes")
+	defer func() {
+		quarantine.Close()
+		res.Quarantine = quarantine.Path()
+	}()
+
+	var found []RegimeFile
+	var paths []string
+	for _, f := range RegimeFiles {
+		zipPath := filepath.Join(baseDir, f.Name)
+		if _, err := os.Stat(zipPath); os.IsNotExist(err) {
+			logger.Warn().Str("file", zipPath).Msg("Regime file missing")
+			continue
 		}
+		found, paths = append(found, f), append(paths, zipPath)
+	}
+	if len(found) == 0 {
+		return res, nil
+	}
+
+	if _, err := repo.conn.Exec(ctx, "DROP TABLE IF EXISTS "+regimesLoad.Sanitize()); err != nil {
+		return res, fmt.Errorf("drop load table: %w", err)
+	}
+	cols := strings.Join(regimeColumns, ", ") + ", dataset"
+	if _, err := repo.conn.Exec(ctx, "CREATE UNLOGGED TABLE "+regimesLoad.Sanitize()+" AS SELECT "+cols+" FROM "+regimesTable+" WITH NO DATA"); err != nil {
+		return res, fmt.Errorf("create load table: %w", err)
+	}
+	defer func() {
+		_, _ = repo.conn.Exec(context.WithoutCancel(ctx), "DROP TABLE IF EXISTS "+regimesLoad.Sanitize())
+	}()
 
-		if len(batch) > 0 {
-			if err := repo.InsertBatch(ctx, batch); err != nil {
-				return err
+	var mu sync.Mutex
+	datasets := make([]*RegimeImportResult, len(found))
+	budget := importer.NewBudget(opts.Parallel.MemoryBudget)
+	err = importer.ForEach(ctx, opts.workers(), paths, func(ctx context.Context, i int, zipPath string) error {
+		return opts.withConn(ctx, repo.conn, func(conn DB) error {
+			f := found[i]
+			logger.Info().Str("dataset", f.Dataset).Msg("Importing regime")
+			ds, stats, err := stageRegimeZip(ctx, NewTributarioRepo(conn), regimesLoad, zipPath, f.Dataset, quarantine, budget, logger)
+			mu.Lock()
+			res.Add(stats...)
+			mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("import %s: %w", f.Dataset, err)
 			}
+			datasets[i] = &ds
+			logger.Info().Str("dataset", f.Dataset).Ints("years", ds.Years).Int64("inserted", ds.Inserted).Int64("duplicates", ds.Duplicates).Msg("Regime staged")
+			return nil
+		})
+	})
+	for _, ds := range datasets {
+		if ds != nil {
+			res.Datasets = append(res.Datasets, *ds)
 		}
 	}
+	if err != nil {
+		return res, err
+	}
 
-	return nil
-}
-
-func ImportAllRegimes(ctx context.Context, repo *TributarioRepo, baseDir string, logger zerolog.Logger) error {
-	files := []struct {
-		Name    string
-		Dataset string
-	}{
-		{"Lucro Presumido.zip", "Lucro Presumido"},
-		{"Lucro Real.zip", "Lucro Real"},
-		{"Lucro Arbitrado.zip", "Lucro Arbitrado"},
-		{"Imunes e Isentas.zip", "Imunes e Isentas"},
+	rows, err := repo.conn.Query(ctx, "SELECT DISTINCT ano FROM "+regimesLoad.Sanitize()+" ORDER BY ano")
+	if err != nil {
+		return res, fmt.Errorf("list years: %w", err)
+	}
+	years, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return res, fmt.Errorf("list years: %w", err)
+	}
+	staged := make([]*stagedSwap, 0, len(years))
+	reloaded := map[string]bool{}
+	for _, year := range years {
+		part, err := repo.regimePartition(ctx, year, logger)
+		if err != nil {
+			return res, err
+		}
+		if err := part.prepare(ctx, repo.conn); err != nil {
+			return res, err
+		}
+		loaded, carried, err := repo.fill(ctx, part, year)
+		if err != nil {
+			return res, err
+		}
+		res.Carried += carried
+		s, err := part.build(ctx, repo.conn, loaded, logger)
+		if err != nil {
+			return res, fmt.Errorf("build %s: %w", part.qualified(""), err)
+		}
+		staged = append(staged, s)
+		reloaded[part.name] = true
 	}
 
-	for _, f := range files {
-		zipPath := filepath.Join(baseDir, f.Name)
-		if _, err := os.Stat(zipPath); os.IsNotExist(err) {
-			logger.Warn().Str("file", zipPath).Msg("Regime file missing")
+	tx, err := beginSwap(ctx, repo.conn)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+	for _, s := range staged {
+		if err := s.swap(ctx, tx); err != nil {
+			return res, fmt.Errorf("swap %s: %w", s.res.Table, err)
+		}
+	}
+	// previous versions of the other years were kept by older reloads;
+	// dropping them makes a rollback undo this reload and nothing else
+	parts, err := partitionsOf(ctx, tx, regimesTable)
+	if err != nil {
+		return res, err
+	}
+	for _, p := range parts {
+		if reloaded[p.name] {
 			continue
 		}
-		logger.Info().Str("dataset", f.Dataset).Msg("Importing regime")
-		if err := ImportRegimeZip(ctx, repo, zipPath, f.Dataset, logger); err != nil {
-			return fmt.Errorf("import %s: %w", f.Dataset, err)
+		if _, err := tx.Exec(ctx, "DROP TABLE "+p.ident(previousSuffix).Sanitize()); err != nil {
+			return res, fmt.Errorf("drop previous version of %s: %w", p.qualified(""), err)
 		}
-		logger.Info().Str("dataset", f.Dataset).Msg("Regime import completed")
 	}
-	return nil
+	if err := tx.Commit(ctx); err != nil {
+		return res, fmt.Errorf("commit swap: %w", err)
+	}
+	res.Partitions = make([]SwapResult, len(staged))
+	for i, s := range staged {
+		res.Partitions[i] = s.done(logger)
+	}
+	logger.Info().Ints("years", years).Int64("carried", res.Carried).Msg("Regime import completed")
+	return res, nil
 }
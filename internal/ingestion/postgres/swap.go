@@ -0,0 +1,542 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+)
+
+const (
+	stagingSuffix  = "_staging"
+	previousSuffix = "_previous"
+	// a reload may not shrink a table below this fraction of its rows
+	minReloadRatio = 0.5
+)
+
+// SwappableTables lists the tables reloaded through a staging swap, which
+// are the ones RollbackTable accepts. tributario.regimes is swapped by
+// year partition.
+var SwappableTables = []string{"cnpj.empresas", "cnpj.estabelecimentos", "cnpj.socios", "cnpj.simples", "tributario.regimes"}
+
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// DB is what the repos need from Postgres: a *pgxpool.Pool for short reads,
+// or a single *pgx.Conn held by the caller for imports, which rely on
+// session state such as temporary tables and advisory locks.
+type DB interface {
+	querier
+	Begin(ctx context.Context) (pgx.Tx, error)
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// Conns runs fn on a dedicated connection with the import statement
+// timeout; parallel reloads take one per worker.
+type Conns func(ctx context.Context, fn func(conn *pgx.Conn) error) error
+
+// ImportOptions tunes a reload. Rejects configures the quarantine of
+// rejected rows. With Conns set and more than one worker, files are loaded
+// concurrently into the staging table, each on its own connection.
+type ImportOptions struct {
+	Rejects  importer.Rejects
+	Parallel importer.Parallelism
+	Conns    Conns
+}
+
+func (o ImportOptions) workers() int {
+	if o.Conns == nil {
+		return 1
+	}
+	return max(o.Parallel.Workers, 1)
+}
+
+// withConn runs fn on a worker connection, or on conn when the reload
+// runs one file at a time.
+func (o ImportOptions) withConn(ctx context.Context, conn DB, fn func(conn DB) error) error {
+	if o.workers() == 1 {
+		return fn(conn)
+	}
+	return o.Conns(ctx, func(c *pgx.Conn) error { return fn(c) })
+}
+
+// lockReload takes a session advisory lock on table, so a manual reload
+// and the scheduler can't load the same staging table at once.
+func lockReload(ctx context.Context, conn DB, table string) (unlock func(), err error) {
+	var ok bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", "reload:"+table).Scan(&ok); err != nil {
+		return nil, fmt.Errorf("lock %s: %w", table, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("a reload of %s is already running", table)
+	}
+	return func() {
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", "reload:"+table)
+	}, nil
+}
+
+// SwapResult reports a swap. PreviousRows is the row count of the version
+// kept as <table>_previous.
+type SwapResult struct {
+	Table        string        `json:"table"`
+	Rows         int64         `json:"rows"`
+	PreviousRows int64         `json:"previous_rows"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// swapTable reloads a live table through an UNLOGGED copy without indexes.
+// Once loaded, the copy gets the indexes and foreign keys of the live table
+// and the two trade places in one transaction; the replaced version stays
+// as <table>_previous until the next reload. A partition is detached
+// from parent for the swap and its replacement attached with bound.
+type swapTable struct {
+	schema string
+	name   string
+	parent string
+	bound  string
+}
+
+func newSwapTable(qualified string) swapTable {
+	schema, name, _ := strings.Cut(qualified, ".")
+	return swapTable{schema: schema, name: name}
+}
+
+func (t swapTable) ident(suffix string) pgx.Identifier {
+	return pgx.Identifier{t.schema, t.name + suffix}
+}
+
+func (t swapTable) qualified(suffix string) string {
+	return t.schema + "." + t.name + suffix
+}
+
+// prepare recreates the empty staging table.
+func (t swapTable) prepare(ctx context.Context, q querier) error {
+	staging, live := t.ident(stagingSuffix).Sanitize(), t.ident("").Sanitize()
+	if _, err := q.Exec(ctx, "DROP TABLE IF EXISTS "+staging); err != nil {
+		return fmt.Errorf("drop staging table: %w", err)
+	}
+	sql := "CREATE UNLOGGED TABLE " + staging + " (LIKE " + live +
+		" INCLUDING DEFAULTS INCLUDING IDENTITY INCLUDING GENERATED INCLUDING CONSTRAINTS)"
+	if _, err := q.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("create staging table: %w", err)
+	}
+	return nil
+}
+
+type tableIndex struct {
+	name    string
+	def     string
+	contype string
+}
+
+func indexesOf(ctx context.Context, q querier, table string) ([]tableIndex, error) {
+	rows, err := q.Query(ctx, `SELECT i.relname, pg_get_indexdef(x.indexrelid), COALESCE(c.contype::text, '')
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		LEFT JOIN pg_constraint c ON c.conindid = x.indexrelid AND c.conrelid = x.indrelid
+		WHERE x.indrelid = $1::regclass
+		ORDER BY i.relname`, table)
+	if err != nil {
+		return nil, fmt.Errorf("list indexes of %s: %w", table, err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (tableIndex, error) {
+		var ix tableIndex
+		err := row.Scan(&ix.name, &ix.def, &ix.contype)
+		return ix, err
+	})
+}
+
+func foreignKeysOf(ctx context.Context, q querier, table string) (map[string]string, error) {
+	rows, err := q.Query(ctx, `SELECT conname, pg_get_constraintdef(oid)
+		FROM pg_constraint WHERE conrelid = $1::regclass AND contype = 'f'`, table)
+	if err != nil {
+		return nil, fmt.Errorf("list foreign keys of %s: %w", table, err)
+	}
+	defer rows.Close()
+	fks := make(map[string]string)
+	for rows.Next() {
+		var name, def string
+		if err := rows.Scan(&name, &def); err != nil {
+			return nil, err
+		}
+		fks[name] = def
+	}
+	return fks, rows.Err()
+}
+
+func countRows(ctx context.Context, q querier, table pgx.Identifier) (int64, error) {
+	var n int64
+	err := q.QueryRow(ctx, "SELECT count(*) FROM "+table.Sanitize()).Scan(&n)
+	return n, err
+}
+
+// stagedSwap is a staging table ready to trade places with the live one.
+type stagedSwap struct {
+	table   swapTable
+	res     SwapResult
+	indexes []tableIndex
+	fks     map[string]string
+	start   time.Time
+}
+
+// finish validates the staging table against the loaded row count, builds
+// its indexes and foreign keys, and swaps it with the live table.
+func (t swapTable) finish(ctx context.Context, conn DB, loaded int64, logger zerolog.Logger) (SwapResult, error) {
+	staged, err := t.build(ctx, conn, loaded, logger)
+	if err != nil {
+		return staged.res, err
+	}
+	tx, err := beginSwap(ctx, conn)
+	if err != nil {
+		return staged.res, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := staged.swap(ctx, tx); err != nil {
+		return staged.res, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return staged.res, fmt.Errorf("commit swap: %w", err)
+	}
+	return staged.done(logger), nil
+}
+
+// build does the slow part of finish, everything before the swap.
+func (t swapTable) build(ctx context.Context, conn DB, loaded int64, logger zerolog.Logger) (*stagedSwap, error) {
+	staged := &stagedSwap{table: t, res: SwapResult{Table: t.qualified("")}, start: time.Now()}
+	res := &staged.res
+	staging, live := t.ident(stagingSuffix), t.ident("")
+
+	n, err := countRows(ctx, conn, staging)
+	if err != nil {
+		return staged, fmt.Errorf("count staging rows: %w", err)
+	}
+	if n != loaded {
+		return staged, fmt.Errorf("staging has %d rows, loaded %d", n, loaded)
+	}
+	if n == 0 {
+		return staged, fmt.Errorf("refusing to replace %s with an empty load", res.Table)
+	}
+	current, err := countRows(ctx, conn, live)
+	if err != nil {
+		return staged, fmt.Errorf("count live rows: %w", err)
+	}
+	if float64(n) < float64(current)*minReloadRatio {
+		return staged, fmt.Errorf("reload of %s has %d rows, less than %.0f%% of the current %d", res.Table, n, minReloadRatio*100, current)
+	}
+	res.Rows, res.PreviousRows = n, current
+
+	if _, err := conn.Exec(ctx, "ALTER TABLE "+staging.Sanitize()+" SET LOGGED"); err != nil {
+		return staged, fmt.Errorf("set staging logged: %w", err)
+	}
+
+	if staged.indexes, err = indexesOf(ctx, conn, t.qualified("")); err != nil {
+		return staged, err
+	}
+	if err := t.copyIndexes(ctx, conn, stagingSuffix, staged.indexes, logger); err != nil {
+		return staged, err
+	}
+
+	if staged.fks, err = foreignKeysOf(ctx, conn, t.qualified("")); err != nil {
+		return staged, err
+	}
+	for name, def := range staged.fks {
+		constraint := pgx.Identifier{name + stagingSuffix}.Sanitize()
+		if _, err := conn.Exec(ctx, "ALTER TABLE "+staging.Sanitize()+" ADD CONSTRAINT "+constraint+" "+def+" NOT VALID"); err != nil {
+			return staged, fmt.Errorf("add foreign key %s: %w", name, err)
+		}
+		if _, err := conn.Exec(ctx, "ALTER TABLE "+staging.Sanitize()+" VALIDATE CONSTRAINT "+constraint); err != nil {
+			return staged, fmt.Errorf("validate foreign key %s: %w", name, err)
+		}
+	}
+	if _, err := conn.Exec(ctx, "ANALYZE "+staging.Sanitize()); err != nil {
+		return staged, fmt.Errorf("analyze staging: %w", err)
+	}
+	return staged, nil
+}
+
+// beginSwap starts the transaction swaps run in.
+func beginSwap(ctx context.Context, conn DB) (pgx.Tx, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	// readers hold locks on the live table; wait for them, but not forever
+	if _, err := tx.Exec(ctx, "SET LOCAL lock_timeout = '60s'"); err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// swap trades the staging and live tables in tx; the live one becomes the
+// previous version, replacing the one kept before.
+func (s *stagedSwap) swap(ctx context.Context, tx pgx.Tx) error {
+	t := s.table
+	if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+t.ident(previousSuffix).Sanitize()); err != nil {
+		return fmt.Errorf("drop previous version: %w", err)
+	}
+	if err := t.detach(ctx, tx); err != nil {
+		return err
+	}
+	if err := t.rename(ctx, tx, "", previousSuffix, s.indexes); err != nil {
+		return err
+	}
+	if err := t.rename(ctx, tx, stagingSuffix, "", s.indexes); err != nil {
+		return err
+	}
+	if err := t.attach(ctx, tx); err != nil {
+		return err
+	}
+	for name := range s.fks {
+		sql := "ALTER TABLE " + t.ident("").Sanitize() + " RENAME CONSTRAINT " + pgx.Identifier{name + stagingSuffix}.Sanitize() + " TO " + pgx.Identifier{name}.Sanitize()
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("rename foreign key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// done logs the committed swap and returns its result.
+func (s *stagedSwap) done(logger zerolog.Logger) SwapResult {
+	s.res.Duration = time.Since(s.start)
+	logger.Info().Str("table", s.res.Table).Int64("rows", s.res.Rows).Int64("previous_rows", s.res.PreviousRows).Msg("🔁 Swapped in reloaded table")
+	return s.res
+}
+
+// copyIndexes builds the live table's indexes on the table with suffix,
+// named after them with the suffix appended, with the constraints they back.
+func (t swapTable) copyIndexes(ctx context.Context, conn DB, suffix string, indexes []tableIndex, logger zerolog.Logger) error {
+	table := t.ident(suffix).Sanitize()
+	for _, ix := range indexes {
+		logger.Info().Str("table", t.qualified(suffix)).Str("index", ix.name).Msg("Building index")
+		def := strings.Replace(ix.def, "INDEX "+ix.name+" ON ", "INDEX "+ix.name+suffix+" ON ", 1)
+		def = strings.Replace(def, " ON "+t.qualified("")+" ", " ON "+t.qualified(suffix)+" ", 1)
+		def = strings.Replace(def, " ON ONLY "+t.qualified("")+" ", " ON ONLY "+t.qualified(suffix)+" ", 1)
+		if _, err := conn.Exec(ctx, def); err != nil {
+			return fmt.Errorf("create index %s: %w", ix.name, err)
+		}
+		kind := map[string]string{"p": "PRIMARY KEY", "u": "UNIQUE"}[ix.contype]
+		if kind == "" {
+			continue
+		}
+		name := pgx.Identifier{ix.name + suffix}.Sanitize()
+		if _, err := conn.Exec(ctx, "ALTER TABLE "+table+" ADD CONSTRAINT "+name+" "+kind+" USING INDEX "+name); err != nil {
+			return fmt.Errorf("add constraint %s: %w", ix.name, err)
+		}
+	}
+	return nil
+}
+
+// rename moves the table with suffix from, and its indexes, to suffix to.
+// indexes are named without the suffix.
+func (t swapTable) rename(ctx context.Context, tx pgx.Tx, from, to string, indexes []tableIndex) error {
+	sql := "ALTER TABLE " + t.ident(from).Sanitize() + " RENAME TO " + pgx.Identifier{t.name + to}.Sanitize()
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("rename %s: %w", t.qualified(from), err)
+	}
+	for _, ix := range indexes {
+		sql := "ALTER INDEX " + pgx.Identifier{t.schema, ix.name + from}.Sanitize() + " RENAME TO " + pgx.Identifier{ix.name + to}.Sanitize()
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("rename index %s: %w", ix.name+from, err)
+		}
+	}
+	return nil
+}
+
+// detach takes a partition out of its parent; attach puts the table now
+// named after it back. Both do nothing for a plain table.
+func (t swapTable) detach(ctx context.Context, tx pgx.Tx) error {
+	if t.parent == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "ALTER TABLE "+t.parent+" DETACH PARTITION "+t.ident("").Sanitize()); err != nil {
+		return fmt.Errorf("detach %s: %w", t.qualified(""), err)
+	}
+	return nil
+}
+
+func (t swapTable) attach(ctx context.Context, tx pgx.Tx) error {
+	if t.parent == "" {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "ALTER TABLE "+t.parent+" ATTACH PARTITION "+t.ident("").Sanitize()+" "+t.bound); err != nil {
+		return fmt.Errorf("attach %s: %w", t.qualified(""), err)
+	}
+	return nil
+}
+
+// partitionsOf lists the partitions of table that have a previous version.
+func partitionsOf(ctx context.Context, q querier, table string) ([]swapTable, error) {
+	rows, err := q.Query(ctx, `SELECT n.nspname, c.relname, pg_get_expr(c.relpartbound, c.oid)
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE i.inhparent = $1::regclass
+			AND to_regclass(quote_ident(n.nspname) || '.' || quote_ident(c.relname || $2)) IS NOT NULL
+		ORDER BY c.relname`, table, previousSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions of %s: %w", table, err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (swapTable, error) {
+		t := swapTable{parent: table}
+		err := row.Scan(&t.schema, &t.name, &t.bound)
+		return t, err
+	})
+}
+
+// RollbackTable puts the previous version of a swapped table back in place;
+// the replaced version becomes the previous one, so rolling back twice
+// restores the reload. A partitioned table rolls back every partition
+// with a previous version, which are the ones the last reload swapped, in
+// one transaction, and the counts add up theirs.
+func RollbackTable(ctx context.Context, conn DB, table string, logger zerolog.Logger) (SwapResult, error) {
+	t := newSwapTable(table)
+	res := SwapResult{Table: t.qualified("")}
+
+	unlock, err := lockReload(ctx, conn, res.Table)
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	var partitioned bool
+	if err := conn.QueryRow(ctx, "SELECT relkind = 'p' FROM pg_class WHERE oid = $1::regclass", res.Table).Scan(&partitioned); err != nil {
+		return res, fmt.Errorf("inspect %s: %w", res.Table, err)
+	}
+	parts := []swapTable{t}
+	if partitioned {
+		if parts, err = partitionsOf(ctx, conn, res.Table); err != nil {
+			return res, err
+		}
+		if len(parts) == 0 {
+			return res, fmt.Errorf("no previous version of %s", res.Table)
+		}
+	}
+
+	rollbacks := make([]*pendingRollback, len(parts))
+	for i, p := range parts {
+		if rollbacks[i], err = p.prepareRollback(ctx, conn, logger); err != nil {
+			return res, err
+		}
+	}
+	// partitions roll back together, like they were swapped in
+	tx, err := beginSwap(ctx, conn)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+	for _, rb := range rollbacks {
+		r, err := rb.apply(ctx, tx)
+		if err != nil {
+			return res, err
+		}
+		res.Rows += r.Rows
+		res.PreviousRows += r.PreviousRows
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return res, fmt.Errorf("commit rollback: %w", err)
+	}
+	logger.Info().Str("table", res.Table).Int64("rows", res.Rows).Int("tables", len(parts)).Msg("⏪ Rolled back to previous table version")
+	return res, nil
+}
+
+// pendingRollback is a table whose previous version has the indexes to
+// take the live table's place.
+type pendingRollback struct {
+	table    swapTable
+	live     []tableIndex
+	previous []tableIndex
+}
+
+func (t swapTable) prepareRollback(ctx context.Context, conn DB, logger zerolog.Logger) (*pendingRollback, error) {
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", t.qualified(previousSuffix)).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no previous version of %s", t.qualified(""))
+	}
+	live, err := indexesOf(ctx, conn, t.qualified(""))
+	if err != nil {
+		return nil, err
+	}
+	previous, err := t.previousIndexes(ctx, conn, live, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingRollback{table: t, live: live, previous: previous}, nil
+}
+
+// apply trades the live and previous versions in tx.
+func (rb *pendingRollback) apply(ctx context.Context, tx pgx.Tx) (SwapResult, error) {
+	t := rb.table
+	res := SwapResult{Table: t.qualified("")}
+	if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+t.ident(stagingSuffix).Sanitize()); err != nil {
+		return res, fmt.Errorf("drop staging table: %w", err)
+	}
+	if err := t.detach(ctx, tx); err != nil {
+		return res, err
+	}
+	if err := t.rename(ctx, tx, "", stagingSuffix, rb.live); err != nil {
+		return res, err
+	}
+	if err := t.rename(ctx, tx, previousSuffix, "", rb.previous); err != nil {
+		return res, err
+	}
+	if err := t.rename(ctx, tx, stagingSuffix, previousSuffix, rb.live); err != nil {
+		return res, err
+	}
+	if err := t.attach(ctx, tx); err != nil {
+		return res, err
+	}
+	var err error
+	if res.Rows, err = countRows(ctx, tx, t.ident("")); err != nil {
+		return res, err
+	}
+	if res.PreviousRows, err = countRows(ctx, tx, t.ident(previousSuffix)); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// previousIndexes lists the indexes of the previous version by the names
+// they get back on rollback. Indexes the live table gained since, say from
+// a migration, are built on it first, so rolling back doesn't lose them.
+func (t swapTable) previousIndexes(ctx context.Context, conn DB, live []tableIndex, logger zerolog.Logger) ([]tableIndex, error) {
+	previous, err := indexesOf(ctx, conn, t.qualified(previousSuffix))
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(previous))
+	var renamed []tableIndex
+	for _, ix := range previous {
+		have[ix.name] = true
+		// swapped out indexes got the suffix; any other name stays as is
+		if name, ok := strings.CutSuffix(ix.name, previousSuffix); ok {
+			ix.name = name
+			have[name] = true
+			renamed = append(renamed, ix)
+		}
+	}
+	var missing []tableIndex
+	for _, ix := range live {
+		if !have[ix.name] {
+			missing = append(missing, ix)
+		}
+	}
+	if err := t.copyIndexes(ctx, conn, previousSuffix, missing, logger); err != nil {
+		return nil, err
+	}
+	return append(renamed, missing...), nil
+}
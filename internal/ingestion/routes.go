@@ -1,54 +1,416 @@
 package ingestion
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/infra/providers"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	mongoimport "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/mongo"
 	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/csvx"
 	"github.com/BrunoGuimaraesSilva/receitago/pkg/httputil"
 )
 
-func RegisterRoutes(r chi.Router, pg *pgx.Conn, mongo *mongo.Client, cfg *config.Config, logger zerolog.Logger) {
+func RegisterRoutes(r chi.Router, pg *pgxpool.Pool, mongo *mongo.Client, cfg *config.Config, logger zerolog.Logger) {
+	// imports hold one connection for their whole run, see database.WithConn
+	withConn := func(r *http.Request, fn func(conn *pgx.Conn) error) error {
+		return database.WithConn(r.Context(), pg, cfg.PGImportTimeout, fn)
+	}
+	rejects := importer.Rejects{Dir: cfg.QuarantineDir, MaxRatio: cfg.MaxRejectedRatio}
+	parallel := importer.Parallelism{Workers: cfg.ImportWorkers, MemoryBudget: cfg.ImportMemoryBudget}
+	reloadOpts := postgres.ImportOptions{
+		Rejects:  rejects,
+		Parallel: parallel,
+		Conns: func(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+			return database.WithConn(ctx, pg, cfg.PGImportTimeout, fn)
+		},
+	}
+	runs := postgres.NewImportRunRepo(pg)
+	record := func(r *http.Request, kind, batch string, fn func() (importer.Summarizer, error)) error {
+		return postgres.RecordImport(r.Context(), runs, kind, batch, logger, fn)
+	}
+	receitaBatch := func() string { return providers.LastDownloadedBatch(cfg.DataDir + "/receita") }
+
 	// @Summary Import tax dictionaries
 	// @Description Imports tax-related dictionaries into PostgreSQL
 	// @Tags import
 	// @Accept json
 	// @Produce json
 	// @Security BearerAuth
-	// @Success 200 {object} models.SuccessResponse "Successfully imported"
+	// @Success 200 {object} ingestion.DictionaryImportResult "Rows per table and rejected rows"
 	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
 	// @Failure 500 {object} models.ErrorResponse "Internal server error"
 	// @Router /v1/import/dictionaries [post]
 	r.Post("/import/dictionaries", func(w http.ResponseWriter, r *http.Request) {
-		repo := postgres.NewDictionaryRepo(pg)
-		if err := postgres.ImportAllDictionaries(r.Context(), repo, cfg.DataDir+"/zips", logger); err != nil {
+		var res postgres.DictionaryImportResult
+		err := record(r, "dictionaries", receitaBatch(), func() (importer.Summarizer, error) {
+			err := withConn(r, func(conn *pgx.Conn) (err error) {
+				res, err = postgres.ImportAllDictionaries(r.Context(), postgres.NewDictionaryRepo(conn), cfg.DataDir+"/zips", rejects, logger)
+				return err
+			})
+			return res, err
+		})
+		if err != nil {
 			httputil.WriteError(w, http.StatusInternalServerError, err)
 			return
 		}
-		httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "success", "message": "Dictionaries imported"})
+		httputil.WriteJSON(w, http.StatusOK, res)
 	})
 
 	// @Summary Import tax regime data
-	// @Description Imports tax regime (tributário) data into PostgreSQL
+	// @Description Reloads tax regime (tributário) data into PostgreSQL, replacing each dataset's rows for the years in its file
 	// @Tags import
 	// @Accept json
 	// @Produce json
 	// @Security BearerAuth
-	// @Success 200 {object} models.SuccessResponse "Successfully imported"
+	// @Success 200 {object} ingestion.RegimeReloadResult "Per-dataset load results and the table swap"
 	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
 	// @Failure 500 {object} models.ErrorResponse "Internal server error"
 	// @Router /v1/import/tributario [post]
 	r.Post("/import/tributario", func(w http.ResponseWriter, r *http.Request) {
-		repo := postgres.NewTributarioRepo(pg)
-		if err := postgres.ImportAllRegimes(r.Context(), repo, cfg.DataDir, logger); err != nil {
+		var results postgres.RegimeReloadResult
+		err := record(r, "tributario", receitaBatch(), func() (importer.Summarizer, error) {
+			err := withConn(r, func(conn *pgx.Conn) (err error) {
+				results, err = postgres.ImportAllRegimes(r.Context(), postgres.NewTributarioRepo(conn), cfg.DataDir+"/zips", reloadOpts, logger)
+				return err
+			})
+			return results, err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, results)
+	})
+
+	// @Summary Import Tesouro datasets
+	// @Description Imports the downloaded Tesouro Nacional (SIAFI) CSVs into PostgreSQL; unchanged files are skipped
+	// @Tags import
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {array} ingestion.TesouroImportResult "Per-file import results"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/import/tesouro [post]
+	r.Post("/import/tesouro", func(w http.ResponseWriter, r *http.Request) {
+		var results postgres.TesouroImportResults
+		err := record(r, "tesouro", "", func() (importer.Summarizer, error) {
+			err := withConn(r, func(conn *pgx.Conn) (err error) {
+				results, err = postgres.ImportAllTesouro(r.Context(), postgres.NewTesouroRepo(conn), cfg.DataDir+"/tesouro", logger)
+				return err
+			})
+			return results, err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, results)
+	})
+
+	// @Summary Import Receita files into MongoDB
+	// @Description Imports every EmpresasN/EstabelecimentosN/SociosN/Simples zip of the current batch into its Mongo collection
+	// @Tags import
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Param entity path string true "Entity" Enums(empresas, estabelecimentos, socios, simples)
+	// @Param prune query bool false "Delete documents missing from the batch (defaults to MONGO_PRUNE_STALE)"
+	// @Param encoding query string false "CSV encoding, auto to detect it (defaults to RECEITA_ENCODING, then iso-8859-1)" Enums(auto, utf-8, iso-8859-1, windows-1252)
+	// @Success 200 {object} ingestion.ImportResult "Per-file row counts"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid parameters"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown entity"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/import/mongo/{entity} [post]
+	r.Post("/import/mongo/{entity}", func(w http.ResponseWriter, r *http.Request) {
+		entity := chi.URLParam(r, "entity")
+		if _, ok := mongoimport.Entities[entity]; !ok {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown entity %q", entity))
+			return
+		}
+		prune := cfg.MongoPrune
+		if v := r.URL.Query().Get("prune"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid prune: %w", err))
+				return
+			}
+			prune = b
+		}
+		encoding := cfg.ReceitaEncoding
+		if v := r.URL.Query().Get("encoding"); v != "" {
+			if _, err := csvx.ParseEncoding(v); err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			encoding = v
+		}
+		opts := mongoimport.ImportOptions{
+			Batch:    receitaBatch(),
+			Prune:    prune,
+			Encoding: encoding,
+			Rejects:  rejects,
+			Parallel: parallel,
+		}
+		db := mongo.Database(cfg.MongoDB)
+		var res mongoimport.ImportResult
+		err := record(r, "mongo "+entity, opts.Batch, func() (importer.Summarizer, error) {
+			var err error
+			res, err = mongoimport.ImportEntity(r.Context(), db, entity, cfg.DataDir+"/zips", opts, logger)
+			return res, err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary Import CNPJ data into PostgreSQL
+	// @Description Replaces cnpj.empresas, cnpj.estabelecimentos, cnpj.socios or cnpj.simples with every zip of the current batch using COPY
+	// @Tags import
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Param entity path string true "Entity" Enums(empresas, estabelecimentos, socios, simples)
+	// @Success 200 {object} ingestion.CNPJImportResult "Per-file row counts and unknown codes"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown entity"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/import/cnpj/{entity} [post]
+	r.Post("/import/cnpj/{entity}", func(w http.ResponseWriter, r *http.Request) {
+		entity := chi.URLParam(r, "entity")
+		if _, ok := postgres.CNPJTables[entity]; !ok {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown entity %q", entity))
+			return
+		}
+		var res postgres.CNPJImportResult
+		err := record(r, "cnpj "+entity, receitaBatch(), func() (importer.Summarizer, error) {
+			err := withConn(r, func(conn *pgx.Conn) (err error) {
+				res, err = postgres.ImportCNPJTable(r.Context(), postgres.NewCNPJRepo(conn), entity, cfg.DataDir+"/zips", reloadOpts, logger)
+				return err
+			})
+			return res, err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary Roll back a reloaded table
+	// @Description Swaps a reloaded table with the previous version kept by the last reload
+	// @Tags import
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Param table path string true "Table" Enums(cnpj.empresas, cnpj.estabelecimentos, cnpj.socios, cnpj.simples, tributario.regimes)
+	// @Success 200 {object} ingestion.SwapResult "Rows of the restored and replaced versions"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown table"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/import/rollback/{table} [post]
+	r.Post("/import/rollback/{table}", func(w http.ResponseWriter, r *http.Request) {
+		table := chi.URLParam(r, "table")
+		if !slices.Contains(postgres.SwappableTables, table) {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown table %q", table))
+			return
+		}
+		var res postgres.SwapResult
+		err := withConn(r, func(conn *pgx.Conn) (err error) {
+			res, err = postgres.RollbackTable(r.Context(), conn, table, logger)
+			return err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary Update the CNPJ history
+	// @Description Records the current cnpj tables as a batch in the Type-2 history of estabelecimentos (situação, address, CNAE principal) and sócios. Batches must be applied in order; the last one again is a no-op.
+	// @Tags import
+	// @Produce json
+	// @Security BearerAuth
+	// @Param batch query string false "Batch name (defaults to the last downloaded Receita batch)"
+	// @Success 200 {object} ingestion.HistoryResult "Versions opened and closed"
+	// @Failure 400 {object} models.BadRequestResponse "No batch given or downloaded"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/import/history [post]
+	r.Post("/import/history", func(w http.ResponseWriter, r *http.Request) {
+		batch := r.URL.Query().Get("batch")
+		if batch == "" {
+			batch = receitaBatch()
+		}
+		if batch == "" {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("no batch given and none downloaded"))
+			return
+		}
+		var res postgres.HistoryResult
+		err := record(r, "history", batch, func() (importer.Summarizer, error) {
+			err := withConn(r, func(conn *pgx.Conn) (err error) {
+				res, err = postgres.ApplyHistory(r.Context(), postgres.NewHistoryRepo(conn), batch, logger)
+				return err
+			})
+			return res, err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary Build the Mongo companies collection
+	// @Description Merges empresas, estabelecimentos and socios into one document per CNPJ básico with dictionary descriptions
+	// @Tags import
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {object} ingestion.BuildResult "Companies built"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/build/companies [post]
+	r.Post("/build/companies", func(w http.ResponseWriter, r *http.Request) {
+		var res mongoimport.BuildResult
+		err := record(r, "build companies", receitaBatch(), func() (importer.Summarizer, error) {
+			raw, err := postgres.LoadDictionaries(r.Context(), postgres.NewDictionaryRepo(pg))
+			if err != nil {
+				return res, fmt.Errorf("load dictionaries: %w", err)
+			}
+			res, err = mongoimport.BuildCompanies(r.Context(), mongo.Database(cfg.MongoDB), mongoimport.NewDictionaries(raw), logger)
+			return res, err
+		})
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary Repair text encoding in Mongo
+	// @Description Re-decodes text fields of documents imported with broken accents (raw Latin-1 or double-decoded UTF-8)
+	// @Tags import
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Param entity path string true "Entity" Enums(empresas, estabelecimentos, socios, simples)
+	// @Param dry_run query bool false "Only count the documents that would change"
+	// @Success 200 {object} ingestion.RepairResult "Repaired documents per field"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid parameters"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown entity"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/import/mongo/{entity}/repair-encoding [post]
+	r.Post("/import/mongo/{entity}/repair-encoding", func(w http.ResponseWriter, r *http.Request) {
+		entity := chi.URLParam(r, "entity")
+		if _, ok := mongoimport.Entities[entity]; !ok {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown entity %q", entity))
+			return
+		}
+		dryRun := false
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid dry_run: %w", err))
+				return
+			}
+			dryRun = b
+		}
+		res, err := mongoimport.RepairEncoding(r.Context(), mongo.Database(cfg.MongoDB), entity, dryRun, logger)
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, res)
+	})
+
+	// @Summary List import runs
+	// @Description Lists recorded imports, newest first, with files, row counts per table, rejected rows, duration and throughput
+	// @Tags import
+	// @Produce json
+	// @Security BearerAuth
+	// @Param kind query string false "Import kind, e.g. dictionaries, tributario, tesouro, cnpj empresas, mongo socios, build companies"
+	// @Param status query string false "Run status" Enums(running, success, failed)
+	// @Param before_id query int false "Only runs older than this id, for paging"
+	// @Param limit query int false "Maximum runs returned (default 50, at most 500)"
+	// @Success 200 {array} ingestion.ImportRun "Import runs"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid parameters"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/imports [get]
+	r.Get("/imports", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := postgres.ImportRunFilter{Kind: q.Get("kind"), Status: q.Get("status")}
+		if v := q.Get("before_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid before_id: %w", err))
+				return
+			}
+			filter.BeforeID = id
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || n == 0 || n > 500 {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+			filter.Limit = n
+		}
+		list, err := runs.List(r.Context(), filter)
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, list)
+	})
+
+	// @Summary Get an import run
+	// @Description Returns a recorded import with its full result
+	// @Tags import
+	// @Produce json
+	// @Security BearerAuth
+	// @Param id path int true "Run id"
+	// @Success 200 {object} ingestion.ImportRun "Import run"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid id"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown run"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/imports/{id} [get]
+	r.Get("/imports/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid id: %w", err))
+			return
+		}
+		run, err := runs.Get(r.Context(), id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("import run %d not found", id))
+			return
+		}
+		if err != nil {
 			httputil.WriteError(w, http.StatusInternalServerError, err)
 			return
 		}
-		httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "success", "message": "Tributário imported"})
+		httputil.WriteJSON(w, http.StatusOK, run)
 	})
 }
@@ -0,0 +1,419 @@
+package lookup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// errUnsupportedBatch is returned for a body that is neither JSON nor CSV.
+var errUnsupportedBatch = errors.New("unsupported content type, expected application/json, text/csv or multipart/form-data")
+
+const (
+	StatusFound    = "found"
+	StatusNotFound = "not_found"
+	StatusInvalid  = "invalid"
+	StatusError    = "error"
+)
+
+// BatchResult is the lookup of one input row. Row counts the CNPJs of the
+// input from 1, not counting a CSV header.
+type BatchResult struct {
+	Row     int      `json:"row" example:"1"`
+	Input   string   `json:"input" example:"11.222.333/0001-81"`
+	Status  string   `json:"status" example:"found"`
+	Error   string   `json:"error,omitempty"`
+	Company *Company `json:"company,omitempty"`
+
+	record []string
+	err    error
+}
+
+// batchInput is a CNPJ to look up and, for CSV input, the row it came in.
+type batchInput struct {
+	value  string
+	record []string
+}
+
+// batchSource reads the inputs of a batch one at a time, returning io.EOF
+// after the last. CSV sources keep their header and separator for the
+// results.
+type batchSource struct {
+	header []string
+	comma  rune
+	next   func() (batchInput, error)
+}
+
+// jsonSource reads a JSON array of CNPJs, as strings or numbers.
+func jsonSource(r io.Reader) (*batchSource, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array of CNPJs")
+	}
+	next := func() (batchInput, error) {
+		if !dec.More() {
+			return batchInput{}, io.EOF
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return batchInput{}, fmt.Errorf("decode cnpj list: %w", err)
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			s = string(raw)
+		}
+		return batchInput{value: s}, nil
+	}
+	return &batchSource{next: next}, nil
+}
+
+// csvSource reads the CNPJs of a CSV column, named in the header row.
+// Without a separator, the header decides between ',' and the ';' of
+// spreadsheets saved in Portuguese locales.
+func csvSource(r io.Reader, column string, comma rune) (*batchSource, error) {
+	if comma == 0 {
+		br := bufio.NewReader(r)
+		head, _ := br.Peek(br.Size())
+		if i := bytes.IndexByte(head, '\n'); i >= 0 {
+			head = head[:i]
+		}
+		comma = ','
+		if bytes.Count(head, []byte(";")) > bytes.Count(head, []byte(",")) {
+			comma = ';'
+		}
+		r = br
+	}
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	// spreadsheets often save UTF-8 with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	col := -1
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("column %q not found, the header has %s", column, strings.Join(header, ", "))
+	}
+	next := func() (batchInput, error) {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return batchInput{}, err
+		}
+		if err != nil {
+			return batchInput{}, fmt.Errorf("read csv: %w", err)
+		}
+		in := batchInput{record: record}
+		if col < len(record) {
+			in.value = record[col]
+		}
+		return in, nil
+	}
+	return &batchSource{header: header, comma: comma, next: next}, nil
+}
+
+// parseBatchSource picks the reader for the request body: a JSON array, a
+// CSV body or a CSV uploaded as the file field of a form. The column and
+// separator query parameters describe the CSV.
+func parseBatchSource(r *http.Request) (*batchSource, error) {
+	q := r.URL.Query()
+	column := q.Get("column")
+	if column == "" {
+		column = "cnpj"
+	}
+	var comma rune
+	if v := q.Get("separator"); v != "" {
+		c, size := utf8.DecodeRuneInString(v)
+		if size != len(v) || c == '"' || c == '\n' || c == '\r' {
+			return nil, fmt.Errorf("invalid separator %q", v)
+		}
+		comma = c
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		return jsonSource(r.Body)
+	case "text/csv":
+		return csvSource(r.Body, column, comma)
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, fmt.Errorf("read form: %w", err)
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, fmt.Errorf("no file field in the form")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read form: %w", err)
+			}
+			// the part is read as the rows are looked up, never buffered
+			if part.FormName() == "file" {
+				return csvSource(part, column, comma)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w, got %q", errUnsupportedBatch, mediaType)
+}
+
+// lookupOne classifies an input and looks it up. A CNPJ básico has no
+// check digits, so only its length is checked. A failed lookup is
+// reported on its row, unless the batch itself was cancelled.
+func lookupOne(ctx context.Context, store Store, row int, in batchInput) BatchResult {
+	res := BatchResult{Row: row, Input: in.value, record: in.record}
+	basico, cnpj, err := parseKey(in.value)
+	if err == nil && cnpj != "" && !validCheckDigits(cnpj) {
+		err = fmt.Errorf("invalid cnpj %q, wrong check digits", in.value)
+	}
+	if err != nil {
+		res.Status, res.Error = StatusInvalid, err.Error()
+		return res
+	}
+	c, err := store.Company(ctx, basico, cnpj)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		res.Status = StatusNotFound
+	case err != nil && ctx.Err() != nil:
+		res.err = err
+	case err != nil:
+		res.Status, res.Error = StatusError, err.Error()
+	default:
+		res.Status, res.Company = StatusFound, &c
+	}
+	return res
+}
+
+// lookupBatch looks the inputs up on up to workers goroutines and hands
+// the results to emit in input order. Reading stays at most workers rows
+// ahead of emit, so memory doesn't grow with the input. The first read
+// or emit error, or the context ending, stops the batch. The reader and
+// the lookups are done by the time it returns, so nothing reads the
+// request body after the handler.
+func lookupBatch(ctx context.Context, store Store, workers int, src *batchSource, emit func(BatchResult) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	workers = max(workers, 1)
+	pending := make(chan chan BatchResult, workers)
+	slots := make(chan struct{}, workers)
+	var readErr error
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		for row := 1; ctx.Err() == nil; row++ {
+			in, err := src.next()
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			done := make(chan BatchResult, 1)
+			select {
+			case pending <- done:
+			case <-ctx.Done():
+				<-slots
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				done <- lookupOne(ctx, store, row, in)
+			}()
+		}
+	}()
+
+	for done := range pending {
+		res := <-done
+		if res.err != nil {
+			return fmt.Errorf("look up row %d: %w", res.Row, res.err)
+		}
+		if err := emit(res); err != nil {
+			return err
+		}
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return ctx.Err()
+}
+
+// batchColumns are the enrichment columns of a CSV result.
+var batchColumns = []string{
+	"status", "error", "cnpj", "razao_social", "nome_fantasia", "matriz_filial",
+	"situacao_cadastral", "data_situacao", "data_inicio_atividade",
+	"natureza_juridica", "porte", "capital_social", "cnae_principal", "cnae_principal_descricao",
+	"cnaes_secundarios", "tipo_logradouro", "logradouro", "numero", "complemento", "bairro",
+	"cep", "municipio", "uf", "telefones", "email", "opcao_simples", "opcao_mei",
+}
+
+// csvRow flattens a result into batchColumns. A lookup by básico
+// describes the matriz.
+func (res BatchResult) csvRow() []string {
+	var c Company
+	var e Estabelecimento
+	var simples Simples
+	if res.Company != nil {
+		c = *res.Company
+		if m := mainEstabelecimento(c.Estabelecimentos); m != nil {
+			e = *m
+		}
+		if c.Simples != nil {
+			simples = *c.Simples
+		}
+	}
+	var cnae Code
+	if e.CNAEPrincipal != nil {
+		cnae = *e.CNAEPrincipal
+	}
+	cnaes := make([]string, len(e.CNAEsSecundarios))
+	for i, code := range e.CNAEsSecundarios {
+		cnaes[i] = code.Code
+	}
+	a := e.Endereco
+	return []string{
+		res.Status, res.Error, e.CNPJ, c.RazaoSocial, e.NomeFantasia, description(e.MatrizFilial),
+		description(e.SituacaoCadastral), e.DataSituacao, e.DataInicioAtividade,
+		codeText(c.NaturezaJuridica), description(c.Porte), c.CapitalSocial, cnae.Code, cnae.Description,
+		strings.Join(cnaes, "|"), a.TipoLogradouro, a.Logradouro, a.Numero, a.Complemento, a.Bairro,
+		a.CEP, description(a.Municipio), a.UF, strings.Join(e.Telefones, "|"), e.Email,
+		optante(simples.Simples.Optante), optante(simples.MEI.Optante),
+	}
+}
+
+func mainEstabelecimento(ests []Estabelecimento) *Estabelecimento {
+	for i, e := range ests {
+		if e.MatrizFilial != nil && e.MatrizFilial.Code == "1" {
+			return &ests[i]
+		}
+	}
+	if len(ests) > 0 {
+		return &ests[0]
+	}
+	return nil
+}
+
+func description(c *Code) string {
+	if c == nil {
+		return ""
+	}
+	if c.Description != "" {
+		return c.Description
+	}
+	return c.Code
+}
+
+func codeText(c *Code) string {
+	if c == nil {
+		return ""
+	}
+	return strings.TrimSpace(c.Code + " " + c.Description)
+}
+
+func optante(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	if *b {
+		return "S"
+	}
+	return "N"
+}
+
+// batchWriter streams results in one of the batch formats.
+type batchWriter struct {
+	write  func(BatchResult) error
+	flush  func() error
+	finish func() error
+}
+
+func newBatchWriter(w http.ResponseWriter, format string, src *batchSource) batchWriter {
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="cnpj-batch.csv"`)
+		cw := csv.NewWriter(w)
+		if src.comma != 0 {
+			cw.Comma = src.comma
+		}
+		header := src.header
+		// CSV input is echoed, cut or padded to its header; JSON input
+		// gets its row number and value
+		echo := func(res BatchResult) []string {
+			row := make([]string, len(header))
+			copy(row, res.record)
+			return row
+		}
+		if header == nil {
+			echo = func(res BatchResult) []string { return []string{strconv.Itoa(res.Row), res.Input} }
+			header = []string{"row", "input"}
+		}
+		_ = cw.Write(append(append([]string{}, header...), batchColumns...))
+		flush := func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return batchWriter{
+			write:  func(res BatchResult) error { return cw.Write(append(echo(res), res.csvRow()...)) },
+			flush:  flush,
+			finish: flush,
+		}
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		return batchWriter{
+			write:  func(res BatchResult) error { return enc.Encode(res) },
+			flush:  func() error { return nil },
+			finish: func() error { return nil },
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, "[")
+	enc := json.NewEncoder(w)
+	first := true
+	return batchWriter{
+		write: func(res BatchResult) error {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			return enc.Encode(res)
+		},
+		flush: func() error { return nil },
+		finish: func() error {
+			_, err := io.WriteString(w, "]\n")
+			return err
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeStore knows one company and fails the lookups of another.
+type fakeStore struct{}
+
+func (fakeStore) Company(ctx context.Context, basico, cnpj string) (Company, error) {
+	switch basico {
+	case "11222333":
+		return Company{CNPJBasico: basico}, nil
+	case "11444777":
+		return Company{}, errors.New("connection reset")
+	}
+	return Company{}, ErrNotFound
+}
+
+func (fakeStore) Search(ctx context.Context, q string, limit, offset int) (SearchPage, error) {
+	return SearchPage{}, nil
+}
+
+func TestLookupBatch(t *testing.T) {
+	src, err := jsonSource(strings.NewReader(`["11.222.333/0001-81", "11444777000161", "11222333000180", "00000000000191", "11222333"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = lookupBatch(context.Background(), fakeStore{}, 2, src, func(res BatchResult) error {
+		got = append(got, res.Status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("lookupBatch() error = %v", err)
+	}
+	want := []string{StatusFound, StatusError, StatusInvalid, StatusNotFound, StatusFound}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestLookupBatchStopsReading(t *testing.T) {
+	var reading, reads atomic.Int32
+	src := &batchSource{next: func() (batchInput, error) {
+		reading.Add(1)
+		defer reading.Add(-1)
+		reads.Add(1)
+		time.Sleep(time.Millisecond)
+		return batchInput{value: "11222333"}, nil
+	}}
+	emitErr := errors.New("client went away")
+	err := lookupBatch(context.Background(), fakeStore{}, 4, src, func(res BatchResult) error {
+		if res.Row == 3 {
+			return emitErr
+		}
+		return nil
+	})
+	if !errors.Is(err, emitErr) {
+		t.Fatalf("lookupBatch() error = %v, want %v", err, emitErr)
+	}
+	if n := reading.Load(); n != 0 {
+		t.Fatalf("%d reads still running after lookupBatch returned", n)
+	}
+	n := reads.Load()
+	time.Sleep(10 * time.Millisecond)
+	if reads.Load() != n {
+		t.Errorf("source read after lookupBatch returned")
+	}
+}
@@ -0,0 +1,54 @@
+package lookup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseCNPJ accepts a CNPJ with or without punctuation and returns its 14
+// digits.
+func parseCNPJ(s string) (string, error) {
+	digits := strings.NewReplacer(".", "", "/", "", "-", "", " ", "").Replace(s)
+	if len(digits) != 14 || strings.Trim(digits, "0123456789") != "" {
+		return "", fmt.Errorf("invalid cnpj %q, expected 14 digits", s)
+	}
+	return digits, nil
+}
+
+// parseKey accepts a full CNPJ or a CNPJ básico, with or without
+// punctuation, and returns the básico and, for a full CNPJ, its 14 digits.
+func parseKey(s string) (basico, cnpj string, err error) {
+	digits := strings.NewReplacer(".", "", "/", "", "-", "", " ", "").Replace(s)
+	if strings.Trim(digits, "0123456789") != "" || (len(digits) != 14 && len(digits) != 8) {
+		return "", "", fmt.Errorf("invalid cnpj %q, expected 14 digits or an 8-digit básico", s)
+	}
+	if len(digits) == 8 {
+		return digits, "", nil
+	}
+	return digits[:8], digits, nil
+}
+
+// validCheckDigits verifies the two check digits of a 14-digit CNPJ.
+// Repeated digits pass the arithmetic but are never issued.
+func validCheckDigits(cnpj string) bool {
+	if strings.Count(cnpj, cnpj[:1]) == len(cnpj) {
+		return false
+	}
+	return checkDigit(cnpj[:12]) == cnpj[12] && checkDigit(cnpj[:13]) == cnpj[13]
+}
+
+// checkDigit is the modulo 11 digit of digits, weighted 2 to 9 from the
+// right.
+func checkDigit(digits string) byte {
+	sum, weight := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		if weight++; weight > 9 {
+			weight = 2
+		}
+	}
+	if r := sum % 11; r >= 2 {
+		return byte('0' + 11 - r)
+	}
+	return '0'
+}
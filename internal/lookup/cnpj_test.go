@@ -0,0 +1,77 @@
+package lookup
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		in      string
+		basico  string
+		cnpj    string
+		wantErr bool
+	}{
+		{in: "11222333000181", basico: "11222333", cnpj: "11222333000181"},
+		{in: "11.222.333/0001-81", basico: "11222333", cnpj: "11222333000181"},
+		{in: " 11 222 333 0001 81 ", basico: "11222333", cnpj: "11222333000181"},
+		{in: "11222333", basico: "11222333"},
+		{in: "11.222.333", basico: "11222333"},
+		{in: "", wantErr: true},
+		{in: "1122233300018", wantErr: true},
+		{in: "112223330001811", wantErr: true},
+		{in: "1122233", wantErr: true},
+		{in: "11222333000A81", wantErr: true},
+		{in: "11_222_333", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			basico, cnpj, err := parseKey(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKey(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if basico != tt.basico || cnpj != tt.cnpj {
+				t.Errorf("parseKey(%q) = %q, %q, want %q, %q", tt.in, basico, cnpj, tt.basico, tt.cnpj)
+			}
+		})
+	}
+}
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   byte
+	}{
+		{"112223330001", '8'},
+		{"1122233300018", '1'},
+		{"114447770001", '6'},
+		{"1144477700016", '1'},
+		// remainders under 2 give 0
+		{"000000000001", '9'},
+		{"000000000000", '0'},
+	}
+	for _, tt := range tests {
+		if got := checkDigit(tt.digits); got != tt.want {
+			t.Errorf("checkDigit(%q) = %c, want %c", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestValidCheckDigits(t *testing.T) {
+	tests := []struct {
+		cnpj string
+		want bool
+	}{
+		{"11222333000181", true},
+		{"11444777000161", true},
+		{"00000000000191", true},
+		{"11222333000180", false},
+		{"11222333000191", false},
+		{"11444777000116", false},
+		// repeated digits pass the arithmetic but are never issued
+		{"00000000000000", false},
+		{"11111111111111", false},
+	}
+	for _, tt := range tests {
+		if got := validCheckDigits(tt.cnpj); got != tt.want {
+			t.Errorf("validCheckDigits(%q) = %v, want %v", tt.cnpj, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+)
+
+// ErrNotFound is returned by a Store when the CNPJ is unknown.
+var ErrNotFound = errors.New("cnpj not found")
+
+// maxEstabelecimentos caps the estabelecimentos returned with a company,
+// the same limit the Mongo companies collection embeds.
+const maxEstabelecimentos = 5000
+
+// Code is a coded value with its description, when one is known.
+type Code struct {
+	Code        string `json:"code" example:"2062"`
+	Description string `json:"description,omitempty" example:"Sociedade Empresária Limitada"`
+}
+
+type Endereco struct {
+	TipoLogradouro string `json:"tipo_logradouro,omitempty" example:"RUA"`
+	Logradouro     string `json:"logradouro,omitempty" example:"XV DE NOVEMBRO"`
+	Numero         string `json:"numero,omitempty" example:"100"`
+	Complemento    string `json:"complemento,omitempty"`
+	Bairro         string `json:"bairro,omitempty" example:"CENTRO"`
+	CEP            string `json:"cep,omitempty" example:"80020310"`
+	UF             string `json:"uf,omitempty" example:"PR"`
+	Municipio      *Code  `json:"municipio,omitempty"`
+	CidadeExterior string `json:"cidade_exterior,omitempty"`
+	Pais           *Code  `json:"pais,omitempty"`
+}
+
+type Estabelecimento struct {
+	CNPJ                 string   `json:"cnpj" example:"11222333000181"`
+	MatrizFilial         *Code    `json:"matriz_filial,omitempty"`
+	NomeFantasia         string   `json:"nome_fantasia,omitempty"`
+	SituacaoCadastral    *Code    `json:"situacao_cadastral,omitempty"`
+	DataSituacao         string   `json:"data_situacao,omitempty" example:"2005-11-03"`
+	MotivoSituacao       *Code    `json:"motivo_situacao,omitempty"`
+	DataInicioAtividade  string   `json:"data_inicio_atividade,omitempty" example:"2005-11-03"`
+	CNAEPrincipal        *Code    `json:"cnae_principal,omitempty"`
+	CNAEsSecundarios     []Code   `json:"cnaes_secundarios"`
+	Endereco             Endereco `json:"endereco"`
+	Telefones            []string `json:"telefones"`
+	Fax                  string   `json:"fax,omitempty"`
+	Email                string   `json:"email,omitempty"`
+	SituacaoEspecial     string   `json:"situacao_especial,omitempty"`
+	DataSituacaoEspecial string   `json:"data_situacao_especial,omitempty"`
+}
+
+type Representante struct {
+	CPF          string `json:"cpf,omitempty"`
+	Nome         string `json:"nome,omitempty"`
+	Qualificacao *Code  `json:"qualificacao,omitempty"`
+}
+
+type Socio struct {
+	Identificador *Code          `json:"identificador,omitempty"`
+	Nome          string         `json:"nome,omitempty"`
+	Documento     string         `json:"documento,omitempty" example:"***123456**"`
+	Qualificacao  *Code          `json:"qualificacao,omitempty"`
+	DataEntrada   string         `json:"data_entrada,omitempty" example:"2005-11-03"`
+	Pais          *Code          `json:"pais,omitempty"`
+	FaixaEtaria   *Code          `json:"faixa_etaria,omitempty"`
+	Representante *Representante `json:"representante,omitempty"`
+}
+
+// Option is a Simples Nacional or MEI option and its period.
+type Option struct {
+	Optante  *bool  `json:"optante"`
+	Opcao    string `json:"data_opcao,omitempty" example:"2007-07-01"`
+	Exclusao string `json:"data_exclusao,omitempty"`
+}
+
+type Simples struct {
+	Simples Option `json:"simples"`
+	MEI     Option `json:"mei"`
+}
+
+type Regime struct {
+	CNPJ                      string  `json:"cnpj" example:"11222333000181"`
+	CNPJDaSCP                 *string `json:"cnpj_da_scp,omitempty"`
+	FormaDeTributacao         string  `json:"forma_de_tributacao" example:"LUCRO PRESUMIDO"`
+	QuantidadeDeEscrituracoes int     `json:"quantidade_de_escrituracoes" example:"1"`
+	Dataset                   string  `json:"dataset" example:"Lucro Presumido"`
+}
+
+// RegimeYear groups the tax regimes declared for a year.
+type RegimeYear struct {
+	Ano     int      `json:"ano" example:"2024"`
+	Regimes []Regime `json:"regimes"`
+}
+
+// Company is everything known about a CNPJ básico. Looked up by a full
+// CNPJ, Estabelecimentos and Regimes only hold that estabelecimento's.
+// TotalEstabelecimentos counts them all, even past the returned limit.
+type Company struct {
+	CNPJBasico              string            `json:"cnpj_basico" example:"11222333"`
+	RazaoSocial             string            `json:"razao_social,omitempty" example:"EMPRESA EXEMPLO LTDA"`
+	NaturezaJuridica        *Code             `json:"natureza_juridica,omitempty"`
+	QualificacaoResponsavel *Code             `json:"qualificacao_responsavel,omitempty"`
+	CapitalSocial           string            `json:"capital_social,omitempty" example:"100000.00"`
+	Porte                   *Code             `json:"porte,omitempty"`
+	EnteFederativo          string            `json:"ente_federativo,omitempty"`
+	Estabelecimentos        []Estabelecimento `json:"estabelecimentos"`
+	TotalEstabelecimentos   int               `json:"total_estabelecimentos"`
+	Truncated               bool              `json:"estabelecimentos_truncados,omitempty"`
+	Socios                  []Socio           `json:"socios"`
+	Simples                 *Simples          `json:"simples,omitempty"`
+	Regimes                 []RegimeYear      `json:"regimes"`
+	Source                  string            `json:"source" example:"postgres"`
+}
+
+// Store reads companies from one of the databases the data is imported
+// into. cnpj is empty for a lookup by básico. Search pages through the
+// companies matching a name, best first.
+type Store interface {
+	Company(ctx context.Context, basico, cnpj string) (Company, error)
+	Search(ctx context.Context, q string, limit, offset int) (SearchPage, error)
+}
+
+// NewStore returns the store named by LOOKUP_STORE, postgres or mongo.
+func NewStore(name string, pg postgres.DB, db *mongo.Database) (Store, error) {
+	switch name {
+	case "postgres":
+		return NewPostgresStore(pg), nil
+	case "mongo":
+		return NewMongoStore(db, pg), nil
+	}
+	return nil, fmt.Errorf("unknown lookup store %q, expected postgres or mongo", name)
+}
+
+// Receita domains that have no dictionary file.
+var domains = map[string]map[int]string{
+	"porte":         {0: "Não informado", 1: "Micro empresa", 3: "Empresa de pequeno porte", 5: "Demais"},
+	"matriz_filial": {1: "Matriz", 2: "Filial"},
+	"situacao":      {1: "Nula", 2: "Ativa", 3: "Suspensa", 4: "Inapta", 8: "Baixada"},
+	"identificador": {1: "Pessoa jurídica", 2: "Pessoa física", 3: "Estrangeiro"},
+	"faixa_etaria": {
+		0: "Não se aplica", 1: "0 a 12 anos", 2: "13 a 20 anos", 3: "21 a 30 anos", 4: "31 a 40 anos",
+		5: "41 a 50 anos", 6: "51 a 60 anos", 7: "61 a 70 anos", 8: "71 a 80 anos", 9: "Maiores de 80 anos",
+	},
+}
+
+// domain decodes a code of a Receita domain; nil codes stay nil.
+func domain(name string, code *int) *Code {
+	if code == nil {
+		return nil
+	}
+	return &Code{Code: strconv.Itoa(*code), Description: domains[name][*code]}
+}
+
+// dictCode pairs a dictionary code with its description; empty codes are nil.
+func dictCode(code, description *string) *Code {
+	if code == nil || *code == "" {
+		return nil
+	}
+	c := &Code{Code: *code}
+	if description != nil {
+		c.Description = *description
+	}
+	return c
+}
+
+// phones joins DDD and number pairs, skipping empty numbers.
+func phones(pairs ...string) []string {
+	out := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			out = append(out, joinPhone(pairs[i], pairs[i+1]))
+		}
+	}
+	return out
+}
+
+func joinPhone(ddd, number string) string {
+	return strings.TrimSpace(ddd + " " + number)
+}
+
+// noRepresentante is the CPF Receita writes when a sócio has no legal
+// representative.
+const noRepresentante = "***000000**"
+
+func representante(r Representante) *Representante {
+	if r.CPF == noRepresentante {
+		r.CPF = ""
+	}
+	if r.CPF == "" && r.Nome == "" {
+		return nil
+	}
+	return &r
+}
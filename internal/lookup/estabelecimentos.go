@@ -0,0 +1,334 @@
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+	// CNAE subclasses have 7 digits; shorter codes are divisions, groups
+	// and classes, which prefix their subclasses
+	cnaeDigits = 7
+)
+
+const (
+	CNAEPrincipal  = "principal"
+	CNAESecundario = "secundario"
+	CNAEAny        = "any"
+)
+
+// EstabelecimentoFilter selects estabelecimentos; empty fields don't
+// filter, and the values of a field are alternatives. CNAE holds codes or
+// prefixes of codes, matched against the fields named by CNAEField.
+// Cursor is the last CNPJ of the previous page.
+type EstabelecimentoFilter struct {
+	UF               []string
+	Municipio        []string
+	CNAE             []string
+	CNAEField        string
+	Situacao         []int
+	Porte            []int
+	NaturezaJuridica []string
+	MatrizFilial     []int
+	InicioFrom       *time.Time
+	InicioTo         *time.Time
+	Cursor           string
+	Limit            uint64
+}
+
+// ListedEstabelecimento is an estabelecimento with the company fields it
+// can be filtered by.
+type ListedEstabelecimento struct {
+	CNPJBasico       string `json:"cnpj_basico" example:"11222333"`
+	RazaoSocial      string `json:"razao_social,omitempty" example:"EMPRESA EXEMPLO LTDA"`
+	NaturezaJuridica *Code  `json:"natureza_juridica,omitempty"`
+	Porte            *Code  `json:"porte,omitempty"`
+	Estabelecimento
+}
+
+// EstabelecimentoPage is a page of estabelecimentos in CNPJ order.
+// NextCursor is empty on the last page. TotalEstimate is the planner's
+// estimate of the matches, which can be far off for selective filters.
+type EstabelecimentoPage struct {
+	Estabelecimentos []ListedEstabelecimento `json:"estabelecimentos"`
+	TotalEstimate    int64                   `json:"total_estimate" example:"15230"`
+	NextCursor       string                  `json:"next_cursor,omitempty" example:"11222333000181"`
+}
+
+// values splits a comma-separated parameter, which may also be repeated.
+func values(r *http.Request, name string) []string {
+	var out []string
+	for _, v := range r.URL.Query()[name] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func digitsOnly(name string, codes []string) error {
+	for _, c := range codes {
+		if strings.Trim(c, "0123456789") != "" {
+			return fmt.Errorf("invalid %s %q, expected digits only", name, c)
+		}
+	}
+	return nil
+}
+
+// domainCodes parses the codes of a Receita domain, rejecting unknown ones.
+func domainCodes(r *http.Request, param, name string) ([]int, error) {
+	var out []int
+	for _, v := range values(r, param) {
+		n, err := strconv.Atoi(v)
+		if _, ok := domains[name][n]; err != nil || !ok {
+			return nil, fmt.Errorf("invalid %s %q", param, v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func parseDate(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD", name, v)
+	}
+	return &t, nil
+}
+
+// parseEstabelecimentoFilter reads the filters and paging of the listing.
+func parseEstabelecimentoFilter(r *http.Request) (EstabelecimentoFilter, error) {
+	q := r.URL.Query()
+	f := EstabelecimentoFilter{
+		Municipio:        values(r, "municipio"),
+		CNAE:             values(r, "cnae"),
+		CNAEField:        q.Get("cnae_field"),
+		NaturezaJuridica: values(r, "natureza_juridica"),
+		Cursor:           q.Get("cursor"),
+		Limit:            defaultListLimit,
+	}
+	for _, uf := range values(r, "uf") {
+		if len(uf) != 2 {
+			return f, fmt.Errorf("invalid uf %q", uf)
+		}
+		f.UF = append(f.UF, strings.ToUpper(uf))
+	}
+	if err := digitsOnly("municipio", f.Municipio); err != nil {
+		return f, err
+	}
+	if err := digitsOnly("natureza_juridica", f.NaturezaJuridica); err != nil {
+		return f, err
+	}
+	if err := digitsOnly("cnae", f.CNAE); err != nil {
+		return f, err
+	}
+	for _, c := range f.CNAE {
+		if len(c) < 2 || len(c) > cnaeDigits {
+			return f, fmt.Errorf("invalid cnae %q, expected 2 to %d digits", c, cnaeDigits)
+		}
+	}
+	if f.CNAEField == "" {
+		f.CNAEField = CNAEPrincipal
+	}
+	if !slices.Contains([]string{CNAEPrincipal, CNAESecundario, CNAEAny}, f.CNAEField) {
+		return f, fmt.Errorf("invalid cnae_field %q, expected principal, secundario or any", f.CNAEField)
+	}
+
+	var err error
+	if f.Situacao, err = domainCodes(r, "situacao", "situacao"); err != nil {
+		return f, err
+	}
+	if f.Porte, err = domainCodes(r, "porte", "porte"); err != nil {
+		return f, err
+	}
+	if f.MatrizFilial, err = domainCodes(r, "matriz_filial", "matriz_filial"); err != nil {
+		return f, err
+	}
+	if f.InicioFrom, err = parseDate(r, "inicio_from"); err != nil {
+		return f, err
+	}
+	if f.InicioTo, err = parseDate(r, "inicio_to"); err != nil {
+		return f, err
+	}
+	if f.Cursor != "" {
+		if f.Cursor, err = parseCNPJ(f.Cursor); err != nil {
+			return f, fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 || n > maxListLimit {
+			return f, fmt.Errorf("invalid limit %q", v)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+// cnaeRange bounds the subclasses under a CNAE code or prefix.
+func cnaeRange(code string) (string, string) {
+	pad := cnaeDigits - len(code)
+	return code + strings.Repeat("0", pad), code + strings.Repeat("9", pad)
+}
+
+// cnaeWhere matches the CNAE codes against the chosen fields. Full
+// secondary codes use the array index; prefixes have to unnest the array.
+func cnaeWhere(f EstabelecimentoFilter) sq.Sqlizer {
+	or := sq.Or{}
+	var full []string
+	for _, code := range f.CNAE {
+		lo, hi := cnaeRange(code)
+		if f.CNAEField != CNAESecundario {
+			or = append(or, sq.Expr("s.cnae_principal BETWEEN ? AND ?", lo, hi))
+		}
+		if f.CNAEField == CNAEPrincipal {
+			continue
+		}
+		if len(code) == cnaeDigits {
+			full = append(full, code)
+		} else {
+			or = append(or, sq.Expr("EXISTS (SELECT 1 FROM unnest(s.cnaes_secundarios) c WHERE c BETWEEN ? AND ?)", lo, hi))
+		}
+	}
+	if len(full) > 0 {
+		or = append(or, sq.Expr("s.cnaes_secundarios && ?", full))
+	}
+	return or
+}
+
+// where builds the filter conditions, without the cursor.
+func (f EstabelecimentoFilter) where() sq.And {
+	and := sq.And{}
+	if len(f.UF) > 0 {
+		and = append(and, sq.Eq{"s.uf": f.UF})
+	}
+	if len(f.Municipio) > 0 {
+		and = append(and, sq.Expr("s.municipio IN (SELECT code FROM dictionaries.municipios WHERE ltrim(code, '0') = ANY(?))", trimZeros(f.Municipio)))
+	}
+	if len(f.CNAE) > 0 {
+		and = append(and, cnaeWhere(f))
+	}
+	if len(f.Situacao) > 0 {
+		and = append(and, sq.Eq{"s.situacao_cadastral": f.Situacao})
+	}
+	if len(f.MatrizFilial) > 0 {
+		and = append(and, sq.Eq{"s.matriz_filial": f.MatrizFilial})
+	}
+	if f.InicioFrom != nil {
+		and = append(and, sq.GtOrEq{"s.data_inicio_atividade": *f.InicioFrom})
+	}
+	if f.InicioTo != nil {
+		and = append(and, sq.LtOrEq{"s.data_inicio_atividade": *f.InicioTo})
+	}
+	if len(f.Porte) > 0 {
+		and = append(and, sq.Eq{"e.porte_empresa": f.Porte})
+	}
+	if len(f.NaturezaJuridica) > 0 {
+		and = append(and, sq.Expr("e.natureza_juridica IN (SELECT code FROM dictionaries.naturezas WHERE ltrim(code, '0') = ANY(?))", trimZeros(f.NaturezaJuridica)))
+	}
+	return and
+}
+
+// trimZeros drops leading zeros, so codes match however they are padded.
+func trimZeros(codes []string) []string {
+	out := make([]string, len(codes))
+	for i, c := range codes {
+		out[i] = strings.TrimLeft(c, "0")
+	}
+	return out
+}
+
+// ListEstabelecimentos returns a page of the estabelecimentos matching f
+// in CNPJ order, which keeps the cursor stable across reloads that don't
+// touch the rows already seen.
+func (s *PostgresStore) ListEstabelecimentos(ctx context.Context, f EstabelecimentoFilter) (EstabelecimentoPage, error) {
+	page := EstabelecimentoPage{Estabelecimentos: []ListedEstabelecimento{}}
+	where := f.where()
+
+	q := s.estabelecimentoSelect("s.cnpj_basico", "COALESCE(e.razao_social, '')", "e.natureza_juridica", "n.description", "e.porte_empresa").
+		LeftJoin("cnpj.empresas e ON e.cnpj_basico = s.cnpj_basico").
+		LeftJoin("dictionaries.naturezas n ON n.code = e.natureza_juridica").
+		Where(where).
+		OrderBy("s.cnpj").
+		Limit(f.Limit + 1)
+	if f.Cursor != "" {
+		q = q.Where(sq.Gt{"s.cnpj": f.Cursor})
+	}
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return page, err
+	}
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return page, fmt.Errorf("query estabelecimentos: %w", err)
+	}
+	list, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ListedEstabelecimento, error) {
+		var l ListedEstabelecimento
+		var natureza, naturezaDesc *string
+		var porte *int
+		var err error
+		l.Estabelecimento, err = scanEstabelecimento(row, &l.CNPJBasico, &l.RazaoSocial, &natureza, &naturezaDesc, &porte)
+		l.NaturezaJuridica = dictCode(natureza, naturezaDesc)
+		l.Porte = domain("porte", porte)
+		return l, err
+	})
+	if err != nil {
+		return page, fmt.Errorf("query estabelecimentos: %w", err)
+	}
+	if uint64(len(list)) > f.Limit {
+		list = list[:f.Limit]
+		page.NextCursor = list[len(list)-1].CNPJ
+	}
+	page.Estabelecimentos = list
+
+	if page.TotalEstimate, err = s.estimate(ctx, f, where); err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// estimate asks the planner how many rows match, which is instant where
+// counting could scan most of the table.
+func (s *PostgresStore) estimate(ctx context.Context, f EstabelecimentoFilter, where sq.And) (int64, error) {
+	q := s.psql.Select("1").From("cnpj.estabelecimentos s").Where(where)
+	if len(f.Porte) > 0 || len(f.NaturezaJuridica) > 0 {
+		q = q.Join("cnpj.empresas e ON e.cnpj_basico = s.cnpj_basico")
+	}
+	sql, args, err := q.Prefix("EXPLAIN (FORMAT JSON)").ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var out []byte
+	if err := s.conn.QueryRow(ctx, sql, args...).Scan(&out); err != nil {
+		return 0, fmt.Errorf("estimate estabelecimentos: %w", err)
+	}
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(out, &plans); err != nil {
+		return 0, fmt.Errorf("decode estimate plan: %w", err)
+	}
+	if len(plans) == 0 {
+		return 0, fmt.Errorf("estimate estabelecimentos: empty plan")
+	}
+	return int64(plans[0].Plan.Rows), nil
+}
@@ -0,0 +1,196 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	mongoimport "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/mongo"
+	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+)
+
+// MongoStore reads companies from the companies collection, which embeds
+// the estabelecimentos, sócios, Simples options and code descriptions.
+// Tax regimes are only imported into Postgres and come from pg.
+type MongoStore struct {
+	companies *mongo.Collection
+	pg        postgres.DB
+}
+
+func NewMongoStore(db *mongo.Database, pg postgres.DB) *MongoStore {
+	return &MongoStore{companies: db.Collection(mongoimport.CompaniesCollection), pg: pg}
+}
+
+func (s *MongoStore) Company(ctx context.Context, basico, cnpj string) (Company, error) {
+	c := Company{CNPJBasico: basico, Source: "mongo"}
+	var doc bson.M
+	err := s.companies.FindOne(ctx, bson.M{"_id": basico}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c, ErrNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("find company: %w", err)
+	}
+
+	c.RazaoSocial = str(doc, "razao_social")
+	c.NaturezaJuridica = docCode(doc, "natureza_juridica")
+	c.QualificacaoResponsavel = docCode(doc, "qualificacao_resp")
+	if v, ok := doc["capital_social"].(primitive.Decimal128); ok {
+		c.CapitalSocial = v.String()
+	}
+	c.Porte = domain("porte", integer(doc, "porte_empresa"))
+	c.EnteFederativo = str(doc, "ente_federativo_resp")
+
+	c.Estabelecimentos = []Estabelecimento{}
+	ests, _ := doc["estabelecimentos"].(bson.A)
+	for _, v := range ests {
+		e, ok := v.(bson.M)
+		if !ok || cnpj != "" && str(e, "cnpj") != cnpj {
+			continue
+		}
+		c.Estabelecimentos = append(c.Estabelecimentos, estabelecimento(e))
+	}
+	if cnpj != "" && len(c.Estabelecimentos) == 0 {
+		return c, ErrNotFound
+	}
+	c.TotalEstabelecimentos = len(c.Estabelecimentos)
+	if cnpj == "" {
+		if total := integer(doc, "total_estabelecimentos"); total != nil {
+			c.TotalEstabelecimentos = *total
+		}
+		c.Truncated, _ = doc["estabelecimentos_truncados"].(bool)
+	}
+
+	c.Socios = []Socio{}
+	socios, _ := doc["socios"].(bson.A)
+	for _, v := range socios {
+		if so, ok := v.(bson.M); ok {
+			c.Socios = append(c.Socios, socio(so))
+		}
+	}
+	if o, ok := doc["simples"].(bson.M); ok {
+		c.Simples = &Simples{
+			Simples: Option{Optante: flag(o, "opcao_simples"), Opcao: date(o, "data_opcao_simples"), Exclusao: date(o, "data_exclusao_simples")},
+			MEI:     Option{Optante: flag(o, "opcao_mei"), Opcao: date(o, "data_opcao_mei"), Exclusao: date(o, "data_exclusao_mei")},
+		}
+	}
+
+	if c.Regimes, err = regimes(ctx, s.pg, basico, cnpj); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func estabelecimento(d bson.M) Estabelecimento {
+	e := Estabelecimento{
+		CNPJ:                 str(d, "cnpj"),
+		MatrizFilial:         domain("matriz_filial", integer(d, "matriz_filial")),
+		NomeFantasia:         str(d, "nome_fantasia"),
+		SituacaoCadastral:    domain("situacao", integer(d, "situacao_cadastral")),
+		DataSituacao:         date(d, "data_situacao"),
+		MotivoSituacao:       docCode(d, "motivo_situacao"),
+		DataInicioAtividade:  date(d, "data_inicio_atividade"),
+		CNAEPrincipal:        docCode(d, "cnae_principal"),
+		CNAEsSecundarios:     []Code{},
+		Telefones:            phones(str(d, "ddd1"), str(d, "telefone1"), str(d, "ddd2"), str(d, "telefone2")),
+		Email:                str(d, "email"),
+		SituacaoEspecial:     str(d, "situacao_especial"),
+		DataSituacaoEspecial: date(d, "data_situacao_especial"),
+		Endereco: Endereco{
+			TipoLogradouro: str(d, "tipo_logradouro"),
+			Logradouro:     str(d, "logradouro"),
+			Numero:         str(d, "numero"),
+			Complemento:    str(d, "complemento"),
+			Bairro:         str(d, "bairro"),
+			CEP:            str(d, "cep"),
+			UF:             str(d, "uf"),
+			Municipio:      docCode(d, "municipio"),
+			CidadeExterior: str(d, "nome_cidade_exterior"),
+			Pais:           docCode(d, "pais"),
+		},
+	}
+	if fax := str(d, "fax"); fax != "" {
+		e.Fax = joinPhone(str(d, "ddd_fax"), fax)
+	}
+	codes, _ := d["cnaes_secundarios"].(bson.A)
+	descs, _ := d["cnaes_secundarios_descricao"].(bson.A)
+	for i, v := range codes {
+		code := Code{Code: fmt.Sprint(v)}
+		if i < len(descs) {
+			code.Description, _ = descs[i].(string)
+		}
+		e.CNAEsSecundarios = append(e.CNAEsSecundarios, code)
+	}
+	return e
+}
+
+func socio(d bson.M) Socio {
+	return Socio{
+		Identificador: domain("identificador", integer(d, "identificador_socio")),
+		Nome:          str(d, "nome_socio"),
+		Documento:     str(d, "cnpj_cpf_socio"),
+		Qualificacao:  docCode(d, "qualificacao_socio"),
+		DataEntrada:   date(d, "data_entrada_sociedade"),
+		Pais:          docCode(d, "pais"),
+		FaixaEtaria:   domain("faixa_etaria", integer(d, "faixa_etaria")),
+		Representante: representante(Representante{
+			CPF:          str(d, "cpf_representante_legal"),
+			Nome:         str(d, "nome_representante_legal"),
+			Qualificacao: docCode(d, "qualificacao_representante"),
+		}),
+	}
+}
+
+func str(d bson.M, key string) string {
+	s, _ := d[key].(string)
+	return s
+}
+
+func integer(d bson.M, key string) *int {
+	var n int
+	switch v := d[key].(type) {
+	case int32:
+		n = int(v)
+	case int64:
+		n = int(v)
+	case int:
+		n = v
+	default:
+		return nil
+	}
+	return &n
+}
+
+func flag(d bson.M, key string) *bool {
+	if b, ok := d[key].(bool); ok {
+		return &b
+	}
+	return nil
+}
+
+func date(d bson.M, key string) string {
+	switch v := d[key].(type) {
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.DateOnly)
+	case time.Time:
+		return v.UTC().Format(time.DateOnly)
+	}
+	return ""
+}
+
+// docCode reads a code field and the <field>_descricao the companies build
+// stores next to it.
+func docCode(d bson.M, key string) *Code {
+	code := str(d, key)
+	if n := integer(d, key); n != nil {
+		code = strconv.Itoa(*n)
+	}
+	desc := str(d, key+"_descricao")
+	return dictCode(&code, &desc)
+}
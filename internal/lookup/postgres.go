@@ -0,0 +1,270 @@
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+
+	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+)
+
+// PostgresStore reads companies from the cnpj schema, decoding codes
+// through the dictionary tables.
+type PostgresStore struct {
+	conn postgres.DB
+	psql sq.StatementBuilderType
+}
+
+func NewPostgresStore(conn postgres.DB) *PostgresStore {
+	return &PostgresStore{
+		conn: conn,
+		psql: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+func (s *PostgresStore) Company(ctx context.Context, basico, cnpj string) (Company, error) {
+	c, found, err := s.empresa(ctx, basico)
+	if err != nil {
+		return c, err
+	}
+	if c.Estabelecimentos, err = s.estabelecimentos(ctx, basico, cnpj); err != nil {
+		return c, err
+	}
+	if len(c.Estabelecimentos) == 0 && (!found || cnpj != "") {
+		return c, ErrNotFound
+	}
+	c.TotalEstabelecimentos = len(c.Estabelecimentos)
+	if cnpj == "" && len(c.Estabelecimentos) == maxEstabelecimentos {
+		if err := s.conn.QueryRow(ctx, "SELECT count(*) FROM cnpj.estabelecimentos WHERE cnpj_basico = $1", basico).Scan(&c.TotalEstabelecimentos); err != nil {
+			return c, fmt.Errorf("count estabelecimentos: %w", err)
+		}
+		c.Truncated = c.TotalEstabelecimentos > maxEstabelecimentos
+	}
+	if c.Socios, err = s.socios(ctx, basico); err != nil {
+		return c, err
+	}
+	if c.Simples, err = s.simples(ctx, basico); err != nil {
+		return c, err
+	}
+	if c.Regimes, err = regimes(ctx, s.conn, basico, cnpj); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// empresa reads the empresa row; a company known only by its
+// estabelecimentos comes back with just the básico and found unset.
+func (s *PostgresStore) empresa(ctx context.Context, basico string) (c Company, found bool, err error) {
+	c = Company{CNPJBasico: basico, Source: "postgres"}
+	sql, args, err := s.psql.Select(
+		"COALESCE(e.razao_social, '')", "e.natureza_juridica", "n.description",
+		"e.qualificacao_resp", "q.description", "COALESCE(e.capital_social::text, '')",
+		"e.porte_empresa", "COALESCE(e.ente_federativo_resp, '')",
+	).
+		From("cnpj.empresas e").
+		LeftJoin("dictionaries.naturezas n ON n.code = e.natureza_juridica").
+		LeftJoin("dictionaries.qualificacoes q ON q.code = e.qualificacao_resp").
+		Where(sq.Eq{"e.cnpj_basico": basico}).
+		ToSql()
+	if err != nil {
+		return c, false, err
+	}
+	var natureza, naturezaDesc, qualificacao, qualificacaoDesc *string
+	var porte *int
+	err = s.conn.QueryRow(ctx, sql, args...).Scan(&c.RazaoSocial, &natureza, &naturezaDesc,
+		&qualificacao, &qualificacaoDesc, &c.CapitalSocial, &porte, &c.EnteFederativo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, false, nil
+	}
+	if err != nil {
+		return c, false, fmt.Errorf("query empresa: %w", err)
+	}
+	c.NaturezaJuridica = dictCode(natureza, naturezaDesc)
+	c.QualificacaoResponsavel = dictCode(qualificacao, qualificacaoDesc)
+	c.Porte = domain("porte", porte)
+	return c, true, nil
+}
+
+// estabelecimentoSelect selects what scanEstabelecimento reads from
+// cnpj.estabelecimentos s, decoded through the dictionaries, followed by
+// the extra columns.
+func (s *PostgresStore) estabelecimentoSelect(extra ...string) sq.SelectBuilder {
+	columns := []string{
+		"s.cnpj", "s.matriz_filial", "COALESCE(s.nome_fantasia, '')",
+		"s.situacao_cadastral", "COALESCE(to_char(s.data_situacao, 'YYYY-MM-DD'), '')",
+		"s.motivo_situacao", "mo.description",
+		"COALESCE(to_char(s.data_inicio_atividade, 'YYYY-MM-DD'), '')",
+		"s.cnae_principal", "cn.description",
+		`(SELECT COALESCE(jsonb_agg(jsonb_build_object('code', u.code, 'description', d.description) ORDER BY u.n), '[]')
+			FROM unnest(s.cnaes_secundarios) WITH ORDINALITY AS u(code, n)
+			LEFT JOIN dictionaries.cnaes d ON ltrim(d.code, '0') = ltrim(u.code, '0'))`,
+		"COALESCE(s.tipo_logradouro, '')", "COALESCE(s.logradouro, '')", "COALESCE(s.numero, '')",
+		"COALESCE(s.complemento, '')", "COALESCE(s.bairro, '')", "COALESCE(s.cep, '')", "COALESCE(s.uf, '')",
+		"s.municipio", "mu.description", "COALESCE(s.nome_cidade_exterior, '')", "s.pais", "pa.description",
+		"COALESCE(s.ddd1, '')", "COALESCE(s.telefone1, '')", "COALESCE(s.ddd2, '')", "COALESCE(s.telefone2, '')",
+		"COALESCE(s.ddd_fax, '')", "COALESCE(s.fax, '')", "COALESCE(s.email, '')",
+		"COALESCE(s.situacao_especial, '')", "COALESCE(to_char(s.data_situacao_especial, 'YYYY-MM-DD'), '')",
+	}
+	return s.psql.Select(append(columns, extra...)...).
+		From("cnpj.estabelecimentos s").
+		LeftJoin("dictionaries.motivos mo ON mo.code = s.motivo_situacao").
+		LeftJoin("dictionaries.cnaes cn ON cn.code = s.cnae_principal").
+		LeftJoin("dictionaries.municipios mu ON mu.code = s.municipio").
+		LeftJoin("dictionaries.paises pa ON pa.code = s.pais")
+}
+
+// scanEstabelecimento reads a row of estabelecimentoSelect, scanning its
+// extra columns into extra.
+func scanEstabelecimento(row pgx.CollectableRow, extra ...any) (Estabelecimento, error) {
+	var e Estabelecimento
+	var matriz, situacao *int
+	var motivo, motivoDesc, cnae, cnaeDesc, municipio, municipioDesc, pais, paisDesc *string
+	var secundarios []byte
+	var ddd1, tel1, ddd2, tel2, dddFax string
+	dest := []any{&e.CNPJ, &matriz, &e.NomeFantasia, &situacao, &e.DataSituacao,
+		&motivo, &motivoDesc, &e.DataInicioAtividade, &cnae, &cnaeDesc, &secundarios,
+		&e.Endereco.TipoLogradouro, &e.Endereco.Logradouro, &e.Endereco.Numero,
+		&e.Endereco.Complemento, &e.Endereco.Bairro, &e.Endereco.CEP, &e.Endereco.UF,
+		&municipio, &municipioDesc, &e.Endereco.CidadeExterior, &pais, &paisDesc,
+		&ddd1, &tel1, &ddd2, &tel2, &dddFax, &e.Fax, &e.Email,
+		&e.SituacaoEspecial, &e.DataSituacaoEspecial}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(secundarios, &e.CNAEsSecundarios); err != nil {
+		return e, fmt.Errorf("decode cnaes secundarios: %w", err)
+	}
+	e.MatrizFilial = domain("matriz_filial", matriz)
+	e.SituacaoCadastral = domain("situacao", situacao)
+	e.MotivoSituacao = dictCode(motivo, motivoDesc)
+	e.CNAEPrincipal = dictCode(cnae, cnaeDesc)
+	e.Endereco.Municipio = dictCode(municipio, municipioDesc)
+	e.Endereco.Pais = dictCode(pais, paisDesc)
+	e.Telefones = phones(ddd1, tel1, ddd2, tel2)
+	if e.Fax != "" {
+		e.Fax = joinPhone(dddFax, e.Fax)
+	}
+	return e, nil
+}
+
+func (s *PostgresStore) estabelecimentos(ctx context.Context, basico, cnpj string) ([]Estabelecimento, error) {
+	q := s.estabelecimentoSelect().OrderBy("s.cnpj").Limit(maxEstabelecimentos)
+	if cnpj != "" {
+		q = q.Where(sq.Eq{"s.cnpj": cnpj})
+	} else {
+		q = q.Where(sq.Eq{"s.cnpj_basico": basico})
+	}
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query estabelecimentos: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Estabelecimento, error) {
+		return scanEstabelecimento(row)
+	})
+}
+
+func (s *PostgresStore) socios(ctx context.Context, basico string) ([]Socio, error) {
+	sql, args, err := s.psql.Select(
+		"s.identificador_socio", "COALESCE(s.nome_socio, '')", "COALESCE(s.cnpj_cpf_socio, '')",
+		"s.qualificacao_socio", "qs.description", "COALESCE(to_char(s.data_entrada_sociedade, 'YYYY-MM-DD'), '')",
+		"s.pais", "pa.description", "s.faixa_etaria",
+		"COALESCE(s.cpf_representante_legal, '')", "COALESCE(s.nome_representante_legal, '')",
+		"s.qualificacao_representante", "qr.description",
+	).
+		From("cnpj.socios s").
+		LeftJoin("dictionaries.qualificacoes qs ON qs.code = s.qualificacao_socio").
+		LeftJoin("dictionaries.qualificacoes qr ON qr.code = s.qualificacao_representante").
+		LeftJoin("dictionaries.paises pa ON pa.code = s.pais").
+		Where(sq.Eq{"s.cnpj_basico": basico}).
+		OrderBy("s.nome_socio", "s.id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query socios: %w", err)
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Socio, error) {
+		var so Socio
+		var identificador, faixa *int
+		var qualificacao, qualificacaoDesc, pais, paisDesc, repQualificacao, repQualificacaoDesc *string
+		var rep Representante
+		err := row.Scan(&identificador, &so.Nome, &so.Documento, &qualificacao, &qualificacaoDesc,
+			&so.DataEntrada, &pais, &paisDesc, &faixa, &rep.CPF, &rep.Nome, &repQualificacao, &repQualificacaoDesc)
+		if err != nil {
+			return so, err
+		}
+		so.Identificador = domain("identificador", identificador)
+		so.Qualificacao = dictCode(qualificacao, qualificacaoDesc)
+		so.Pais = dictCode(pais, paisDesc)
+		so.FaixaEtaria = domain("faixa_etaria", faixa)
+		rep.Qualificacao = dictCode(repQualificacao, repQualificacaoDesc)
+		so.Representante = representante(rep)
+		return so, nil
+	})
+}
+
+func (s *PostgresStore) simples(ctx context.Context, basico string) (*Simples, error) {
+	var o Simples
+	err := s.conn.QueryRow(ctx, `SELECT opcao_simples,
+			COALESCE(to_char(data_opcao_simples, 'YYYY-MM-DD'), ''), COALESCE(to_char(data_exclusao_simples, 'YYYY-MM-DD'), ''),
+			opcao_mei,
+			COALESCE(to_char(data_opcao_mei, 'YYYY-MM-DD'), ''), COALESCE(to_char(data_exclusao_mei, 'YYYY-MM-DD'), '')
+		FROM cnpj.simples WHERE cnpj_basico = $1`, basico).
+		Scan(&o.Simples.Optante, &o.Simples.Opcao, &o.Simples.Exclusao, &o.MEI.Optante, &o.MEI.Opcao, &o.MEI.Exclusao)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query simples: %w", err)
+	}
+	return &o, nil
+}
+
+// regimes reads the tax regimes of a company, or of one estabelecimento
+// when cnpj is set, newest year first. Both stores read them from
+// Postgres, the only one they are imported into.
+func regimes(ctx context.Context, conn postgres.DB, basico, cnpj string) ([]RegimeYear, error) {
+	q := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("ano", "cnpj", "cnpj_da_scp", "forma_de_tributacao", "quantidade_de_escrituracoes", "dataset").
+		From("tributario.regimes").
+		OrderBy("ano DESC", "cnpj", "dataset")
+	if cnpj != "" {
+		q = q.Where(sq.Eq{"cnpj": cnpj})
+	} else {
+		// a range keeps the cnpj index usable for a básico prefix
+		q = q.Where(sq.And{sq.GtOrEq{"cnpj": basico + "000000"}, sq.LtOrEq{"cnpj": basico + "999999"}})
+	}
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query regimes: %w", err)
+	}
+	defer rows.Close()
+
+	years := []RegimeYear{}
+	for rows.Next() {
+		var ano int
+		var r Regime
+		if err := rows.Scan(&ano, &r.CNPJ, &r.CNPJDaSCP, &r.FormaDeTributacao, &r.QuantidadeDeEscrituracoes, &r.Dataset); err != nil {
+			return nil, fmt.Errorf("scan regime: %w", err)
+		}
+		if len(years) == 0 || years[len(years)-1].Ano != ano {
+			years = append(years, RegimeYear{Ano: ano})
+		}
+		years[len(years)-1].Regimes = append(years[len(years)-1].Regimes, r)
+	}
+	return years, rows.Err()
+}
@@ -0,0 +1,276 @@
+package lookup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+
+	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/api/models"
+	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/httputil"
+)
+
+func RegisterRoutes(r chi.Router, pg *pgxpool.Pool, store Store, cfg *config.Config, logger zerolog.Logger) {
+	dictionaries := postgres.NewDictionaryRepo(pg)
+	history := postgres.NewHistoryRepo(pg)
+	// the listing filters on indexed columns only Postgres has
+	listing := NewPostgresStore(pg)
+
+	// @Summary List a dictionary
+	// @Description Lists the codes and descriptions of a Receita dictionary, with the codes as published (leading zeros kept)
+	// @Tags dictionaries
+	// @Produce json
+	// @Security BearerAuth
+	// @Param name path string true "Dictionary" Enums(cnaes, motivos, qualificacoes, municipios, paises, naturezas)
+	// @Success 200 {array} ingestion.DictionaryEntry "Dictionary entries ordered by code"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown dictionary"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/dictionaries/{name} [get]
+	r.Get("/dictionaries/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		table, ok := postgres.DictionaryTable(name)
+		if !ok {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown dictionary %q", name))
+			return
+		}
+		entries, err := dictionaries.Entries(r.Context(), table)
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, entries)
+	})
+
+	// @Summary Look up a dictionary code
+	// @Description Returns the description of a code; leading zeros are optional, so 49 finds 0049
+	// @Tags dictionaries
+	// @Produce json
+	// @Security BearerAuth
+	// @Param name path string true "Dictionary" Enums(cnaes, motivos, qualificacoes, municipios, paises, naturezas)
+	// @Param code path string true "Code"
+	// @Success 200 {object} ingestion.DictionaryEntry "Dictionary entry"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown dictionary or code"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/dictionaries/{name}/{code} [get]
+	r.Get("/dictionaries/{name}/{code}", func(w http.ResponseWriter, r *http.Request) {
+		name, code := chi.URLParam(r, "name"), chi.URLParam(r, "code")
+		table, ok := postgres.DictionaryTable(name)
+		if !ok {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown dictionary %q", name))
+			return
+		}
+		entry, err := dictionaries.Entry(r.Context(), table, code)
+		if errors.Is(err, pgx.ErrNoRows) {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("code %q not found in %s", code, name))
+			return
+		}
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, entry)
+	})
+
+	// @Summary Look up a CNPJ
+	// @Description Returns the empresa, its estabelecimentos, sócios, Simples Nacional and MEI options and tax regimes by year, with every code decoded. A full CNPJ narrows estabelecimentos and regimes to that estabelecimento; an 8-digit básico returns all of them. Data comes from the store set by LOOKUP_STORE.
+	// @Tags cnpj
+	// @Produce json
+	// @Security BearerAuth
+	// @Param cnpj path string true "CNPJ or CNPJ básico, with or without punctuation"
+	// @Success 200 {object} lookup.Company "Company"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid CNPJ"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "Unknown CNPJ"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/cnpj/{cnpj} [get]
+	r.Get("/cnpj/{cnpj}", func(w http.ResponseWriter, r *http.Request) {
+		basico, cnpj, err := parseKey(chi.URLParam(r, "cnpj"))
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		c, err := store.Company(r.Context(), basico, cnpj)
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteJSON(w, http.StatusNotFound, models.NotFoundResponse{
+				Error:   "Not Found",
+				Message: fmt.Sprintf("cnpj %s not found", chi.URLParam(r, "cnpj")),
+			})
+			return
+		}
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, c)
+	})
+
+	// @Summary Look up CNPJs in bulk
+	// @Description Looks up a JSON array of CNPJs, or the CNPJ column of a CSV sent as the body or uploaded as the file field of a form, and streams one result per row in input order: invalid (malformed or wrong check digits), not_found, error (the lookup failed, with the reason) or found with the company as GET /v1/cnpj/{cnpj} returns it. CSV results echo the input columns and add the main fields of the company and of the estabelecimento (the matriz, for a básico). Rows are looked up BATCH_LOOKUP_WORKERS at a time and neither input nor output is buffered, so large files stream through. A failed read of the input after the first row ends the output early.
+	// @Tags cnpj
+	// @Accept json
+	// @Accept text/csv
+	// @Accept multipart/form-data
+	// @Produce json
+	// @Produce application/x-ndjson
+	// @Produce text/csv
+	// @Security BearerAuth
+	// @Param cnpjs body []string false "CNPJs or CNPJ básicos, with or without punctuation"
+	// @Param file formData file false "CSV with a header row"
+	// @Param column query string false "CSV column holding the CNPJs, by header name" default(cnpj)
+	// @Param separator query string false "CSV field separator, detected from the header between , and ; when not given"
+	// @Param format query string false "Result format" Enums(json, ndjson, csv) default(json)
+	// @Success 200 {array} lookup.BatchResult "Results in input order"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid body, column or format"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 415 {object} models.ErrorResponse "Unsupported content type"
+	// @Router /v1/cnpj/batch [post]
+	r.Post("/cnpj/batch", func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "ndjson" && format != "csv" {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q", format))
+			return
+		}
+		src, err := parseBatchSource(r)
+		if errors.Is(err, errUnsupportedBatch) {
+			httputil.WriteError(w, http.StatusUnsupportedMediaType, err)
+			return
+		}
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// HTTP/1 handlers otherwise can't read the body once the response
+		// has started
+		_ = http.NewResponseController(w).EnableFullDuplex()
+		out := newBatchWriter(w, format, src)
+		counts := map[string]int{}
+		err = lookupBatch(r.Context(), store, cfg.BatchWorkers, src, func(res BatchResult) error {
+			if err := out.write(res); err != nil {
+				return err
+			}
+			if counts[res.Status]++; res.Row%100 == 0 {
+				if err := out.flush(); err != nil {
+					return err
+				}
+				if fl, ok := w.(http.Flusher); ok {
+					fl.Flush()
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			err = out.finish()
+		}
+		if err != nil {
+			// the status is sent already, so the output just ends early
+			logger.Error().Err(err).Msg("❌ cnpj batch failed")
+			return
+		}
+		logger.Info().Int("found", counts[StatusFound]).Int("not_found", counts[StatusNotFound]).Int("invalid", counts[StatusInvalid]).Int("error", counts[StatusError]).Msg("📋 CNPJ batch looked up")
+	})
+
+	// @Summary Search companies by name
+	// @Description Finds companies whose razão social, nome fantasia or sócio names match the query, ignoring accents and case, and returns ranked summaries. The postgres store matches trigrams, so typos and partial words still match; the mongo store uses a text index, which matches whole words and their stems.
+	// @Tags cnpj
+	// @Produce json
+	// @Security BearerAuth
+	// @Param q query string true "Name or part of a name, at least 3 characters" example(padaria sao jose)
+	// @Param limit query int false "Results per page, up to 100" default(20)
+	// @Param offset query int false "Results to skip" default(0)
+	// @Success 200 {object} lookup.SearchPage "Ranked results"
+	// @Failure 400 {object} models.BadRequestResponse "Query too short or invalid paging"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/search [get]
+	r.Get("/search", func(w http.ResponseWriter, r *http.Request) {
+		q, limit, offset, err := parseSearch(r)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		page, err := store.Search(r.Context(), q, limit, offset)
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, page)
+	})
+
+	// @Summary List estabelecimentos
+	// @Description Lists the estabelecimentos matching every given filter, in CNPJ order. Filters take comma-separated alternatives. CNAE codes may be prefixes (division, group or class) and match the principal CNAE, the secondary ones or either. Pages are cursor based: pass next_cursor as cursor for the next page. total_estimate is the query planner's estimate of the matches. Always reads from Postgres.
+	// @Tags cnpj
+	// @Produce json
+	// @Security BearerAuth
+	// @Param uf query string false "UFs" example(PR)
+	// @Param municipio query string false "Município codes of the municipios dictionary, leading zeros optional" example(7535)
+	// @Param cnae query string false "CNAE codes or prefixes, 2 to 7 digits" example(6201501)
+	// @Param cnae_field query string false "CNAE fields matched" Enums(principal, secundario, any) default(principal)
+	// @Param situacao query string false "Situação cadastral codes" example(2)
+	// @Param porte query string false "Company porte codes" example(1,3)
+	// @Param natureza_juridica query string false "Natureza jurídica codes, leading zeros optional" example(2062)
+	// @Param matriz_filial query string false "1 for matriz, 2 for filial"
+	// @Param inicio_from query string false "Opened on or after (YYYY-MM-DD)" example(2023-01-01)
+	// @Param inicio_to query string false "Opened on or before (YYYY-MM-DD)"
+	// @Param cursor query string false "next_cursor of the previous page"
+	// @Param limit query int false "Page size, up to 1000" default(100)
+	// @Success 200 {object} lookup.EstabelecimentoPage "Page of estabelecimentos"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid filter"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/estabelecimentos [get]
+	r.Get("/estabelecimentos", func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseEstabelecimentoFilter(r)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		page, err := listing.ListEstabelecimentos(r.Context(), f)
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, page)
+	})
+
+	// @Summary CNPJ history
+	// @Description Returns the versions of an estabelecimento (situação cadastral, address, CNAE principal) and of the sócios of its company across the imported batches, with a timeline of what changed in each batch
+	// @Tags cnpj
+	// @Produce json
+	// @Security BearerAuth
+	// @Param cnpj path string true "CNPJ, with or without punctuation"
+	// @Success 200 {object} ingestion.CNPJHistory "History and timeline"
+	// @Failure 400 {object} models.BadRequestResponse "Invalid CNPJ"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 404 {object} models.NotFoundResponse "CNPJ not in any batch"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/cnpj/{cnpj}/history [get]
+	r.Get("/cnpj/{cnpj}/history", func(w http.ResponseWriter, r *http.Request) {
+		cnpj, err := parseCNPJ(chi.URLParam(r, "cnpj"))
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		h, err := history.History(r.Context(), cnpj)
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(h.Estabelecimento) == 0 {
+			httputil.WriteError(w, http.StatusNotFound, fmt.Errorf("cnpj %s not found in the history", cnpj))
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, h)
+	})
+}
@@ -0,0 +1,177 @@
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// trigrams need a few characters to match anything useful
+	minSearchLength    = 3
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Summary is a company found by a search. CNPJ, NomeFantasia, UF,
+// Municipio and SituacaoCadastral describe the matched estabelecimento, or
+// the matriz when the match was on the company. Field and Match tell what
+// matched, when the store knows.
+type Summary struct {
+	CNPJBasico        string  `json:"cnpj_basico" example:"11222333"`
+	CNPJ              string  `json:"cnpj,omitempty" example:"11222333000181"`
+	RazaoSocial       string  `json:"razao_social,omitempty" example:"EMPRESA EXEMPLO LTDA"`
+	NomeFantasia      string  `json:"nome_fantasia,omitempty"`
+	UF                string  `json:"uf,omitempty" example:"PR"`
+	Municipio         *Code   `json:"municipio,omitempty"`
+	SituacaoCadastral *Code   `json:"situacao_cadastral,omitempty"`
+	Field             string  `json:"field,omitempty" example:"razao_social"`
+	Match             string  `json:"match,omitempty" example:"EMPRESA EXEMPLO LTDA"`
+	Score             float64 `json:"score" example:"0.83"`
+}
+
+// SearchPage is a page of results, best first.
+type SearchPage struct {
+	Query   string    `json:"query" example:"empresa exemplo"`
+	Offset  int       `json:"offset"`
+	Limit   int       `json:"limit"`
+	HasMore bool      `json:"has_more"`
+	Results []Summary `json:"results"`
+	Source  string    `json:"source" example:"postgres"`
+}
+
+func newSearchPage(q string, offset, limit int, results []Summary, source string) SearchPage {
+	p := SearchPage{Query: q, Offset: offset, Limit: limit, Results: results, Source: source}
+	if len(p.Results) > limit {
+		p.Results, p.HasMore = p.Results[:limit], true
+	}
+	return p
+}
+
+// parseSearch reads the query and its paging, applying the defaults.
+func parseSearch(r *http.Request) (q string, limit, offset int, err error) {
+	params := r.URL.Query()
+	q = strings.Join(strings.Fields(params.Get("q")), " ")
+	if utf8.RuneCountInString(q) < minSearchLength {
+		return "", 0, 0, fmt.Errorf("q must have at least %d characters", minSearchLength)
+	}
+	limit = defaultSearchLimit
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxSearchLimit {
+			return "", 0, 0, fmt.Errorf("invalid limit %q, expected 1 to %d", v, maxSearchLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return "", 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	return q, limit, offset, nil
+}
+
+// searchQuery ranks companies by the best word similarity of the query to
+// their razão social, the nome fantasia of an estabelecimento or the name
+// of a sócio. Names and query are compared through cnpj.search_name, so
+// accents and case don't matter; <% uses the trigram indexes and keeps
+// matches above pg_trgm.word_similarity_threshold.
+const searchQuery = `WITH hits AS (
+		SELECT cnpj_basico, NULL::text AS cnpj, 'razao_social' AS field, razao_social AS match,
+			word_similarity(cnpj.search_name($1::text), cnpj.search_name(razao_social)) AS score
+		FROM cnpj.empresas
+		WHERE cnpj.search_name($1::text) <% cnpj.search_name(razao_social)
+		UNION ALL
+		SELECT cnpj_basico, cnpj::text, 'nome_fantasia', nome_fantasia,
+			word_similarity(cnpj.search_name($1::text), cnpj.search_name(nome_fantasia))
+		FROM cnpj.estabelecimentos
+		WHERE cnpj.search_name($1::text) <% cnpj.search_name(nome_fantasia)
+		UNION ALL
+		SELECT cnpj_basico, NULL, 'socio', nome_socio,
+			word_similarity(cnpj.search_name($1::text), cnpj.search_name(nome_socio))
+		FROM cnpj.socios
+		WHERE cnpj.search_name($1::text) <% cnpj.search_name(nome_socio)
+	), best AS (
+		SELECT DISTINCT ON (cnpj_basico) cnpj_basico, cnpj, field, match, score
+		FROM hits
+		ORDER BY cnpj_basico, score DESC, field DESC
+	), page AS (
+		SELECT * FROM best ORDER BY score DESC, cnpj_basico LIMIT $2 OFFSET $3
+	)
+	SELECT p.cnpj_basico, COALESCE(s.cnpj, ''), COALESCE(e.razao_social, ''), COALESCE(s.nome_fantasia, ''),
+		COALESCE(s.uf, ''), s.municipio, mu.description, s.situacao_cadastral, p.field, p.match, p.score
+	FROM page p
+	LEFT JOIN cnpj.empresas e ON e.cnpj_basico = p.cnpj_basico
+	LEFT JOIN LATERAL (
+		SELECT cnpj, nome_fantasia, uf, municipio, situacao_cadastral
+		FROM cnpj.estabelecimentos
+		WHERE cnpj_basico = p.cnpj_basico
+		ORDER BY cnpj = p.cnpj DESC NULLS LAST, matriz_filial, cnpj
+		LIMIT 1
+	) s ON true
+	LEFT JOIN dictionaries.municipios mu ON mu.code = s.municipio
+	ORDER BY p.score DESC, p.cnpj_basico`
+
+func (s *PostgresStore) Search(ctx context.Context, q string, limit, offset int) (SearchPage, error) {
+	rows, err := s.conn.Query(ctx, searchQuery, q, limit+1, offset)
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("search: %w", err)
+	}
+	results, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Summary, error) {
+		var r Summary
+		var municipio, municipioDesc *string
+		var situacao *int
+		err := row.Scan(&r.CNPJBasico, &r.CNPJ, &r.RazaoSocial, &r.NomeFantasia, &r.UF,
+			&municipio, &municipioDesc, &situacao, &r.Field, &r.Match, &r.Score)
+		r.Municipio = dictCode(municipio, municipioDesc)
+		r.SituacaoCadastral = domain("situacao", situacao)
+		return r, err
+	})
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("search: %w", err)
+	}
+	return newSearchPage(q, offset, limit, results, "postgres"), nil
+}
+
+// Search uses the text index of the companies collection, which ignores
+// accents and case and matches word stems but not typos.
+func (s *MongoStore) Search(ctx context.Context, q string, limit, offset int) (SearchPage, error) {
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score, "socios": 0, "estabelecimentos": bson.M{"$slice": 1}}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit + 1))
+	cur, err := s.companies.Find(ctx, bson.M{"$text": bson.M{"$search": q}}, opts)
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("search: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	results := []Summary{}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return SearchPage{}, fmt.Errorf("decode company: %w", err)
+		}
+		r := Summary{CNPJBasico: str(doc, "cnpj_basico"), RazaoSocial: str(doc, "razao_social")}
+		r.Score, _ = doc["score"].(float64)
+		if ests, ok := doc["estabelecimentos"].(bson.A); ok && len(ests) > 0 {
+			if e, ok := ests[0].(bson.M); ok {
+				r.CNPJ, r.NomeFantasia, r.UF = str(e, "cnpj"), str(e, "nome_fantasia"), str(e, "uf")
+				r.Municipio = docCode(e, "municipio")
+				r.SituacaoCadastral = domain("situacao", integer(e, "situacao_cadastral"))
+			}
+		}
+		results = append(results, r)
+	}
+	if err := cur.Err(); err != nil {
+		return SearchPage{}, fmt.Errorf("search: %w", err)
+	}
+	return newSearchPage(q, offset, limit, results, "mongo"), nil
+}
@@ -3,68 +3,141 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/BrunoGuimaraesSilva/receitago/config"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/changes"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/database"
+	downloads "github.com/BrunoGuimaraesSilva/receitago/internal/downloader"
 	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/infra/providers"
-	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/usecase/download"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/importer"
+	mongoimport "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/mongo"
 	postgres "github.com/BrunoGuimaraesSilva/receitago/internal/ingestion/postgres"
-	"github.com/BrunoGuimaraesSilva/receitago/pkg/downloader"
-	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage"
-	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage/local"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Pipeline struct {
 	cfg    *config.Config
-	pg     *pgx.Conn
+	pg     *pgxpool.Pool
+	mongo  *mongo.Client
 	logger zerolog.Logger
 }
 
-func NewPipeline(cfg *config.Config, pg *pgx.Conn, logger zerolog.Logger) (*Pipeline, error) {
+// pipelineStep is a step of Run. plan previews it for Plan; without one
+// the step is reported as running.
+type pipelineStep struct {
+	name string
+	desc string
+	run  func(ctx context.Context) error
+	plan stepPlanner
+}
+
+func NewPipeline(cfg *config.Config, pg *pgxpool.Pool, mongo *mongo.Client, logger zerolog.Logger) (*Pipeline, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config is required")
 	}
 	if pg == nil {
-		return nil, fmt.Errorf("postgres connection is required")
+		return nil, fmt.Errorf("postgres pool is required")
+	}
+	if mongo == nil {
+		return nil, fmt.Errorf("mongo client is required")
 	}
 	return &Pipeline{
 		cfg:    cfg,
 		pg:     pg,
+		mongo:  mongo,
 		logger: logger,
 	}, nil
 }
 
-func (p *Pipeline) Run(ctx context.Context) error {
-	start := time.Now()
-	p.logger.Info().Msg("🚀 Starting automated pipeline")
+func (p *Pipeline) steps() []pipelineStep {
+	var dictionaries, regimes []string
+	for _, f := range postgres.DictionaryFiles {
+		dictionaries = append(dictionaries, f.Name)
+	}
+	for _, f := range postgres.RegimeFiles {
+		regimes = append(regimes, f.Name)
+	}
+	isCSV := func(name string) bool { return strings.EqualFold(filepath.Ext(name), ".csv") }
 
-	if err := p.downloadReceita(ctx); err != nil {
-		return fmt.Errorf("download receita: %w", err)
+	steps := []pipelineStep{
+		{"download receita", "Downloading Receita datasets", p.downloadReceita, p.planDownload("receita")},
+		{"download tesouro", "Downloading Tesouro datasets", p.downloadTesouro, p.planDownload("tesouro")},
+		{"import dictionaries", "Importing dictionaries", p.importDictionaries, planImport(namedFiles(p.dictionariesDir(), dictionaries...))},
+		{"import tributario", "Importing tributário", p.importTributario, planImport(namedFiles(p.regimesDir(), regimes...))},
+		{"import tesouro", "Importing Tesouro", p.importTesouro, planImport(matchingFiles(p.tesouroDir(), isCSV, postgres.TesouroFiles))},
 	}
-	if err := p.downloadTesouro(ctx); err != nil {
-		return fmt.Errorf("download tesouro: %w", err)
+	for _, entity := range postgres.CNPJTableNames {
+		table := postgres.CNPJTables[entity]
+		steps = append(steps, pipelineStep{
+			name: "import cnpj " + entity,
+			desc: "Copying " + entity + " into Postgres",
+			run:  func(ctx context.Context) error { return p.importCNPJ(ctx, entity) },
+			plan: planImport(matchingFiles(p.zipsDir(), table.Pattern.MatchString, table.Files)),
+		})
 	}
-	if err := p.importDictionaries(ctx); err != nil {
-		return fmt.Errorf("import dictionaries: %w", err)
+	steps = append(steps,
+		pipelineStep{"snapshot cnpj", "Taking the CNPJ snapshot of the batch", p.snapshotCNPJ, nil},
+		pipelineStep{"diff cnpj", "Comparing the CNPJ batch with the previous one", p.diffCNPJ, nil},
+		pipelineStep{"import history", "Recording the CNPJ batch in the history", p.importHistory, nil},
+	)
+	for _, name := range mongoimport.EntityNames {
+		entity := mongoimport.Entities[name]
+		steps = append(steps, pipelineStep{
+			name: "import mongo " + name,
+			desc: "Importing " + name + " into Mongo",
+			run:  func(ctx context.Context) error { return p.importMongo(ctx, name) },
+			plan: planImport(matchingFiles(p.zipsDir(), entity.Pattern.MatchString, entity.Files)),
+		})
 	}
-	if err := p.importTributario(ctx); err != nil {
-		return fmt.Errorf("import tributario: %w", err)
+	steps = append(steps, pipelineStep{"build mongo companies", "Building Mongo companies", p.buildCompanies, nil})
+	return steps
+}
+
+func (p *Pipeline) Run(ctx context.Context) error {
+	start := time.Now()
+	p.logger.Info().Msg("🚀 Starting automated pipeline")
+	ctx = postgres.WithTrigger(ctx, "pipeline")
+
+	steps := p.steps()
+	for i, st := range steps {
+		p.logger.Info().Msgf("📥 Step %d/%d: %s", i+1, len(steps), st.desc)
+		if err := st.run(ctx); err != nil {
+			return fmt.Errorf("%s: %w", st.name, err)
+		}
 	}
 
 	p.logger.Info().Dur("total_duration", time.Since(start)).Msg("🎉 Pipeline completed successfully")
 	return nil
 }
 
-func (p *Pipeline) downloadReceita(ctx context.Context) error {
-	p.logger.Info().Msg("📥 Step 1/4: Downloading Receita datasets")
+// StepNames lists the steps of a run, in order.
+func (p *Pipeline) StepNames() []string {
+	var names []string
+	for _, st := range p.steps() {
+		names = append(names, st.name)
+	}
+	return names
+}
 
-	provider := providers.NewReceitaProvider(p.cfg.DataDir+"/receita", p.logger)
-	dl := downloader.NewChunkDownloader(downloader.DefaultChunkConfig())
-	fs := storage.NewSmartFilestorer(p.cfg.DataDir, local.LocalFS{}, storage.StdZipReader{})
+// RunStep runs a single step by name, e.g. "import cnpj empresas".
+func (p *Pipeline) RunStep(ctx context.Context, name string) error {
+	for _, st := range p.steps() {
+		if st.name == name {
+			p.logger.Info().Msgf("📥 %s", st.desc)
+			return st.run(ctx)
+		}
+	}
+	return fmt.Errorf("unknown step %q", name)
+}
 
-	uc, err := download.NewInteractor(provider, dl, fs, 3, 5*time.Second)
+func (p *Pipeline) downloadReceita(ctx context.Context) error {
+	uc, err := downloads.NewInteractor("receita", p.cfg, p.logger)
 	if err != nil {
 		return fmt.Errorf("create interactor: %w", err)
 	}
@@ -73,13 +146,7 @@ func (p *Pipeline) downloadReceita(ctx context.Context) error {
 }
 
 func (p *Pipeline) downloadTesouro(ctx context.Context) error {
-	p.logger.Info().Msg("📥 Step 2/4: Downloading Tesouro datasets")
-
-	provider := providers.NewTesouroProvider(p.cfg.DataDir+"/tesouro", p.logger)
-	dl := downloader.NewHTTPDownloader(downloader.DefaultHTTPConfig())
-	fs := storage.NewSmartFilestorer(p.cfg.DataDir, local.LocalFS{}, storage.StdZipReader{})
-
-	uc, err := download.NewInteractor(provider, dl, fs, 2, 2*time.Second)
+	uc, err := downloads.NewInteractor("tesouro", p.cfg, p.logger)
 	if err != nil {
 		return fmt.Errorf("create interactor: %w", err)
 	}
@@ -87,16 +154,168 @@ func (p *Pipeline) downloadTesouro(ctx context.Context) error {
 	return err
 }
 
-func (p *Pipeline) importDictionaries(ctx context.Context) error {
-	p.logger.Info().Msg("📥 Step 3/4: Importing dictionaries")
+// withConn runs an import on a dedicated connection with the import
+// statement timeout.
+func (p *Pipeline) withConn(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	return database.WithConn(ctx, p.pg, p.cfg.PGImportTimeout, fn)
+}
+
+func (p *Pipeline) rejects() importer.Rejects {
+	return importer.Rejects{Dir: p.cfg.QuarantineDir, MaxRatio: p.cfg.MaxRejectedRatio}
+}
+
+func (p *Pipeline) parallel() importer.Parallelism {
+	return importer.Parallelism{Workers: p.cfg.ImportWorkers, MemoryBudget: p.cfg.ImportMemoryBudget}
+}
+
+// reloadOpts loads files in parallel, each worker on its own connection.
+func (p *Pipeline) reloadOpts() postgres.ImportOptions {
+	return postgres.ImportOptions{Rejects: p.rejects(), Parallel: p.parallel(), Conns: p.withConn}
+}
+
+// logReport logs the rejected rows of an import step, if any.
+func (p *Pipeline) logReport(step string, r importer.Report) {
+	if r.Rejected > 0 {
+		p.logger.Warn().Str("step", step).Int("accepted", r.Accepted).Int("rejected", r.Rejected).Str("quarantine", r.Quarantine).Msg("⚠️ Rows rejected")
+	}
+}
 
-	repo := postgres.NewDictionaryRepo(p.pg)
-	return postgres.ImportAllDictionaries(ctx, repo, p.cfg.DataDir+"/zips", p.logger)
+// record runs an import step and records it in the import history.
+func (p *Pipeline) record(ctx context.Context, kind, batch string, fn func() (importer.Summarizer, error)) error {
+	return postgres.RecordImport(ctx, postgres.NewImportRunRepo(p.pg), kind, batch, p.logger, fn)
+}
+
+func (p *Pipeline) importDictionaries(ctx context.Context) error {
+	return p.record(ctx, "dictionaries", p.receitaBatch(), func() (importer.Summarizer, error) {
+		var res postgres.DictionaryImportResult
+		err := p.withConn(ctx, func(conn *pgx.Conn) (err error) {
+			res, err = postgres.ImportAllDictionaries(ctx, postgres.NewDictionaryRepo(conn), p.dictionariesDir(), p.rejects(), p.logger)
+			return err
+		})
+		p.logReport("import dictionaries", res.Report)
+		return res, err
+	})
 }
 
 func (p *Pipeline) importTributario(ctx context.Context) error {
-	p.logger.Info().Msg("📥 Step 4/4: Importing tributário")
+	return p.record(ctx, "tributario", p.receitaBatch(), func() (importer.Summarizer, error) {
+		var res postgres.RegimeReloadResult
+		err := p.withConn(ctx, func(conn *pgx.Conn) (err error) {
+			res, err = postgres.ImportAllRegimes(ctx, postgres.NewTributarioRepo(conn), p.regimesDir(), p.reloadOpts(), p.logger)
+			return err
+		})
+		p.logReport("import tributario", res.Report)
+		return res, err
+	})
+}
 
-	repo := postgres.NewTributarioRepo(p.pg)
-	return postgres.ImportAllRegimes(ctx, repo, p.cfg.DataDir, p.logger)
+func (p *Pipeline) importTesouro(ctx context.Context) error {
+	return p.record(ctx, "tesouro", "", func() (importer.Summarizer, error) {
+		var res postgres.TesouroImportResults
+		err := p.withConn(ctx, func(conn *pgx.Conn) (err error) {
+			res, err = postgres.ImportAllTesouro(ctx, postgres.NewTesouroRepo(conn), p.tesouroDir(), p.logger)
+			return err
+		})
+		return res, err
+	})
+}
+
+func (p *Pipeline) importCNPJ(ctx context.Context, entity string) error {
+	return p.record(ctx, "cnpj "+entity, p.receitaBatch(), func() (importer.Summarizer, error) {
+		var res postgres.CNPJImportResult
+		err := p.withConn(ctx, func(conn *pgx.Conn) (err error) {
+			res, err = postgres.ImportCNPJTable(ctx, postgres.NewCNPJRepo(conn), entity, p.zipsDir(), p.reloadOpts(), p.logger)
+			return err
+		})
+		for _, f := range res.Files {
+			p.logger.Info().Str("entity", entity).Str("file", f.File).Int("rows", f.Rows).Int("skipped", f.Skipped).Msg("Postgres file imported")
+		}
+		p.logReport("import cnpj "+entity, res.Report)
+		return res, err
+	})
+}
+
+func (p *Pipeline) importMongo(ctx context.Context, entity string) error {
+	batch := p.receitaBatch()
+	return p.record(ctx, "mongo "+entity, batch, func() (importer.Summarizer, error) {
+		opts := mongoimport.ImportOptions{Batch: batch, Prune: p.cfg.MongoPrune, Encoding: p.cfg.ReceitaEncoding, Rejects: p.rejects(), Parallel: p.parallel()}
+		res, err := mongoimport.ImportEntity(ctx, p.mongo.Database(p.cfg.MongoDB), entity, p.zipsDir(), opts, p.logger)
+		for _, f := range res.Files {
+			p.logger.Info().Str("entity", entity).Str("file", f.File).Int("rows", f.Rows).Int("skipped", f.Skipped).Interface("conversion_errors", f.ConversionErrors).Msg("Mongo file imported")
+		}
+		p.logReport("import mongo "+entity, res.Report)
+		return res, err
+	})
+}
+
+func (p *Pipeline) snapshotCNPJ(ctx context.Context) error {
+	batch := p.receitaBatch()
+	if batch == "" {
+		p.logger.Warn().Msg("No Receita batch downloaded, skipping snapshot")
+		return nil
+	}
+	return p.withConn(ctx, func(conn *pgx.Conn) error {
+		_, err := changes.NewRepo(conn).Snapshot(ctx, batch, p.cfg.SnapshotsKept, p.logger)
+		return err
+	})
 }
+
+func (p *Pipeline) diffCNPJ(ctx context.Context) error {
+	batch := p.receitaBatch()
+	if batch == "" {
+		return nil
+	}
+	return p.withConn(ctx, func(conn *pgx.Conn) error {
+		repo := changes.NewRepo(conn)
+		prev, err := repo.Previous(ctx, batch)
+		if err != nil {
+			return err
+		}
+		if prev == "" {
+			p.logger.Info().Str("batch", batch).Msg("No earlier snapshot, nothing to compare")
+			return nil
+		}
+		_, err = repo.Diff(ctx, prev, batch, p.logger)
+		return err
+	})
+}
+
+func (p *Pipeline) importHistory(ctx context.Context) error {
+	batch := p.receitaBatch()
+	if batch == "" {
+		p.logger.Warn().Msg("No Receita batch downloaded, skipping history")
+		return nil
+	}
+	return p.record(ctx, "history", batch, func() (importer.Summarizer, error) {
+		var res postgres.HistoryResult
+		err := p.withConn(ctx, func(conn *pgx.Conn) (err error) {
+			res, err = postgres.ApplyHistory(ctx, postgres.NewHistoryRepo(conn), batch, p.logger)
+			return err
+		})
+		return res, err
+	})
+}
+
+func (p *Pipeline) buildCompanies(ctx context.Context) error {
+	return p.record(ctx, "build companies", p.receitaBatch(), func() (importer.Summarizer, error) {
+		var res mongoimport.BuildResult
+		raw, err := postgres.LoadDictionaries(ctx, postgres.NewDictionaryRepo(p.pg))
+		if err != nil {
+			return res, fmt.Errorf("load dictionaries: %w", err)
+		}
+		res, err = mongoimport.BuildCompanies(ctx, p.mongo.Database(p.cfg.MongoDB), mongoimport.NewDictionaries(raw), p.logger)
+		return res, err
+	})
+}
+
+func (p *Pipeline) dictionariesDir() string { return p.zipsDir() }
+
+func (p *Pipeline) zipsDir() string { return p.cfg.DataDir + "/zips" }
+
+func (p *Pipeline) receitaDir() string { return p.cfg.DataDir + "/receita" }
+
+func (p *Pipeline) receitaBatch() string { return providers.LastDownloadedBatch(p.receitaDir()) }
+
+func (p *Pipeline) regimesDir() string { return p.zipsDir() }
+
+func (p *Pipeline) tesouroDir() string { return p.cfg.DataDir + "/tesouro" }
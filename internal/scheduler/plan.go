@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	downloads "github.com/BrunoGuimaraesSilva/receitago/internal/downloader"
+	"github.com/BrunoGuimaraesSilva/receitago/internal/downloader/usecase/download"
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/storage"
+)
+
+// StepPlan describes what a pipeline step would do. Download steps carry the
+// provider plan; import steps list the files they would read and the ones
+// that would still be missing after the downloads.
+type StepPlan struct {
+	Name     string         `json:"name"`
+	WillRun  bool           `json:"will_run"`
+	Reason   string         `json:"reason,omitempty"`
+	Download *download.Plan `json:"download,omitempty"`
+	Files    []string       `json:"files,omitempty"`
+	Missing  []string       `json:"missing,omitempty"`
+}
+
+type PipelinePlan struct {
+	Steps              []StepPlan `json:"steps"`
+	DownloadBytes      int64      `json:"download_bytes"`
+	EstimatedDiskBytes int64      `json:"estimated_disk_bytes"`
+}
+
+// dryRun is what a plan carries from one step to the next: where downloads
+// land, the files they would bring and the step the pipeline stops at.
+type dryRun struct {
+	fs        *storage.SmartFilestorer
+	incoming  map[string]bool
+	stoppedAt string
+}
+
+// stepPlanner previews a pipeline step without running it.
+type stepPlanner func(ctx context.Context, dr *dryRun) (StepPlan, error)
+
+// Plan runs the pipeline in dry-run mode: providers are listed and checked
+// against the ledger and the data directory, but nothing is downloaded,
+// written or imported. It walks the same steps as Run; a step without a
+// planner is reported as running.
+func (p *Pipeline) Plan(ctx context.Context) (PipelinePlan, error) {
+	var plan PipelinePlan
+	dr := &dryRun{fs: downloads.NewFilestorer(p.cfg), incoming: map[string]bool{}}
+
+	for _, st := range p.steps() {
+		step := StepPlan{WillRun: true}
+		switch {
+		case dr.stoppedAt != "":
+			step = StepPlan{Reason: "pipeline stops at " + dr.stoppedAt}
+		case st.plan != nil:
+			var err error
+			if step, err = st.plan(ctx, dr); err != nil {
+				return plan, fmt.Errorf("plan %s: %w", st.name, err)
+			}
+		}
+		step.Name = st.name
+		if d := step.Download; d != nil && d.Blocked == "" {
+			plan.DownloadBytes += d.DownloadBytes
+			plan.EstimatedDiskBytes += d.EstimatedDiskBytes
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// planDownload previews a provider's download. A failed or blocked
+// download stops the pipeline.
+func (p *Pipeline) planDownload(name string) stepPlanner {
+	return func(ctx context.Context, dr *dryRun) (StepPlan, error) {
+		step := StepPlan{Name: "download " + name, WillRun: true}
+		uc, err := downloads.NewInteractor(name, p.cfg, p.logger)
+		if err != nil {
+			return step, fmt.Errorf("create interactor: %w", err)
+		}
+		dp, err := uc.Plan(ctx)
+		switch {
+		case err != nil:
+			step.Reason = err.Error()
+			dr.stoppedAt = step.Name
+		case dp.Blocked != "":
+			step.Reason = dp.Blocked
+			step.Download = &dp
+			dr.stoppedAt = step.Name
+		default:
+			step.Download = &dp
+			for _, it := range dp.Items {
+				if it.Action == download.ActionFetch {
+					dr.incoming[dr.fs.Path(it.Filename)] = true
+				}
+			}
+		}
+		return step, nil
+	}
+}
+
+// planImport previews an import step reading the files listed by files,
+// whether they are on disk or still to be downloaded.
+func planImport(files func(dr *dryRun) ([]string, error)) stepPlanner {
+	return func(ctx context.Context, dr *dryRun) (StepPlan, error) {
+		var step StepPlan
+		paths, err := files(dr)
+		if err != nil {
+			return step, err
+		}
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil || dr.incoming[path] {
+				step.Files = append(step.Files, path)
+			} else {
+				step.Missing = append(step.Missing, path)
+			}
+		}
+		step.WillRun = true
+		if len(step.Files) == 0 {
+			step.Reason = "no input files, step would import nothing"
+		}
+		return step, nil
+	}
+}
+
+// namedFiles lists the files of an import reading fixed names in dir.
+func namedFiles(dir string, names ...string) func(dr *dryRun) ([]string, error) {
+	return func(dr *dryRun) ([]string, error) {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, name)
+		}
+		return paths, nil
+	}
+}
+
+// matchingFiles lists the files of an import reading whatever list finds
+// in dir, plus the downloads landing there that match.
+func matchingFiles(dir string, match func(name string) bool, list func(dir string) ([]string, error)) func(dr *dryRun) ([]string, error) {
+	return func(dr *dryRun) ([]string, error) {
+		files, err := list(dir)
+		if err != nil {
+			return nil, fmt.Errorf("list files: %w", err)
+		}
+		return withIncoming(files, dr.incoming, dir, match), nil
+	}
+}
+
+// withIncoming adds the downloads landing in dir whose name matches to files.
+func withIncoming(files []string, incoming map[string]bool, dir string, match func(name string) bool) []string {
+	for path := range incoming {
+		if filepath.Dir(path) == filepath.Clean(dir) && match(filepath.Base(path)) && !slices.Contains(files, path) {
+			files = append(files, path)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
@@ -0,0 +1,29 @@
+package scheduler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/BrunoGuimaraesSilva/receitago/pkg/httputil"
+)
+
+func RegisterRoutes(r chi.Router, pipeline *Pipeline) {
+	// @Summary Preview a pipeline run
+	// @Description Lists the downloads and import steps the scheduled pipeline would run, without touching files or databases
+	// @Tags pipeline
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {object} scheduler.PipelinePlan "Pipeline plan"
+	// @Failure 401 {object} models.UnauthorizedResponse "Missing or invalid token"
+	// @Failure 500 {object} models.ErrorResponse "Internal server error"
+	// @Router /v1/plan/pipeline [get]
+	r.Get("/plan/pipeline", func(w http.ResponseWriter, r *http.Request) {
+		plan, err := pipeline.Plan(r.Context())
+		if err != nil {
+			httputil.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, plan)
+	})
+}
@@ -0,0 +1,184 @@
+package csvx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type Encoding string
+
+const (
+	UTF8        Encoding = "utf-8"
+	ISO88591    Encoding = "iso-8859-1"
+	Windows1252 Encoding = "windows-1252"
+	// Auto detects the encoding from the start of the input.
+	Auto Encoding = "auto"
+)
+
+// sniffSize is how much of a file is inspected to guess its format.
+const sniffSize = 64 * 1024
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Format is the detected shape of a delimited text file.
+type Format struct {
+	Encoding Encoding `json:"encoding"`
+	Comma    rune     `json:"comma"`
+}
+
+// ParseEncoding accepts the usual spellings of the supported encodings.
+func ParseEncoding(s string) (Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "utf-8", "utf8":
+		return UTF8, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		return ISO88591, nil
+	case "windows-1252", "cp1252":
+		return Windows1252, nil
+	case "auto":
+		return Auto, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q", s)
+}
+
+// DetectEncoding guesses the encoding of sample. A BOM or valid UTF-8 with
+// multi-byte runes means UTF-8; bytes in 0x80-0x9F, which are control codes
+// in ISO-8859-1, point to Windows-1252. A plain ASCII sample says nothing,
+// so it falls back to ISO-8859-1, the encoding of government exports.
+func DetectEncoding(sample []byte) Encoding {
+	if bytes.HasPrefix(sample, utf8BOM) {
+		return UTF8
+	}
+	ascii := true
+	for _, b := range sample {
+		if b >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return ISO88591
+	}
+	// the sample may end in the middle of a multi-byte rune
+	for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(sample[i]) {
+			if !utf8.FullRune(sample[i:]) {
+				sample = sample[:i]
+			}
+			break
+		}
+	}
+	if utf8.Valid(sample) {
+		return UTF8
+	}
+	for _, b := range sample {
+		if b >= 0x80 && b <= 0x9F {
+			return Windows1252
+		}
+	}
+	return ISO88591
+}
+
+// DetectComma picks the most frequent candidate delimiter in the first line.
+func DetectComma(sample []byte) rune {
+	line, _, _ := bytes.Cut(sample, []byte("\n"))
+	best, bestCount := ',', 0
+	for _, c := range []rune{';', ',', '\t', '|'} {
+		if n := bytes.Count(line, []byte(string(c))); n > bestCount {
+			best, bestCount = c, n
+		}
+	}
+	return best
+}
+
+// Decode returns a reader producing UTF-8 from r, dropping a UTF-8 BOM.
+func Decode(r io.Reader, enc Encoding) io.Reader {
+	switch enc {
+	case ISO88591:
+		return transform.NewReader(r, charmap.ISO8859_1.NewDecoder())
+	case Windows1252:
+		return transform.NewReader(r, charmap.Windows1252.NewDecoder())
+	}
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
+	}
+	return br
+}
+
+// DecodeAuto detects the encoding of r from its start and returns it with
+// a reader that yields the whole content decoded to UTF-8.
+func DecodeAuto(r io.Reader) (Encoding, io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	sample, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", nil, fmt.Errorf("peek: %w", err)
+	}
+	enc := DetectEncoding(sample)
+	return enc, Decode(br, enc), nil
+}
+
+// Sniff inspects the start of r and returns its format together with a
+// reader that yields the whole content decoded to UTF-8.
+func Sniff(r io.Reader) (Format, io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	sample, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return Format{}, nil, fmt.Errorf("peek: %w", err)
+	}
+	f := Format{Encoding: DetectEncoding(sample), Comma: DetectComma(sample)}
+	return f, Decode(br, f.Encoding), nil
+}
+
+// NewReader returns a csv.Reader configured for the lenient quoting found in
+// government exports.
+func NewReader(r io.Reader, comma rune) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return reader
+}
+
+// Unaccent strips diacritics, so "Razão Social" becomes "Razao Social".
+func Unaccent(s string) string {
+	// chains keep state, so each call builds its own
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	out, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return out
+}
+
+// RepairMojibake fixes text that was stored with the wrong encoding: invalid
+// UTF-8 is raw Latin-1 and gets decoded, and valid UTF-8 that was decoded
+// twice ("RazÃ£o") is re-encoded once. ok reports whether s changed.
+func RepairMojibake(s string) (string, bool) {
+	if !utf8.ValidString(s) {
+		out, err := charmap.Windows1252.NewDecoder().String(s)
+		if err != nil {
+			return s, false
+		}
+		return out, out != s
+	}
+	if !strings.ContainsAny(s, "ÃÂ") {
+		return s, false
+	}
+	raw, err := charmap.Windows1252.NewEncoder().String(s)
+	if err != nil || !utf8.ValidString(raw) {
+		return s, false
+	}
+	return raw, raw != s
+}
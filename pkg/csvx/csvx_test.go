@@ -0,0 +1,30 @@
+package csvx
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample []byte
+		want   Encoding
+	}{
+		{"empty", nil, ISO88591},
+		{"ascii only", []byte(`"00000000";"BANCO DO BRASIL SA";"2046"` + "\n"), ISO88591},
+		{"ascii head of a long file", bytes.Repeat([]byte("\"12345678\";\"EMPRESA LTDA\"\n"), 3000), ISO88591},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "cnpj;nome\n"...), UTF8},
+		{"utf-8", []byte("\"SÃO PAULO\";\"AÇÚCAR\"\n"), UTF8},
+		{"utf-8 cut mid rune", []byte("\"SÃO PAULO\";\"A\xc3"), UTF8},
+		{"iso-8859-1", []byte("\"S\xc3O PAULO\";\"A\xc7\xdaCAR\"\n"), ISO88591},
+		{"windows-1252", []byte("\"\x93ASPAS\x94\";\"S\xc3O\"\n"), Windows1252},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectEncoding(tt.sample); got != tt.want {
+				t.Errorf("DetectEncoding() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
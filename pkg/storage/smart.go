@@ -33,6 +33,14 @@ func (s *SmartFilestorer) Save(ctx context.Context, name string, r iox.ReadSeekC
 	return s.saveRaw(ctx, name, r)
 }
 
+// Path returns where Save stores name: zips under zips/, anything else under tesouro/.
+func (s *SmartFilestorer) Path(name string) string {
+	if strings.ToLower(filepath.Ext(name)) == ".zip" {
+		return filepath.Join(s.BaseDir, "zips", name)
+	}
+	return filepath.Join(s.BaseDir, "tesouro", name)
+}
+
 func (s *SmartFilestorer) saveRaw(ctx context.Context, name string, r iox.ReadSeekCloser) error {
 	if _, err := r.Seek(0, 0); err != nil {
 		return err